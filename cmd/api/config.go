@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/dispatch"
+	"safedeal-backend-trainee/internal/earnings"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/idempotency"
+	"safedeal-backend-trainee/internal/pricing"
+	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/ratelimit"
+	"safedeal-backend-trainee/internal/rating"
+	"safedeal-backend-trainee/internal/resp"
+	"safedeal-backend-trainee/internal/routing"
+	"safedeal-backend-trainee/internal/shift"
+	"safedeal-backend-trainee/internal/slot"
+	"safedeal-backend-trainee/internal/surge"
+
+	"github.com/pkg/errors"
+)
+
+// configuration - настройки сервиса, которые хранятся в том же файле,
+// что и настройки подключения к БД
+type configuration struct {
+	Geo      geo.Configuration      `json:"geo"`
+	Pricing  pricing.Configuration  `json:"pricing"`
+	Surge    surge.Configuration    `json:"surge"`
+	Slots    slot.Configuration     `json:"slots"`
+	Routing  routing.Configuration  `json:"routing"`
+	Dispatch dispatch.Configuration `json:"dispatch"`
+	Shifts   shift.Configuration    `json:"shifts"`
+	Earnings earnings.Configuration `json:"earnings"`
+	Ratings  rating.Configuration   `json:"ratings"`
+	// KV - сервер с протоколом Redis для счетчиков ограничения запросов и общего кэша товаров
+	KV resp.Configuration `json:"kv"`
+	// ClientIP - прокси перед сервисом, через которые определяется IP-адрес клиента
+	ClientIP clientip.Configuration `json:"client_ip"`
+	// RateLimits - правила ограничения числа запросов клиентов
+	RateLimits ratelimit.Configuration `json:"rate_limits"`
+	// ProductCache - кэш товаров, работает только с хранилищем postgres
+	ProductCache product.CacheConfiguration `json:"product_cache"`
+	// Idempotency - хранение ответов на создание заказа с заголовком Idempotency-Key
+	Idempotency idempotency.Configuration `json:"idempotency"`
+	// Cities - рабочие календари городов, город зоны доставки задается в geo.zones
+	Cities      map[string]ftime.CalendarConfiguration `json:"cities"`
+	DefaultCity string                                 `json:"default_city"`
+}
+
+func parseConfig(filename string) (*configuration, error) {
+	byteData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read input json file: "+filename)
+	}
+
+	c := configuration{
+		Geo:      geo.DefaultConfiguration,
+		Pricing:  pricing.DefaultConfiguration,
+		Surge:    surge.DefaultConfiguration,
+		Slots:    slot.DefaultConfiguration,
+		Routing:  routing.DefaultConfiguration,
+		Dispatch: dispatch.DefaultConfiguration,
+		Shifts:   shift.DefaultConfiguration,
+		Earnings: earnings.DefaultConfiguration,
+		Ratings:  rating.DefaultConfiguration,
+
+		Idempotency:  idempotency.DefaultConfiguration,
+		ProductCache: product.DefaultCacheConfiguration,
+		KV:           resp.DefaultConfiguration,
+		RateLimits:   ratelimit.DefaultConfiguration,
+	}
+
+	err = json.Unmarshal(byteData, &c)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal json with configuration")
+	}
+
+	resolveHolidays(c.Cities, filepath.Dir(filename))
+
+	return &c, nil
+}
+
+// resolveHolidays делает относительные пути к файлам с праздниками путями от каталога
+// файла конфигурации, чтобы они не зависели от рабочего каталога процесса
+func resolveHolidays(cities map[string]ftime.CalendarConfiguration, dir string) {
+	for city, c := range cities {
+		if c.Holidays == "" || filepath.IsAbs(c.Holidays) {
+			continue
+		}
+
+		c.Holidays = filepath.Join(dir, c.Holidays)
+		cities[city] = c
+	}
+}
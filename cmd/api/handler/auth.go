@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ehttp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// authenticate кладет в контекст запроса владельца API-ключа из заголовка
+// Authorization: Bearer <key>. Запросы без заголовка считаются анонимными.
+// Неудачные попытки учитываются по IP-адресу клиента, и после исчерпания лимита ключи с этого адреса
+// не проверяются, чтобы их нельзя было подбирать: rateLimit выполняется после authenticate
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" || h.authStorage == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rule, client := h.limiter.FailedAuth(), "ip:"+clientip.FromContext(r.Context())
+		if rule != nil {
+			d, err := h.limiter.Check(r.Context(), rule, client)
+			if err != nil {
+				h.logger.Errorf("can't check rate limit %q: %v", rule.Name, err)
+			} else if !d.Allowed {
+				respondTooManyRequests(w, r, d, h.logger)
+				return
+			}
+		}
+
+		p, err := h.principal(r.Context(), header)
+		if err != nil {
+			if rule != nil && ehttp.FromError(err).StatusCode == http.StatusUnauthorized {
+				if err := h.limiter.Count(r.Context(), rule, client); err != nil {
+					h.logger.Errorf("can't count failed authentication: %v", err)
+				}
+			}
+
+			respondError(w, r, err, h.logger)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), p)))
+	})
+}
+
+func (h *Handler) principal(ctx context.Context, header string) (*auth.Principal, error) {
+	const prefix = "Bearer "
+
+	if !strings.HasPrefix(header, prefix) {
+		msg := "authorization header must contain bearer token"
+		return nil, ehttp.UnauthorizedErr(msg, msg)
+	}
+
+	key := strings.TrimSpace(strings.TrimPrefix(header, prefix))
+
+	p, err := h.authStorage.FindByKey(ctx, key)
+	if errors.Is(err, domain.ErrNotFound) {
+		msg := "invalid api key"
+		return nil, ehttp.UnauthorizedErr(msg, msg)
+	}
+
+	if err != nil {
+		detail := fmt.Sprintf("can't find api key: %v", err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	return p, nil
+}
+
+// requestBuyer возвращает покупателя, от имени которого выполняется запрос. Покупатель определяется
+// только по API-ключу с ролью buyer, поле buyer из тела запроса должно с ним совпадать.
+// Для анонимного запроса возвращается пустая строка
+func requestBuyer(ctx context.Context, declared string) (string, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok || !p.HasRole(auth.Buyer) {
+		if declared == "" {
+			return "", nil
+		}
+
+		msg := "buyer requires api key with role buyer"
+		if ok {
+			return "", ehttp.ForbiddenErr(msg, msg)
+		}
+
+		return "", ehttp.UnauthorizedErr(msg, msg)
+	}
+
+	if declared != "" && declared != p.BuyerID() {
+		msg := "buyer does not match api key"
+		detail := fmt.Sprintf("%v: buyer= %q, api key buyer= %q", msg, declared, p.BuyerID())
+
+		return "", ehttp.ForbiddenErr(msg, detail)
+	}
+
+	return p.BuyerID(), nil
+}
+
+// requireRole пропускает только запросы, выполненные владельцем ключа с одной из ролей
+func (h *Handler) requireRole(roles ...auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := auth.FromContext(r.Context())
+			if !ok {
+				msg := "authentication required"
+				respondError(w, r, ehttp.UnauthorizedErr(msg, msg), h.logger)
+
+				return
+			}
+
+			if !p.HasRole(roles...) {
+				msg := fmt.Sprintf("role %q has no access to this resource", p.Role)
+				respondError(w, r, ehttp.ForbiddenErr(msg, msg), h.logger)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/ehttp"
+)
+
+// getProductCacheStats возвращает число попаданий и промахов кэша товаров
+func (h *Handler) getProductCacheStats(w http.ResponseWriter, r *http.Request) error {
+	if h.productCache == nil {
+		msg := "product cache is not configured"
+		return ehttp.FeatureDisabledErr(msg)
+	}
+
+	err := respondJSON(w, h.productCache.Stats())
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with product cache stats: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+	"safedeal-backend-trainee/internal/clientip"
+)
+
+// resolveClientIP сохраняет в контексте запроса IP-адрес клиента. По нему ограничиваются
+// запросы без API-ключа, и он записывается в журнал ошибок и журнал назначений
+func (h *Handler) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := clientip.NewContext(r.Context(), h.clientIP.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/routing"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// currentCourier возвращает курьера, которому принадлежит API-ключ запроса
+func (h *Handler) currentCourier(r *http.Request) (*courier.Courier, error) {
+	p, ok := auth.FromContext(r.Context())
+	if !ok || p.Role != auth.Courier {
+		msg := "authentication required"
+		return nil, ehttp.UnauthorizedErr(msg, msg)
+	}
+
+	c, err := h.courierStorage.FindByID(r.Context(), p.SubjectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get courier of api key %v", p.ID)
+	}
+
+	return c, nil
+}
+
+type routeStop struct {
+	OrderID    int64             `json:"order_id"`
+	Kind       routing.Kind      `json:"kind"`
+	Address    string            `json:"address"`
+	Location   geo.Point         `json:"location"`
+	Arrival    ftime.FormatTime  `json:"arrival"`
+	WindowFrom *ftime.FormatTime `json:"window_from,omitempty"`
+	WindowTo   *ftime.FormatTime `json:"window_to,omitempty"`
+	Wait       ftime.Duration    `json:"wait"`
+	Late       ftime.Duration    `json:"late"`
+}
+
+// getRoute строит маршрут курьера по назначенным ему заказам на сегодня
+// (день определяется по календарю города зоны курьера)
+func (h *Handler) getRoute(w http.ResponseWriter, r *http.Request) error {
+	if h.routing == nil {
+		msg := "routing is not configured"
+		return ehttp.FeatureDisabledErr(msg)
+	}
+
+	c, err := h.currentCourier(r)
+	if err != nil {
+		return err
+	}
+
+	cal := h.calendar(c.Zone)
+
+	start, route, err := h.planDay(r.Context(), c, h.now())
+	if err != nil {
+		return err
+	}
+
+	out := make([]*routeStop, 0, len(route.Visits))
+
+	for _, v := range route.Visits {
+		s := &routeStop{
+			OrderID:  v.OrderID,
+			Kind:     v.Kind,
+			Address:  v.Address,
+			Location: v.Point,
+			Arrival:  ftime.FormatTime{Time: cal.In(v.Arrival).Round(time.Second)},
+			Wait:     ftime.Duration{Duration: v.Wait.Round(time.Second)},
+			Late:     ftime.Duration{Duration: v.Late.Round(time.Second)},
+		}
+
+		if !v.From.IsZero() {
+			s.WindowFrom = ftime.New(cal.In(v.From))
+		}
+
+		if !v.To.IsZero() {
+			s.WindowTo = ftime.New(cal.In(v.To))
+		}
+
+		out = append(out, s)
+	}
+
+	err = respondJSON(w, struct {
+		CourierID int64          `json:"courier_id"`
+		Start     geo.Point      `json:"start"`
+		Duration  ftime.Duration `json:"duration"`
+		Late      ftime.Duration `json:"late"`
+		Stops     []*routeStop   `json:"stops"`
+	}{
+		CourierID: c.ID,
+		Start:     start,
+		Duration:  ftime.Duration{Duration: route.Duration.Round(time.Second)},
+		Late:      ftime.Duration{Duration: route.Late.Round(time.Second)},
+		Stops:     out,
+	})
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with courier's route: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// planDay строит маршрут курьера по назначенным ему на сегодня заказам, начиная
+// с момента now и последнего известного местоположения курьера (без него - с первой точки)
+func (h *Handler) planDay(ctx context.Context, c *courier.Courier, now time.Time) (geo.Point, *routing.Route, error) {
+	var start geo.Point
+
+	now = h.calendar(c.Zone).In(now)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	orders, err := h.orderStorage.FindByCourier(ctx, c.ID, dayStart, dayStart.AddDate(0, 0, 1))
+	if err != nil {
+		detail := fmt.Sprintf("can't find orders of courier with id= %v: %v", c.ID, err)
+		return start, nil, ehttp.InternalServerErr(detail)
+	}
+
+	key := routeKey(c, orders)
+	if start, route, ok := h.routes.get(c.ID, key, now); ok {
+		return start, route, nil
+	}
+
+	stops, err := h.routeStops(orders)
+	if err != nil {
+		return start, nil, err
+	}
+
+	switch {
+	case c.Location != nil:
+		start = *c.Location
+	case len(stops) > 0:
+		start = stops[0].Point
+	}
+
+	route := h.routing.Plan(start, now, c.Vehicle, stops)
+	h.routes.put(c.ID, key, start, route, now)
+
+	return start, route, nil
+}
+
+// routeTTL - сколько используется построенный маршрут курьера, если его заказы и местоположение
+// не менялись. ETA в деталях заказа не перестраивает маршрут на каждый запрос, но отстает
+// от текущего момента не больше чем на routeTTL
+const routeTTL = 30 * time.Second
+
+// routeCache хранит последний построенный маршрут каждого курьера
+type routeCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	routes map[int64]*cachedRoute
+}
+
+type cachedRoute struct {
+	key     string
+	start   geo.Point
+	route   *routing.Route
+	expires time.Time
+}
+
+func newRouteCache(ttl time.Duration) *routeCache {
+	return &routeCache{ttl: ttl, routes: make(map[int64]*cachedRoute)}
+}
+
+func (rc *routeCache) get(courierID int64, key string, now time.Time) (geo.Point, *routing.Route, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	r, ok := rc.routes[courierID]
+	if !ok || r.key != key || !now.Before(r.expires) {
+		return geo.Point{}, nil, false
+	}
+
+	return r.start, r.route, true
+}
+
+func (rc *routeCache) put(courierID int64, key string, start geo.Point, route *routing.Route, now time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.routes[courierID] = &cachedRoute{key: key, start: start, route: route, expires: now.Add(rc.ttl)}
+}
+
+// routeKey меняется вместе с местоположением курьера и версиями его заказов
+func routeKey(c *courier.Courier, orders []*order.Order) string {
+	var b strings.Builder
+
+	if c.Location != nil {
+		fmt.Fprintf(&b, "%v,%v;", c.Location.Lat, c.Location.Lon)
+	}
+
+	for _, o := range orders {
+		fmt.Fprintf(&b, "%d:%d;", o.ID, o.Version)
+	}
+
+	return b.String()
+}
+
+// estimate возвращает ETA назначенного заказа по текущему маршруту курьера. Маршрут
+// строится от последнего местоположения курьера, поэтому ETA обновляется с каждым
+// его сообщением. Для заказа без курьера или вне маршрута ETA нет
+func (h *Handler) estimate(ctx context.Context, o *order.Order) (*routing.Estimate, error) {
+	if h.routing == nil || o.CourierID == 0 || o.Status != order.Assigned {
+		return nil, nil
+	}
+
+	c, err := h.courierStorage.FindByID(ctx, o.CourierID)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		detail := fmt.Sprintf("can't find courier with id= %v: %v", o.CourierID, err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	now := h.now()
+
+	_, route, err := h.planDay(ctx, c, now)
+	if err != nil {
+		return nil, err
+	}
+
+	eta, ok := h.routing.Estimate(route, o.ID, c.LocatedAt, now)
+	if !ok {
+		return nil, nil
+	}
+
+	return eta, nil
+}
+
+// routeStops возвращает точки забора и доставки недоставленных заказов, для заказов, которые
+// курьер уже везет, - только точки доставки. Окно доставки - интервал [time, time_to],
+// для заказа без интервала курьер должен успеть ко времени доставки
+func (h *Handler) routeStops(orders []*order.Order) ([]*routing.Stop, error) {
+	stops := make([]*routing.Stop, 0, 2*len(orders))
+
+	for _, o := range orders {
+		if o.Status == order.Delivered {
+			continue
+		}
+
+		to, err := h.geocoder.Geocode(o.Destination)
+		if err != nil {
+			detail := fmt.Sprintf("can't geocode destination of order with id= %v: %v", o.ID, err)
+			return nil, ehttp.InternalServerErr(detail)
+		}
+
+		dropoff := &routing.Stop{OrderID: o.ID, Kind: routing.Dropoff, Address: o.Destination, Point: to}
+
+		if o.Time != nil {
+			dropoff.From, dropoff.To = o.Time.Time, o.Time.Time
+		}
+
+		if o.TimeTo != nil {
+			dropoff.To = o.TimeTo.Time
+		}
+
+		if !carried(o) {
+			from, err := h.geocoder.Geocode(o.From)
+			if err != nil {
+				detail := fmt.Sprintf("can't geocode pickup place of order with id= %v: %v", o.ID, err)
+				return nil, ehttp.InternalServerErr(detail)
+			}
+
+			stops = append(stops, &routing.Stop{OrderID: o.ID, Kind: routing.Pickup, Address: o.From, Point: from})
+		}
+
+		stops = append(stops, dropoff)
+	}
+
+	return stops, nil
+}
+
+// carried проверяет, что курьер уже забрал заказ
+func carried(o *order.Order) bool {
+	return o.PickedUpAt != nil || o.ArrivedAt != nil
+}
+
+// pickUpOrder отмечает, что курьер забрал заказ: точка забора пропадает из его маршрута
+func (h *Handler) pickUpOrder(w http.ResponseWriter, r *http.Request) error {
+	if h.routing == nil {
+		msg := "routing is not configured"
+		return ehttp.FeatureDisabledErr(msg)
+	}
+
+	c, o, err := h.courierOrder(r)
+	if err != nil {
+		return err
+	}
+
+	version, err := matchVersion(r, o)
+	if err != nil {
+		return err
+	}
+
+	err = h.courierStorage.PickUp(r.Context(), o.ID, c.ID, version, h.now())
+	if err == courier.ErrNotAssigned {
+		msg := fmt.Sprintf("order with id= %v is not waiting for pickup", o.ID)
+		return ehttp.ConflictErr(msg, msg)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "can't mark pickup for order with id= %v", o.ID)
+	}
+
+	w.Header().Set("ETag", etag(version+1))
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// updateLocation сохраняет текущее местоположение курьера, от которого строится маршрут
+func (h *Handler) updateLocation(w http.ResponseWriter, r *http.Request) error {
+	c, err := h.currentCourier(r)
+	if err != nil {
+		return err
+	}
+
+	var p geo.Point
+
+	if err = json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return ehttp.JSONUnmarshalErr(err)
+	}
+
+	if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+		msg := "location must contain valid lat and lon"
+		return ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	if err = h.courierStorage.UpdateLocation(r.Context(), c.ID, p, h.now()); err != nil {
+		detail := fmt.Sprintf("can't update location of courier with id= %v: %v", c.ID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/dispatch"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/validation"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+)
+
+func dispatchDisabledErr() error {
+	msg := "dispatch is not configured"
+	return ehttp.FeatureDisabledErr(msg)
+}
+
+func orderIDFromURL(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= BottomLineValidID {
+		return -1, ehttp.IncorrectID(id)
+	}
+
+	return id, nil
+}
+
+// getAssignments возвращает журнал назначений заказа
+func (h *Handler) getAssignments(w http.ResponseWriter, r *http.Request) error {
+	if h.dispatchStorage == nil {
+		return dispatchDisabledErr()
+	}
+
+	orderID, err := orderIDFromURL(r)
+	if err != nil {
+		return err
+	}
+
+	history, err := h.dispatchStorage.History(r.Context(), orderID)
+	if err != nil {
+		detail := fmt.Sprintf("can't get assignments of order with id= %v: %v", orderID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	err = respondJSON(w, history)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with assignments: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// deleteAssignment снимает курьера с заказа. Заказ возвращается диспетчеру,
+// который не назначит его тому же курьеру повторно
+func (h *Handler) deleteAssignment(w http.ResponseWriter, r *http.Request) error {
+	if h.dispatchStorage == nil {
+		return dispatchDisabledErr()
+	}
+
+	orderID, err := orderIDFromURL(r)
+	if err != nil {
+		return err
+	}
+
+	var in struct {
+		Reason string `json:"reason"`
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+		return ehttp.JSONUnmarshalErr(err)
+	}
+
+	var v validation.Validator
+
+	v.MaxLength("reason", in.Reason, maxReason)
+
+	if err = v.Err(); err != nil {
+		return err
+	}
+
+	version, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+
+	a := &dispatch.Assignment{
+		OrderID: orderID,
+		Action:  dispatch.Unassign,
+		Actor:   actor(r),
+		Reason:  in.Reason,
+		IP:      clientip.FromContext(r.Context()),
+	}
+
+	err = h.dispatchStorage.Unassign(r.Context(), a, version)
+	if err == dispatch.ErrNotAssigned {
+		msg := fmt.Sprintf("order with id= %v has no courier", orderID)
+		return ehttp.ConflictErr(msg, msg)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "can't unassign order with id= %v", orderID)
+	}
+
+	w.Header().Set("ETag", etag(version+1))
+
+	err = respondJSON(w, a)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with assignment: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// actor возвращает автора изменения для журнала: роль и номер API-ключа
+func actor(r *http.Request) string {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", p.Role, p.ID)
+}
@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/earnings"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/order"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+)
+
+// maxEarningsDays - самый длинный период, за который можно запросить заработок
+const maxEarningsDays = 93
+
+func earningsDisabledErr() error {
+	msg := "courier earnings are not configured"
+	return ehttp.FeatureDisabledErr(msg)
+}
+
+// courierOrder возвращает заказ, назначенный текущему курьеру
+func (h *Handler) courierOrder(r *http.Request) (*courier.Courier, *order.Order, error) {
+	c, err := h.currentCourier(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orderID, err := orderIDFromURL(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o, err := h.orderStorage.FindByID(r.Context(), orderID)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't get order with id= %v", orderID)
+	}
+
+	if o.CourierID != c.ID {
+		msg := fmt.Sprintf("can't find order with id= %v", orderID)
+		return nil, nil, ehttp.NotFoundErr(msg, msg)
+	}
+
+	return c, o, nil
+}
+
+func notDeliverableErr(orderID int64) error {
+	msg := fmt.Sprintf("order with id= %v is not waiting for delivery", orderID)
+	return ehttp.ConflictErr(msg, msg)
+}
+
+// arriveOrder отмечает приезд курьера по адресу, с этого момента считается ожидание покупателя
+func (h *Handler) arriveOrder(w http.ResponseWriter, r *http.Request) error {
+	if h.earnings == nil {
+		return earningsDisabledErr()
+	}
+
+	c, o, err := h.courierOrder(r)
+	if err != nil {
+		return err
+	}
+
+	version, err := matchVersion(r, o)
+	if err != nil {
+		return err
+	}
+
+	err = h.earningsStorage.Arrive(r.Context(), o.ID, c.ID, version, h.now())
+	if err == earnings.ErrNotDeliverable {
+		return notDeliverableErr(o.ID)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "can't mark arrival for order with id= %v", o.ID)
+	}
+
+	w.Header().Set("ETag", etag(version+1))
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// deliverOrder отмечает заказ доставленным и начисляет курьеру оплату по текущим правилам
+func (h *Handler) deliverOrder(w http.ResponseWriter, r *http.Request) error {
+	if h.earnings == nil {
+		return earningsDisabledErr()
+	}
+
+	c, o, err := h.courierOrder(r)
+	if err != nil {
+		return err
+	}
+
+	if o.Status != order.Assigned {
+		return notDeliverableErr(o.ID)
+	}
+
+	version, err := matchVersion(r, o)
+	if err != nil {
+		return err
+	}
+
+	distance, err := h.deliveryDistance(o)
+	if err != nil {
+		return err
+	}
+
+	now := h.now()
+
+	var waiting time.Duration
+	if o.ArrivedAt != nil && now.After(*o.ArrivedAt) {
+		waiting = now.Sub(*o.ArrivedAt)
+	}
+
+	e := h.earnings.Pay(distance, waiting)
+	e.OrderID = o.ID
+	e.CourierID = c.ID
+	e.DeliveredAt = now
+
+	err = h.earningsStorage.Deliver(r.Context(), e, version)
+	if err == earnings.ErrNotDeliverable {
+		return notDeliverableErr(o.ID)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "can't deliver order with id= %v", o.ID)
+	}
+
+	w.Header().Set("ETag", etag(version+1))
+
+	e.DeliveredAt = h.calendar(c.Zone).In(e.DeliveredAt)
+
+	err = respondJSON(w, e)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with earning: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+func (h *Handler) deliveryDistance(o *order.Order) (float64, error) {
+	from, err := h.geocoder.Geocode(o.From)
+	if err != nil {
+		detail := fmt.Sprintf("can't geocode pickup place of order with id= %v: %v", o.ID, err)
+		return 0, ehttp.InternalServerErr(detail)
+	}
+
+	to, err := h.geocoder.Geocode(o.Destination)
+	if err != nil {
+		detail := fmt.Sprintf("can't geocode destination of order with id= %v: %v", o.ID, err)
+		return 0, ehttp.InternalServerErr(detail)
+	}
+
+	return geo.Distance(from, to), nil
+}
+
+// getEarnings возвращает заработок курьера по дням за период from..to (даты включительно
+// в формате 2006-01-02 по календарю города курьера)
+func (h *Handler) getEarnings(w http.ResponseWriter, r *http.Request) error {
+	if h.earnings == nil {
+		return earningsDisabledErr()
+	}
+
+	c, err := h.currentCourier(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.calendar(c.Zone).Location()
+
+	from, err := time.ParseInLocation(dateLayout, r.URL.Query().Get("from"), loc)
+	if err != nil {
+		msg := "from must be a date in format YYYY-MM-DD"
+		return ehttp.BadRequestErr(msg, msg)
+	}
+
+	to, err := time.ParseInLocation(dateLayout, r.URL.Query().Get("to"), loc)
+	if err != nil {
+		msg := "to must be a date in format YYYY-MM-DD"
+		return ehttp.BadRequestErr(msg, msg)
+	}
+
+	to = to.AddDate(0, 0, 1)
+
+	if !to.After(from) || to.After(from.AddDate(0, 0, maxEarningsDays)) {
+		msg := fmt.Sprintf("period must be from 1 to %v days", maxEarningsDays)
+		return ehttp.BadRequestErr(msg, msg)
+	}
+
+	ee, err := h.earningsStorage.Find(r.Context(), c.ID, from, to)
+	if err != nil {
+		detail := fmt.Sprintf("can't find earnings of courier with id= %v: %v", c.ID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	days := earnings.Daily(ee, loc)
+
+	total := 0
+	for _, d := range days {
+		total += d.Total
+	}
+
+	err = respondJSON(w, struct {
+		CourierID int64           `json:"courier_id"`
+		Days      []*earnings.Day `json:"days"`
+		Total     int             `json:"total"`
+	}{
+		CourierID: c.ID,
+		Days:      days,
+		Total:     total,
+	})
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with earnings: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+const (
+	dateLayout  = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// getStatement выгружает выписку курьера за месяц (2006-01) в CSV
+func (h *Handler) getStatement(w http.ResponseWriter, r *http.Request) error {
+	if h.earnings == nil {
+		return earningsDisabledErr()
+	}
+
+	c, err := h.currentCourier(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.calendar(c.Zone).Location()
+
+	month := chi.URLParam(r, "month")
+
+	from, err := time.ParseInLocation(monthLayout, month, loc)
+	if err != nil {
+		msg := "month must be in format YYYY-MM"
+		return ehttp.BadRequestErr(msg, msg)
+	}
+
+	ee, err := h.earningsStorage.Find(r.Context(), c.ID, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		detail := fmt.Sprintf("can't find earnings of courier with id= %v: %v", c.ID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	filename := fmt.Sprintf("statement-%d-%s.csv", c.ID, month)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	if err = earnings.WriteStatement(w, ee, loc); err != nil {
+		h.logger.Errorf("can't write statement of courier with id= %v: %v", c.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/order"
+	"strconv"
+	"strings"
+)
+
+// etag возвращает сильный ETag заказа версии version
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch возвращает версию заказа из заголовка If-Match. Изменить заказ можно, только
+// зная его текущую версию, поэтому запрос без заголовка отклоняется с кодом 428
+func ifMatch(r *http.Request) (int64, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		msg := "If-Match header with order ETag is required"
+		return 0, ehttp.PreconditionRequiredErr(msg, msg)
+	}
+
+	msg := "If-Match header must contain order ETag"
+
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, ehttp.BadRequestErr(msg, msg)
+	}
+
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, ehttp.BadRequestErr(msg, msg)
+	}
+
+	return version, nil
+}
+
+// matchVersion сверяет If-Match с текущей версией заказа. Хранилище проверяет версию еще раз
+// при изменении, это позволяет не выполнять лишнюю работу для устаревшей версии
+func matchVersion(r *http.Request, o *order.Order) (int64, error) {
+	version, err := ifMatch(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if version != o.Version {
+		return 0, order.ErrStale
+	}
+
+	return version, nil
+}
@@ -3,42 +3,266 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/dispatch"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/earnings"
 	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/idempotency"
+	"safedeal-backend-trainee/internal/memory"
 	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/pricing"
 	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/promo"
+	"safedeal-backend-trainee/internal/ratelimit"
+	"safedeal-backend-trainee/internal/rating"
+	"safedeal-backend-trainee/internal/routing"
+	"safedeal-backend-trainee/internal/shift"
+	"safedeal-backend-trainee/internal/slot"
+	"safedeal-backend-trainee/internal/surge"
 	"safedeal-backend-trainee/pkg/log/logger"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/httprate"
+	"github.com/go-chi/chi/middleware"
 )
 
 type Handler struct {
-	productStorage product.Storage
-	orderStorage   order.Storage
-	logger         logger.Logger
+	productStorage  product.Storage
+	orderStorage    order.Storage
+	promoStorage    promo.Storage
+	authStorage     auth.Storage
+	courierStorage  courier.Storage
+	surgeStorage    surge.Storage
+	slotStorage     slot.Storage
+	dispatchStorage dispatch.Storage
+	shiftStorage    shift.Storage
+	earningsStorage earnings.Storage
+	logger          logger.Logger
+	geocoder        geo.Geocoder
+	zones           geo.Zones
+	pricing         *pricing.Calculator
+	surge           *surge.Calculator
+	slots           *slot.Schedule
+	calendars       *ftime.Calendars
+	routing         *routing.Planner
+	routes          *routeCache
+	shifts          shift.Configuration
+	earnings        *earnings.Rules
+	ratings         *rating.Board
+	unitOfWork      domain.UnitOfWork
+	idempotency     *idempotency.Keeper
+	productCache    *product.Cache
+	limiter         *ratelimit.Limiter
+	clientIP        *clientip.Resolver
+	now             func() time.Time
 }
 
-func New(p product.Storage, o order.Storage, l logger.Logger) *Handler {
-	return &Handler{
+type Option func(*Handler)
+
+// WithPromo включает поддержку промокодов
+func WithPromo(s promo.Storage) Option {
+	return func(h *Handler) {
+		h.promoStorage = s
+	}
+}
+
+// WithAuth включает проверку API-ключей
+func WithAuth(s auth.Storage) Option {
+	return func(h *Handler) {
+		h.authStorage = s
+	}
+}
+
+// WithSurge включает повышение цены при нехватке свободных курьеров
+func WithSurge(c *surge.Calculator, s surge.Storage, cs courier.Storage) Option {
+	return func(h *Handler) {
+		h.surge = c
+		h.surgeStorage = s
+		h.courierStorage = cs
+	}
+}
+
+// WithSlots включает запись заказов на интервалы доставки
+func WithSlots(sc *slot.Schedule, s slot.Storage) Option {
+	return func(h *Handler) {
+		h.slots = sc
+		h.slotStorage = s
+	}
+}
+
+// WithCalendars задает рабочие календари городов
+func WithCalendars(cc *ftime.Calendars) Option {
+	return func(h *Handler) {
+		h.calendars = cc
+	}
+}
+
+// WithRouting включает построение маршрутов курьеров
+func WithRouting(p *routing.Planner, cs courier.Storage) Option {
+	return func(h *Handler) {
+		h.routing = p
+		h.courierStorage = cs
+	}
+}
+
+// WithDispatch включает журнал и отмену назначений курьеров
+func WithDispatch(s dispatch.Storage) Option {
+	return func(h *Handler) {
+		h.dispatchStorage = s
+	}
+}
+
+// WithShifts включает смены курьеров
+func WithShifts(c shift.Configuration, s shift.Storage, cs courier.Storage) Option {
+	return func(h *Handler) {
+		h.shifts = c
+		h.shiftStorage = s
+		h.courierStorage = cs
+	}
+}
+
+// WithEarnings включает отметки о доставке и расчет оплаты курьерам
+func WithEarnings(r *earnings.Rules, s earnings.Storage, cs courier.Storage) Option {
+	return func(h *Handler) {
+		h.earnings = r
+		h.earningsStorage = s
+		h.courierStorage = cs
+	}
+}
+
+// WithRatings включает отзывы покупателей о доставках
+func WithRatings(b *rating.Board) Option {
+	return func(h *Handler) {
+		h.ratings = b
+	}
+}
+
+// WithUnitOfWork включает транзакции, в которых выполняются связанные изменения товаров и заказов
+func WithUnitOfWork(u domain.UnitOfWork) Option {
+	return func(h *Handler) {
+		h.unitOfWork = u
+	}
+}
+
+// WithIdempotency включает повтор сохраненного ответа на создание заказа с тем же Idempotency-Key
+func WithIdempotency(k *idempotency.Keeper) Option {
+	return func(h *Handler) {
+		h.idempotency = k
+	}
+}
+
+// WithProductCache включает статистику кэша товаров. Сам кэш передается в New как хранилище товаров
+func WithProductCache(c *product.Cache) Option {
+	return func(h *Handler) {
+		h.productCache = c
+	}
+}
+
+// WithRateLimit задает правила ограничения запросов. По умолчанию действует DefaultConfiguration
+// со счетчиками в памяти процесса
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(h *Handler) {
+		h.limiter = l
+	}
+}
+
+// WithTrustedProxies задает прокси, от которых принимаются заголовки X-Forwarded-For и Forwarded.
+// По умолчанию прокси нет, и IP-адрес клиента - адрес соединения
+func WithTrustedProxies(cr *clientip.Resolver) Option {
+	return func(h *Handler) {
+		h.clientIP = cr
+	}
+}
+
+func WithGeo(g geo.Geocoder, zz geo.Zones) Option {
+	return func(h *Handler) {
+		h.geocoder = g
+		h.zones = zz
+	}
+}
+
+func WithPricing(c *pricing.Calculator) Option {
+	return func(h *Handler) {
+		h.pricing = c
+	}
+}
+
+func New(p product.Storage, o order.Storage, l logger.Logger, opts ...Option) *Handler {
+	h := &Handler{
 		productStorage: p,
 		orderStorage:   o,
 		logger:         l,
+		geocoder:       geo.NewHashGeocoder(geo.MoscowBounds),
+		pricing:        pricing.New(pricing.DefaultConfiguration),
+		calendars:      ftime.NewCalendars(ftime.AlwaysOpen(), nil),
+		limiter:        ratelimit.New(ratelimit.DefaultConfiguration, memory.NewKVStore()),
+		clientIP:       &clientip.Resolver{},
+		routes:         newRouteCache(routeTTL),
+		now:            time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(h)
 	}
+
+	return h
 }
 
 func (h *Handler) Routes() chi.Router {
-	const (
-		RequestLimit = 10
-		Window       = 1 * time.Minute
-	)
-
 	r := chi.NewRouter()
-	r.With(httprate.LimitByIP(RequestLimit, Window)).Route("/api/v1", func(r chi.Router) {
+	r.Use(requestID)
+	r.NotFound(MWError(routeNotFound, h.logger))
+	r.MethodNotAllowed(MWError(methodNotAllowed, h.logger))
+
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(h.resolveClientIP)
+		r.Use(h.authenticate)
+		r.Use(h.rateLimit)
+
+		r.Get("/problems", MWError(h.getProblems, h.logger))
+		r.Get("/problems/{code}", MWError(h.getProblem, h.logger))
 		r.Post("/products/{id}/cost-of-delivery", MWError(h.costOfDelivery, h.logger))
-		r.Post("/products/{id}/order", MWError(h.createOrder, h.logger))
+		r.With(h.idempotent).Post("/products/{id}/order", MWError(h.createOrder, h.logger))
 		r.Get("/orders", MWError(h.getOrders, h.logger))
 		r.Get("/orders/{id}", MWError(h.getOrder, h.logger))
+		r.With(h.requireRole(auth.Buyer)).Post("/orders/{id}/rating", MWError(h.rateOrder, h.logger))
+		r.Get("/delivery-slots", MWError(h.getDeliverySlots, h.logger))
+		r.Group(func(r chi.Router) {
+			r.Use(h.requireRole(auth.Seller, auth.Admin))
+			r.Get("/shifts/coverage", MWError(h.getCoverage, h.logger))
+			r.Get("/ratings/couriers", MWError(h.ratingsHandler(courierRatings), h.logger))
+			r.Get("/ratings/pickups", MWError(h.ratingsHandler(pickupRatings), h.logger))
+		})
+
+		r.With(h.requireRole(auth.Courier)).Route("/couriers/me", func(r chi.Router) {
+			r.Get("/route", MWError(h.getRoute, h.logger))
+			r.Post("/location", MWError(h.updateLocation, h.logger))
+			r.Get("/shifts", MWError(h.getShifts, h.logger))
+			r.Post("/shift/clock-in", MWError(h.shiftHandler(clockIn), h.logger))
+			r.Post("/shift/clock-out", MWError(h.shiftHandler(clockOut), h.logger))
+			r.Post("/shift/break-start", MWError(h.shiftHandler(startBreak), h.logger))
+			r.Post("/shift/break-end", MWError(h.shiftHandler(endBreak), h.logger))
+			r.Post("/orders/{id}/picked-up", MWError(h.pickUpOrder, h.logger))
+			r.Post("/orders/{id}/arrived", MWError(h.arriveOrder, h.logger))
+			r.Post("/orders/{id}/delivered", MWError(h.deliverOrder, h.logger))
+			r.Get("/earnings", MWError(h.getEarnings, h.logger))
+			r.Get("/statements/{month}", MWError(h.getStatement, h.logger))
+		})
+
+		r.With(h.requireRole(auth.Admin)).Route("/admin", func(r chi.Router) {
+			r.Get("/surge/{zone}", MWError(h.getSurge, h.logger))
+			r.Put("/surge/{zone}", MWError(h.setSurge, h.logger))
+			r.Delete("/surge/{zone}", MWError(h.deleteSurge, h.logger))
+			r.Post("/shifts", MWError(h.createShift, h.logger))
+			r.Get("/orders/{id}/assignments", MWError(h.getAssignments, h.logger))
+			r.Delete("/orders/{id}/assignment", MWError(h.deleteAssignment, h.logger))
+			r.Get("/cache/products", MWError(h.getProductCacheStats, h.logger))
+		})
 	})
 
 	return r
@@ -49,30 +273,31 @@ type handlerFunc func(http.ResponseWriter, *http.Request) error
 func MWError(h handlerFunc, l logger.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := h(w, r); err != nil {
-			e, ok := err.(ehttp.HTTPError)
-			if !ok {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
-			if e.Detail != "" {
-				l.Errorf(e.Detail)
-			}
-
-			w.WriteHeader(e.StatusCode)
-
-			if e.Msg != "" {
-				w.Header().Add("Content-Type", "application/json")
-
-				out, err := json.Marshal(e)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-
-				// no need to handle error here
-				_, _ = w.Write(out)
-			}
+			respondError(w, r, err, l)
 		}
 	}
 }
+
+// respondError пишет ответ с ошибкой в формате application/problem+json. В журнал попадает запрос,
+// его ID и IP-адрес клиента, определенный resolveClientIP
+func respondError(w http.ResponseWriter, r *http.Request, err error, l logger.Logger) {
+	// ошибки предметной области получают код ответа своего вида, остальные - 500
+	e := ehttp.FromError(err)
+	id := middleware.GetReqID(r.Context())
+
+	if e.Detail != "" {
+		l.Errorf("%s %s [%s] from %s: %s", r.Method, r.URL.Path, id, clientip.FromContext(r.Context()), e.Detail)
+	}
+
+	out, err := json.Marshal(e.Problem(id))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ehttp.ProblemContentType)
+	w.WriteHeader(e.StatusCode)
+
+	// no need to handle error here
+	_, _ = w.Write(out)
+}
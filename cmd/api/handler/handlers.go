@@ -1,14 +1,17 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"safedeal-backend-trainee/internal/ehttp"
 	"safedeal-backend-trainee/internal/ftime"
 	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/pricing"
 	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/slot"
+	"safedeal-backend-trainee/internal/validation"
 	"strconv"
 	"strings"
 	"time"
@@ -19,41 +22,79 @@ import (
 const BottomLineValidID = 0
 
 func (h *Handler) costOfDelivery(w http.ResponseWriter, r *http.Request) error {
-	type destination struct {
+	type deliveryInfo struct {
 		Address string `json:"destination"`
+		// Time и Slot - время доставки, как при создании заказа. Коэффициент спроса рассчитывается
+		// для него, без них - для текущего времени
+		Time      ftime.FormatTime  `json:"time"`
+		Slot      *ftime.FormatTime `json:"slot"`
+		PromoCode string            `json:"promo_code"`
+		Buyer     string            `json:"buyer"`
 	}
 
-	var d destination
+	var d deliveryInfo
 
 	err := json.NewDecoder(r.Body).Decode(&d)
 	if err != nil {
 		return ehttp.JSONUnmarshalErr(err)
 	}
 
+	var v validation.Validator
+
+	validateDelivery(&v, d.Address, d.PromoCode, d.Buyer)
+
+	if err = v.Err(); err != nil {
+		return err
+	}
+
+	buyer, err := requestBuyer(r.Context(), d.Buyer)
+	if err != nil {
+		return err
+	}
+
 	id, err := getIDFromRequest(r)
 	if err != nil {
 		return err
 	}
 
-	product, err := h.productStorage.FindByID(id)
+	product, err := h.productStorage.FindByID(r.Context(), id)
 	if err != nil {
-		detail := fmt.Sprintf("can't find product with id= %v: %v", id, err)
-		return ehttp.InternalServerErr(detail)
+		return errors.Wrapf(err, "can't get product with id= %v", id)
 	}
 
-	if product.ID == BottomLineValidID {
-		msg := fmt.Sprintf("can't find product with id= %v", id)
-		detail := fmt.Sprintf("%v: %v", msg, err)
+	dest, err := h.locate(d.Address)
+	if err != nil {
+		return err
+	}
 
-		return ehttp.NotFoundErr(msg, detail)
+	at := d.Time.Time
+	if at.IsZero() && d.Slot != nil {
+		at = d.Slot.Time
 	}
 
-	price := calcPrice()
+	if at.IsZero() {
+		at = h.now()
+	}
+
+	q, err := h.quote(r.Context(), product, dest, at, d.PromoCode, buyer)
+	if err != nil {
+		return err
+	}
 
-	err = respondJSON(w, map[string]interface{}{
-		"from":        product.Place,
-		"destination": d.Address,
-		"price":       price,
+	err = respondJSON(w, struct {
+		Destination string             `json:"destination"`
+		From        string             `json:"from"`
+		Price       int                `json:"price"`
+		Zone        string             `json:"zone"`
+		PromoCode   string             `json:"promo_code,omitempty"`
+		Breakdown   *pricing.Breakdown `json:"breakdown"`
+	}{
+		Destination: d.Address,
+		From:        product.Place,
+		Price:       q.breakdown.Total,
+		Zone:        q.zone,
+		PromoCode:   promoCode(q),
+		Breakdown:   q.breakdown,
 	})
 	if err != nil {
 		detail := fmt.Sprintf("can't respond json with delivery info: %v", err)
@@ -77,18 +118,12 @@ func getIDFromRequest(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-// calcPrice возвращает цену доставки
-// (цена - случайное число в диапазоне [min * fact, max * fact])
-func calcPrice() int {
-	const (
-		min  = 3
-		max  = 20
-		fact = 100
-	)
-
-	rand.Seed(time.Now().UnixNano())
+func promoCode(q *quote) string {
+	if q.promo == nil {
+		return ""
+	}
 
-	return (rand.Intn(max-min+1) + min) * fact // nolint: gomnd
+	return q.promo.Code
 }
 
 func IDFromParams(r *http.Request) (int64, error) {
@@ -107,8 +142,11 @@ func IDFromParams(r *http.Request) (int64, error) {
 
 func (h *Handler) createOrder(w http.ResponseWriter, r *http.Request) error {
 	type orderInfo struct {
-		Address string    `json:"destination"`
-		Time    time.Time `json:"time"`
+		Address   string            `json:"destination"`
+		Time      ftime.FormatTime  `json:"time"`
+		Slot      *ftime.FormatTime `json:"slot"`
+		PromoCode string            `json:"promo_code"`
+		Buyer     string            `json:"buyer"`
 	}
 
 	var info orderInfo
@@ -118,37 +156,105 @@ func (h *Handler) createOrder(w http.ResponseWriter, r *http.Request) error {
 		return ehttp.JSONUnmarshalErr(err)
 	}
 
+	var v validation.Validator
+
+	validateDelivery(&v, info.Address, info.PromoCode, info.Buyer)
+
+	// без времени заказ доставляется к началу интервала
+	if info.Slot == nil {
+		v.RequiredTime("time", info.Time.Time)
+	}
+
+	if err = v.Err(); err != nil {
+		return err
+	}
+
+	buyer, err := requestBuyer(r.Context(), info.Buyer)
+	if err != nil {
+		return err
+	}
+
 	id, err := getIDFromRequest(r)
 	if err != nil {
 		return err
 	}
 
-	product, err := h.productStorage.FindByID(id)
+	product, err := h.productStorage.FindByID(r.Context(), id)
 	if err != nil {
-		detail := fmt.Sprintf("can't find product with productID= %v: %v", id, err)
-		return ehttp.InternalServerErr(detail)
+		return errors.Wrapf(err, "can't get product with id= %v", id)
+	}
+
+	dest, err := h.locate(info.Address)
+	if err != nil {
+		return err
+	}
+
+	sl, err := h.orderSlot(dest, info.Slot, &info.Time.Time)
+	if err != nil {
+		return err
+	}
+
+	q, err := h.quote(r.Context(), product, dest, info.Time.Time, info.PromoCode, buyer)
+	if err != nil {
+		return err
 	}
 
-	if product.ID == BottomLineValidID {
-		msg := fmt.Sprintf("can't find product with id= %v", id)
-		detail := fmt.Sprintf("%v: %v", msg, err)
+	order := NewOrder(product, info.Address, info.Time.Time)
+	order.Buyer = buyer
+	order.Price = q.breakdown.Total
+	order.PromoCode = promoCode(q)
+	order.Zone = q.zone
 
-		return ehttp.NotFoundErr(msg, detail)
+	if sl != nil {
+		order.TimeTo = ftime.New(sl.To)
 	}
 
-	order := NewOrder(product, info.Address, info.Time)
+	err = h.placeOrder(r.Context(), order, q, sl)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/orders/%d", order.ID))
+	w.Header().Set("ETag", etag(order.Version))
 
-	err = h.orderStorage.Create(order)
+	err = respondJSONStatus(w, http.StatusCreated, viewOrder(order, product, h.calendar(order.Zone)))
 	if err != nil {
-		detail := fmt.Sprintf("can't can't create order with productID= %v: %v", order.ProductID, err)
+		detail := fmt.Sprintf("can't respond json with created order: %v", err)
 		return ehttp.InternalServerErr(detail)
 	}
 
-	w.WriteHeader(http.StatusCreated)
-
 	return nil
 }
 
+// placeOrder в одной транзакции проверяет, что товар не удален, пока рассчитывалась
+// стоимость доставки, занимает место в интервале доставки, сохраняет заказ и погашает промокод
+func (h *Handler) placeOrder(ctx context.Context, o *order.Order, q *quote, sl *slot.Slot) error {
+	return h.inTx(ctx, func(ctx context.Context) error {
+		if _, err := h.productStorage.FindByID(ctx, o.ProductID); err != nil {
+			return errors.Wrapf(err, "can't create order with product id= %v", o.ProductID)
+		}
+
+		if err := h.reserveSlot(ctx, q.zone, sl); err != nil {
+			return err
+		}
+
+		if err := h.orderStorage.Create(ctx, o); err != nil {
+			return errors.Wrapf(err, "can't create order with product id= %v", o.ProductID)
+		}
+
+		return h.redeemPromo(ctx, q, o)
+	})
+}
+
+// inTx выполняет f в транзакции, без UnitOfWork - просто вызывает f
+func (h *Handler) inTx(ctx context.Context, f func(ctx context.Context) error) error {
+	if h.unitOfWork == nil {
+		return f(ctx)
+	}
+
+	return h.unitOfWork.Do(ctx, f)
+}
+
 func NewOrder(p *product.Product, dest string, t time.Time) *order.Order {
 	return &order.Order{
 		ProductID:   p.ID,
@@ -160,7 +266,7 @@ func NewOrder(p *product.Product, dest string, t time.Time) *order.Order {
 }
 
 func (h *Handler) getOrders(w http.ResponseWriter, r *http.Request) error {
-	orders, err := h.orderStorage.GetAll()
+	orders, err := h.orderStorage.GetAll(r.Context())
 	if err != nil {
 		detail := fmt.Sprintf("can't get all orders: %v", err)
 		return ehttp.InternalServerErr(detail)
@@ -185,56 +291,83 @@ func removeExtraInfo(old []*order.Order) []*order.Order {
 		o.From = ""
 		o.Destination = ""
 		o.Time = nil
+		o.TimeTo = nil
+		o.Buyer = ""
+		o.Price = 0
+		o.PromoCode = ""
+		o.Zone = ""
+		o.CourierID = 0
+		o.Status = ""
+		o.PickedUpAt = nil
+		o.ArrivedAt = nil
+		o.DeliveredAt = nil
 	}
 
 	return res
 }
 
+// orderView - заказ в ответах API, время возвращается в часовом поясе города доставки
+type orderView struct {
+	ID            int64             `json:"id"`
+	Product       product.Product   `json:"product"`
+	From          string            `json:"from"`
+	Destination   string            `json:"destination"`
+	Time          ftime.FormatTime  `json:"time"`
+	TimeTo        *ftime.FormatTime `json:"time_to,omitempty"`
+	Price         int               `json:"price,omitempty"`
+	PromoCode     string            `json:"promo_code,omitempty"`
+	Status        order.Status      `json:"status,omitempty"`
+	ETA           *ftime.FormatTime `json:"eta,omitempty"`
+	ETAConfidence *float64          `json:"eta_confidence,omitempty"`
+}
+
+func viewOrder(o *order.Order, p *product.Product, cal *ftime.Calendar) *orderView {
+	return &orderView{
+		ID:          o.ID,
+		Product:     *p,
+		From:        o.From,
+		Destination: o.Destination,
+		Time:        ftime.FormatTime{Time: cal.In(o.Time.Time)},
+		TimeTo:      inLocation(o.TimeTo, cal),
+		Price:       o.Price,
+		PromoCode:   o.PromoCode,
+		Status:      o.Status,
+	}
+}
+
 func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request) error {
 	orderID, err := getIDFromRequest(r)
 	if err != nil {
 		return err
 	}
 
-	order, err := h.orderStorage.FindByID(orderID)
+	order, err := h.orderStorage.FindByID(r.Context(), orderID)
 	if err != nil {
-		detail := fmt.Sprintf("can't find order with ID = %v: %v", orderID, err)
-		return ehttp.InternalServerErr(detail)
+		return errors.Wrapf(err, "can't get order with id= %v", orderID)
 	}
 
-	if order.ID == BottomLineValidID {
-		msg := fmt.Sprintf("can't find order with id= %v", orderID)
-		detail := fmt.Sprintf("%v: %v", msg, err)
-
-		return ehttp.NotFoundErr(msg, detail)
+	pr, err := h.productStorage.FindByID(r.Context(), order.ProductID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get product of order with id= %v", orderID)
 	}
 
-	pr, err := h.productStorage.FindByID(order.ProductID)
+	cal := h.calendar(order.Zone)
+
+	eta, err := h.estimate(r.Context(), order)
 	if err != nil {
-		detail := fmt.Sprintf("can't find product: %v", err)
-		return ehttp.InternalServerErr(detail)
+		return err
 	}
 
-	if pr.ID == BottomLineValidID {
-		msg := fmt.Sprintf("can't find product with id= %v", order.ProductID)
-		detail := fmt.Sprintf("%v: %v", msg, err)
+	resp := viewOrder(order, pr, cal)
 
-		return ehttp.NotFoundErr(msg, detail)
+	if eta != nil {
+		resp.ETA = ftime.New(cal.In(eta.At).Round(time.Second))
+		resp.ETAConfidence = &eta.Confidence
 	}
 
-	err = respondJSON(w, struct {
-		ID          int64            `json:"id"`
-		Product     product.Product  `json:"product"`
-		From        string           `json:"from"`
-		Destination string           `json:"destination"`
-		Time        ftime.FormatTime `json:"time"`
-	}{
-		ID:          order.ID,
-		Product:     *pr,
-		From:        order.From,
-		Destination: order.Destination,
-		Time:        *order.Time,
-	})
+	w.Header().Set("ETag", etag(order.Version))
+
+	err = respondJSON(w, resp)
 	if err != nil {
 		detail := fmt.Sprintf("can't respond json with order's detailed info: %v", err)
 		return ehttp.InternalServerErr(detail)
@@ -243,14 +376,27 @@ func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// inLocation возвращает время в часовом поясе города, в который доставляется заказ
+func inLocation(t *ftime.FormatTime, cal *ftime.Calendar) *ftime.FormatTime {
+	if t == nil {
+		return nil
+	}
+
+	return ftime.New(cal.In(t.Time))
+}
+
 func respondJSON(w http.ResponseWriter, payload interface{}) error {
+	return respondJSONStatus(w, http.StatusOK, payload)
+}
+
+func respondJSONStatus(w http.ResponseWriter, status int, payload interface{}) error {
 	response, err := json.Marshal(payload)
 	if err != nil {
 		return errors.Wrapf(err, "can't marshal respond to json")
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 
 	c, err := w.Write(response)
 	if err != nil {
@@ -2,150 +2,2783 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/dispatch"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/earnings"
+	"safedeal-backend-trainee/internal/ehttp"
 	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/idempotency"
+	"safedeal-backend-trainee/internal/memory"
 	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/pricing"
 	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/promo"
+	"safedeal-backend-trainee/internal/ratelimit"
+	"safedeal-backend-trainee/internal/rating"
+	"safedeal-backend-trainee/internal/resp"
+	"safedeal-backend-trainee/internal/routing"
+	"safedeal-backend-trainee/internal/shift"
+	"safedeal-backend-trainee/internal/slot"
+	"safedeal-backend-trainee/internal/surge"
+	"safedeal-backend-trainee/internal/validation"
 	"safedeal-backend-trainee/pkg/log/logger"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 )
 
-type mockProductStorage struct {
-	p *product.Product
+// newProductStorage создает хранилище товаров в памяти с товарами pp
+func newProductStorage(t *testing.T, pp ...*product.Product) *memory.ProductStorage {
+	t.Helper()
+
+	s := memory.NewProductStorage()
+
+	for _, p := range pp {
+		if err := s.Create(p); err != nil {
+			t.Fatalf("can't create product %v", err)
+		}
+	}
+
+	return s
+}
+
+// countingProductStorage считает обращения к хранилищу товаров за кэшем
+type countingProductStorage struct {
+	product.Storage
+	calls int
+}
+
+func (m *countingProductStorage) FindByID(ctx context.Context, id int64) (*product.Product, error) {
+	m.calls++
+	return m.Storage.FindByID(ctx, id)
+}
+
+// invalidatingProductStorage один раз сбрасывает товар в кэше, пока тот читает товар из хранилища,
+// как если бы товар изменили во время чтения
+type invalidatingProductStorage struct {
+	product.Storage
+	cache product.Invalidator
+}
+
+func (m *invalidatingProductStorage) FindByID(ctx context.Context, id int64) (*product.Product, error) {
+	p, err := m.Storage.FindByID(ctx, id)
+
+	if inv := m.cache; inv != nil {
+		m.cache = nil
+
+		if err := inv.Invalidate(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, err
+}
+
+// newOrderStorage создает хранилище заказов в памяти с заказами oo. Хранилище нумерует
+// заказы по порядку с 1 и сохраняет их в первой версии
+func newOrderStorage(t *testing.T, oo ...*order.Order) *memory.OrderStorage {
+	t.Helper()
+
+	s := memory.NewOrderStorage()
+
+	for _, o := range oo {
+		if err := s.Create(context.Background(), o); err != nil {
+			t.Fatalf("can't create order %v", err)
+		}
+	}
+
+	return s
+}
+
+type mockCourierStorage struct {
+	available int
+	c         *courier.Courier
+	courier.Storage
+}
+
+func (m mockCourierStorage) FindByID(ctx context.Context, id int64) (*courier.Courier, error) {
+	if m.c == nil {
+		return nil, domain.NotFound("can't find courier with id= %v", id)
+	}
+
+	return m.c, nil
+}
+
+func (m mockCourierStorage) CountAvailable(ctx context.Context, zone string) (int, error) {
+	return m.available, nil
+}
+
+func (m mockCourierStorage) PickUp(ctx context.Context, orderID int64, courierID int64, version int64, at time.Time) error {
+	return nil
+}
+
+type mockSurgeStorage struct {
+	o *surge.Override
+	surge.Storage
+}
+
+func (m mockSurgeStorage) FindOverride(ctx context.Context, zone string) (*surge.Override, error) {
+	if m.o == nil {
+		return nil, domain.NotFound("can't find surge override for zone %q", zone)
+	}
+
+	return m.o, nil
+}
+
+type mockSlotStorage struct {
+	rr   []*slot.Reservation
+	full bool
+	slot.Storage
+}
+
+func (m mockSlotStorage) Reservations(ctx context.Context, zone string, from time.Time, to time.Time) ([]*slot.Reservation, error) {
+	return m.rr, nil
+}
+
+func (m mockSlotStorage) Reserve(ctx context.Context, zone string, start time.Time, capacity int) error {
+	if m.full {
+		return slot.ErrFull
+	}
+
+	return nil
+}
+
+type mockDispatchStorage struct {
+	courierID int64
+	version   int64
+	history   []*dispatch.Assignment
+	dispatch.Storage
+}
+
+func (m mockDispatchStorage) Unassign(ctx context.Context, a *dispatch.Assignment, version int64) error {
+	if m.courierID == 0 {
+		return dispatch.ErrNotAssigned
+	}
+
+	if version != m.version {
+		return order.ErrStale
+	}
+
+	a.ID = 3
+	a.CourierID = m.courierID
+	a.CreatedAt = time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	return nil
+}
+
+func (m mockDispatchStorage) History(ctx context.Context, orderID int64) ([]*dispatch.Assignment, error) {
+	return m.history, nil
+}
+
+type mockShiftStorage struct {
+	s       *shift.Shift
+	planned []*shift.Shift
+	err     error
+	shift.Storage
+}
+
+func (m mockShiftStorage) Current(ctx context.Context, courierID int64, at time.Time, early time.Duration) (*shift.Shift, error) {
+	if m.s == nil {
+		return nil, shift.ErrNoShift
+	}
+
+	return m.s, nil
+}
+
+func (m mockShiftStorage) FindByID(ctx context.Context, id int64) (*shift.Shift, error) {
+	return m.s, nil
+}
+
+func (m mockShiftStorage) ClockIn(ctx context.Context, shiftID int64, at time.Time) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.s.ClockIn = &at
+
+	return nil
+}
+
+func (m mockShiftStorage) Planned(ctx context.Context, from time.Time, to time.Time) ([]*shift.Shift, error) {
+	return m.planned, nil
+}
+
+type mockEarningsStorage struct {
+	ee []*earnings.Earning
+	earnings.Storage
+}
+
+func (m mockEarningsStorage) Deliver(ctx context.Context, e *earnings.Earning, version int64) error {
+	return nil
+}
+
+func (m mockEarningsStorage) Find(ctx context.Context, courierID int64, from time.Time, to time.Time) ([]*earnings.Earning, error) {
+	return m.ee, nil
+}
+
+func newTestEarnings() []*earnings.Earning {
+	return []*earnings.Earning{
+		{OrderID: 1, DeliveredAt: time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC), Distance: 3.5,
+			Base: 150, DistanceFee: 53, Total: 203},
+		{OrderID: 2, DeliveredAt: time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC), Distance: 1.25,
+			Waiting: ftime.Duration{Duration: 15 * time.Minute}, Base: 150, DistanceFee: 19, WaitingBonus: 25, Total: 194},
+		{OrderID: 3, DeliveredAt: time.Date(2020, 6, 16, 9, 30, 0, 0, time.UTC), Distance: 2,
+			Base: 150, DistanceFee: 30, Total: 180},
+	}
+}
+
+type mockRatingStorage struct {
+	err      error
+	couriers []*rating.Aggregate
+	rating.Storage
+}
+
+func (m mockRatingStorage) Create(ctx context.Context, r *rating.Rating) error {
+	return m.err
+}
+
+func (m mockRatingStorage) Couriers(ctx context.Context, from time.Time) ([]*rating.Aggregate, error) {
+	return m.couriers, nil
+}
+
+type mockAuthStorage struct {
+	p *auth.Principal
+	auth.Storage
+}
+
+func (m mockAuthStorage) FindByKey(ctx context.Context, key string) (*auth.Principal, error) {
+	if m.p == nil || m.p.ID == 0 {
+		return nil, domain.NotFound("can't find api key")
+	}
+
+	return m.p, nil
+}
+
+type mockPromoStorage struct {
+	c        *promo.Code
+	u        *promo.Usage
+	reserved []*promo.Redemption
+	inTx     bool
+	promo.Storage
+}
+
+func (m mockPromoStorage) FindByCode(ctx context.Context, code string) (*promo.Code, error) {
+	if m.c == nil || m.c.ID == 0 {
+		return nil, domain.NotFound("can't find promo code %q", code)
+	}
+
+	return m.c, nil
+}
+
+func (m mockPromoStorage) Usage(ctx context.Context, codeID int64, buyer string) (*promo.Usage, error) {
+	return m.u, nil
+}
+
+func (m *mockPromoStorage) Reserve(ctx context.Context, c *promo.Code, buyer string, orderID int64) (*promo.Redemption, error) {
+	r := &promo.Redemption{ID: int64(len(m.reserved) + 1), CodeID: c.ID, OrderID: orderID, Buyer: buyer}
+	m.reserved = append(m.reserved, r)
+	m.inTx = domain.InUnitOfWork(ctx)
+
+	return r, nil
+}
+
+type mockUnitOfWork struct {
+	calls int
+}
+
+func (m *mockUnitOfWork) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	m.calls++
+	return f(domain.WithUnitOfWork(ctx))
+}
+
+type mockLogger struct {
+	logger.Logger
+}
+
+func (m mockLogger) Debugf(format string, args ...interface{}) {}
+func (m mockLogger) Infof(format string, args ...interface{})  {}
+func (m mockLogger) Warnf(format string, args ...interface{})  {}
+func (m mockLogger) Errorf(format string, args ...interface{}) {}
+func (m mockLogger) Fatalf(format string, args ...interface{}) {}
+func (m mockLogger) Panicf(format string, args ...interface{}) {}
+
+func respContains(in string, want string) bool {
+	if in == "" {
+		return want == ""
+	}
+
+	return strings.Contains(in, want)
+}
+
+// problem разбирает ответ с ошибкой и проверяет его тип
+func problem(t *testing.T, rr *httptest.ResponseRecorder) ehttp.Problem {
+	t.Helper()
+
+	if ct := rr.Header().Get("Content-Type"); ct != ehttp.ProblemContentType {
+		t.Errorf("error response has wrong Content-Type: got %v, want %v", ct, ehttp.ProblemContentType)
+	}
+
+	var p ehttp.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("can't unmarshal error response %q: %v", rr.Body.String(), err)
+	}
+
+	return p
+}
+
+func TestCostOfDeliveryCorrect(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+
+	place := "Тверской бульвар, 25"
+
+	p := &product.Product{
+		ID:    1,
+		Place: place,
+	}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"destination":"Большая Садовая, 302-бис, пятый этаж, кв. № 50","from":"Тверской бульвар, 25"`
+	if !respContains(rr.Body.String(), expected) {
+		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestCostOfDeliveryPrice(t *testing.T) {
+	l := new(mockLogger)
+
+	p := &product.Product{
+		ID:     1,
+		Place:  "Тверской бульвар, 25",
+		Weight: 2.5,
+	}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
+
+	// цена зависит только от веса товара, а не от координат адреса из заглушки геокодера:
+	// 300 + 20 * 2.5 = 350
+	for _, dest := range []string{"Большая Садовая, 302-бис, пятый этаж, кв. № 50", "Арбат, 10"} {
+		body := fmt.Sprintf(`{"destination" : %q}`, dest)
+
+		req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		MWError(h.costOfDelivery, l).ServeHTTP(rr, req)
+
+		expected := `"price":350,`
+		if !respContains(rr.Body.String(), expected) {
+			t.Errorf("costOfDelivery handler returned unexpected body for %q: got %v, want %v",
+				dest, rr.Body.String(), expected)
+		}
+	}
+}
+
+func TestCostOfDeliveryNotFound(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+
+	h := New(newProductStorage(t), newOrderStorage(t), l)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusNotFound)
+	}
+
+	expected := "can't find product with id= 1"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("costOfDelivery handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func TestCostOfDeliveryIncorrectID(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/-1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+
+	place := "Тверской бульвар, 25"
+
+	p := &product.Product{
+		ID:    1,
+		Place: place,
+	}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusBadRequest)
+	}
+
+	expected := fmt.Sprintf("incorrect id= %v", -1)
+	if p := problem(t, rr); p.Code != ehttp.CodeInvalidID || p.Detail != expected {
+		t.Errorf("costOfDelivery handler returned unexpected error: got %v %v, want %v %v",
+			p.Code, p.Detail, ehttp.CodeInvalidID, expected)
+	}
+}
+
+func TestCostOfDeliveryIncorrectJSON(t *testing.T) {
+	// body contains incorrect json(missing a open bracket)
+	json := []byte(`"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+
+	place := "Тверской бульвар, 25"
+
+	p := &product.Product{
+		ID:    1,
+		Place: place,
+	}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusBadRequest)
+	}
+
+	if p := problem(t, rr); p.Code != ehttp.CodeMalformedJSON {
+		t.Errorf("costOfDelivery handler returned unexpected error code: got %v, want %v", p.Code, ehttp.CodeMalformedJSON)
+	}
+}
+
+func TestCostOfDeliveryPromoCode(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "promo_code" : "FREE"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+	mockPromoStorage := new(mockPromoStorage)
+
+	p := &product.Product{
+		ID:    1,
+		Place: "Тверской бульвар, 25",
+	}
+
+	c := &promo.Code{
+		ID:        1,
+		Code:      "FREE",
+		Type:      promo.FreeDelivery,
+		ValidFrom: time.Now().Add(-time.Hour),
+	}
+
+	mockPromoStorage.c = c
+	mockPromoStorage.u = &promo.Usage{}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t), l, WithPromo(mockPromoStorage))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `"price":0,`
+	if !respContains(rr.Body.String(), expected) {
+		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestCostOfDeliveryPromoCodeUsageLimit(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "promo_code" : "ONCE"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+	mockPromoStorage := new(mockPromoStorage)
+
+	p := &product.Product{
+		ID:    1,
+		Place: "Тверской бульвар, 25",
+	}
+
+	c := &promo.Code{
+		ID:        1,
+		Code:      "ONCE",
+		Type:      promo.Percent,
+		Value:     10,
+		ValidFrom: time.Now().Add(-time.Hour),
+		MaxUses:   1,
+	}
+
+	mockPromoStorage.c = c
+	mockPromoStorage.u = &promo.Usage{Total: 1}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t), l, WithPromo(mockPromoStorage))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+
+	expected := "promo code usage limit is reached"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("costOfDelivery handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func TestCostOfDeliverySurge(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+	mockCourierStorage := new(mockCourierStorage)
+	mockSurgeStorage := new(mockSurgeStorage)
+
+	p := &product.Product{
+		ID:    1,
+		Place: "Тверской бульвар, 25",
+	}
+
+	orders := newOrderStorage(t)
+	mockCourierStorage.available = 1
+
+	h := New(newProductStorage(t, p), orders, l,
+		WithSurge(surge.New(surge.DefaultConfiguration), mockSurgeStorage, mockCourierStorage))
+
+	at := time.Date(2020, 6, 15, 13, 0, 0, 0, time.UTC)
+	h.now = func() time.Time {
+		return at
+	}
+
+	dest, err := h.locate("Большая Садовая, 302-бис, пятый этаж, кв. № 50")
+	if err != nil {
+		t.Fatalf("can't locate destination %v", err)
+	}
+
+	// заказ в другой зоне и доставленный заказ спрос не создают
+	oo := []*order.Order{
+		{Zone: dest.zone, Time: ftime.New(at)},
+		{Zone: dest.zone, Time: ftime.New(at.Add(10 * time.Minute))},
+		{Zone: dest.zone, Time: ftime.New(at.Add(20 * time.Minute)), Status: order.Assigned},
+		{Zone: dest.zone, Time: ftime.New(at.Add(30 * time.Minute))},
+		{Zone: dest.zone + "-other", Time: ftime.New(at)},
+		{Zone: dest.zone, Time: ftime.New(at), Status: order.Delivered},
+	}
+
+	for _, o := range oo {
+		if err = orders.Create(context.Background(), o); err != nil {
+			t.Fatalf("can't create order %v", err)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	// 4 заказа на одного курьера: 1 + (4 - 1) * 0.25
+	expected := `"surge":1.75,`
+	if !respContains(rr.Body.String(), expected) {
+		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestCostOfDeliverySurgeAtDeliveryTime(t *testing.T) {
+	l := new(mockLogger)
+	mockCourierStorage := &mockCourierStorage{available: 1}
+
+	p := &product.Product{
+		ID:    1,
+		Place: "Тверской бульвар, 25",
+	}
+
+	orders := newOrderStorage(t)
+
+	h := New(newProductStorage(t, p), orders, l,
+		WithSurge(surge.New(surge.DefaultConfiguration), new(mockSurgeStorage), mockCourierStorage))
+
+	now := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	h.now = func() time.Time {
+		return now
+	}
+
+	dest, err := h.locate("Большая Садовая, 302-бис, пятый этаж, кв. № 50")
+	if err != nil {
+		t.Fatalf("can't locate destination %v", err)
+	}
+
+	// спрос есть только на время доставки, а не на текущее время
+	at := now.Add(3 * time.Hour)
+	for i := 0; i < 4; i++ {
+		o := &order.Order{Zone: dest.zone, Time: ftime.New(at.Add(time.Duration(i) * 10 * time.Minute))}
+		if err = orders.Create(context.Background(), o); err != nil {
+			t.Fatalf("can't create order %v", err)
+		}
+	}
+
+	serve := func(f handlerFunc, url string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(MWError(f, l)).ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	type price struct {
+		Price     int                `json:"price"`
+		Breakdown *pricing.Breakdown `json:"breakdown"`
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected float64
+	}{
+		{name: "without time", body: `{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`,
+			expected: 1},
+		{name: "delivery time", body: `{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50",
+			"time" : "2020-06-15T13:00:00Z"}`, expected: 1.75},
+	}
+
+	var quoted price
+
+	for _, tt := range tests {
+		rr := serve(h.costOfDelivery, "/api/v1/products/1/cost-of-delivery", tt.body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("costOfDelivery handler %v returned wrong status code: got %v, want %v",
+				tt.name, rr.Code, http.StatusOK)
+		}
+
+		quoted = price{}
+		if err = json.Unmarshal(rr.Body.Bytes(), &quoted); err != nil {
+			t.Fatalf("can't unmarshal cost of delivery %v", err)
+		}
+
+		if quoted.Breakdown.Surge != tt.expected {
+			t.Errorf("costOfDelivery handler %v returned surge %v, want %v",
+				tt.name, quoted.Breakdown.Surge, tt.expected)
+		}
+	}
+
+	rr := serve(h.createOrder, "/api/v1/products/1/order",
+		`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:00:00Z"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("createOrder handler returned wrong status code: got %v, want %v", rr.Code, http.StatusCreated)
+	}
+
+	var ordered price
+	if err = json.Unmarshal(rr.Body.Bytes(), &ordered); err != nil {
+		t.Fatalf("can't unmarshal created order %v", err)
+	}
+
+	if ordered.Price != quoted.Price {
+		t.Errorf("createOrder handler charged %v, want quoted price %v", ordered.Price, quoted.Price)
+	}
+}
+
+func TestSetSurgeForbidden(t *testing.T) {
+	json := []byte(`{"mode" : "override", "multiplier" : 1.5}`)
+	req, err := http.NewRequest("PUT", "/api/v1/admin/surge/center", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 1}
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("setSurge handler returned wrong status code: got %v, want %v",
+			status, http.StatusForbidden)
+	}
+
+	expected := `role "courier" has no access to this resource`
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("setSurge handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func TestGetSurgeOverride(t *testing.T) {
+	expired := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		o          *surge.Override
+		multiplier string
+	}{
+		{name: "without override", multiplier: `"multiplier":1,`},
+		{name: "active override", o: &surge.Override{Zone: "center", Mode: surge.ModeOverride, Multiplier: 1.5},
+			multiplier: `"multiplier":1.5,`},
+		{name: "expired override", o: &surge.Override{Zone: "center", Mode: surge.ModeOverride, Multiplier: 1.5,
+			ExpiresAt: &expired}, multiplier: `"multiplier":1,`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/api/v1/admin/surge/center", nil)
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
+
+			req.Header.Set("Authorization", "Bearer admin-key")
+
+			mockAuthStorage := &mockAuthStorage{p: &auth.Principal{ID: 5, Role: auth.Admin}}
+			mockCourierStorage := &mockCourierStorage{available: 1}
+
+			h := New(newProductStorage(t), newOrderStorage(t), new(mockLogger), WithAuth(mockAuthStorage),
+				WithSurge(surge.New(surge.DefaultConfiguration), mockSurgeStorage{o: tt.o}, mockCourierStorage))
+			h.now = func() time.Time {
+				return expired.Add(time.Hour)
+			}
+
+			rr := httptest.NewRecorder()
+
+			h.Routes().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("getSurge handler returned wrong status code: got %v, want %v",
+					status, http.StatusOK)
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.multiplier) {
+				t.Errorf("getSurge handler returned unexpected body: got %v, want %v", rr.Body.String(), tt.multiplier)
+			}
+		})
+	}
+}
+
+func TestGetCourierRoute(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/couriers/me/route", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{
+		ID:       7,
+		Vehicle:  courier.Bike,
+		Zone:     "center",
+		Location: &geo.Point{Lat: 55.75, Lon: 37.6},
+	}
+
+	from := ftime.New(time.Date(2020, 6, 15, 17, 0, 0, 0, time.UTC))
+	to := ftime.New(time.Date(2020, 6, 15, 19, 0, 0, 0, time.UTC))
+	orders := newOrderStorage(t,
+		&order.Order{From: "Большой Патриарший пер., 7", Destination: "Тверская, 1", Time: from, TimeTo: to,
+			CourierID: 7, Status: order.Assigned},
+		&order.Order{From: "Арбат, 10", Destination: "Большая Садовая, 302-бис", Time: to,
+			CourierID: 7, Status: order.Assigned},
+		&order.Order{From: "Арбат, 10", Destination: "Тверская, 1", Time: to, CourierID: 8, Status: order.Assigned},
+	)
+
+	h := New(newProductStorage(t), orders, l, WithAuth(mockAuthStorage),
+		WithRouting(routing.New(routing.DefaultConfiguration), mockCourierStorage))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 16, 0, 0, 0, time.UTC)
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("getRoute handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+
+	expected := `{"courier_id":7,"start":{"lat":55.75,"lon":37.6}`
+	if !strings.HasPrefix(body, expected) {
+		t.Errorf("getRoute handler returned unexpected body: got %v, want prefix %v", body, expected)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		pickup := strings.Index(body, `{"order_id":`+id+`,"kind":"pickup"`)
+		dropoff := strings.Index(body, `{"order_id":`+id+`,"kind":"dropoff"`)
+
+		if pickup == -1 || dropoff == -1 || pickup > dropoff {
+			t.Errorf("getRoute handler must pick up order %v before delivering it: got %v", id, body)
+		}
+	}
+
+	if strings.Contains(body, `"order_id":3`) {
+		t.Errorf("getRoute handler returned order of another courier: got %v", body)
+	}
+
+	window := `"window_from":"2020-06-15T19:00:00Z","window_to":"2020-06-15T19:00:00Z"`
+	if !strings.Contains(body, window) {
+		t.Errorf("getRoute handler returned unexpected body: got %v, want %v", body, window)
+	}
+}
+
+func TestGetCourierRouteCarriedOrder(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/couriers/me/route", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := &mockAuthStorage{p: &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}}
+	mockCourierStorage := &mockCourierStorage{c: &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}}
+
+	now := time.Date(2020, 6, 15, 16, 0, 0, 0, time.UTC)
+	pickedUp := now.Add(-10 * time.Minute)
+	to := ftime.New(time.Date(2020, 6, 15, 19, 0, 0, 0, time.UTC))
+	orders := newOrderStorage(t,
+		&order.Order{From: "Большой Патриарший пер., 7", Destination: "Тверская, 1", Time: to,
+			CourierID: 7, Status: order.Assigned, PickedUpAt: &pickedUp},
+		&order.Order{From: "Арбат, 10", Destination: "Большая Садовая, 302-бис", Time: to,
+			CourierID: 7, Status: order.Assigned},
+	)
+
+	h := New(newProductStorage(t), orders, l, WithAuth(mockAuthStorage),
+		WithRouting(routing.New(routing.DefaultConfiguration), mockCourierStorage))
+	h.now = func() time.Time {
+		return now
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("getRoute handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+
+	if strings.Contains(body, `{"order_id":1,"kind":"pickup"`) {
+		t.Errorf("getRoute handler returned pickup of carried order 1: got %v", body)
+	}
+
+	for _, stop := range []string{`{"order_id":1,"kind":"dropoff"`, `{"order_id":2,"kind":"pickup"`} {
+		if !strings.Contains(body, stop) {
+			t.Errorf("getRoute handler returned unexpected body: got %v, want %v", body, stop)
+		}
+	}
+}
+
+func TestPickUpOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+	}{
+		{name: "current version", ifMatch: `"1"`, status: http.StatusNoContent},
+		{name: "another version", ifMatch: `"2"`, status: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/v1/couriers/me/orders/1/picked-up", http.NoBody)
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
+
+			req.Header.Set("Authorization", "Bearer courier-key")
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			l := new(mockLogger)
+			mockAuthStorage := &mockAuthStorage{p: &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}}
+			mockCourierStorage := &mockCourierStorage{c: &courier.Courier{ID: 7, Vehicle: courier.Bike}}
+			orders := newOrderStorage(t, &order.Order{CourierID: 7, Status: order.Assigned})
+
+			h := New(newProductStorage(t), orders, l, WithAuth(mockAuthStorage),
+				WithRouting(routing.New(routing.DefaultConfiguration), mockCourierStorage))
+
+			rr := httptest.NewRecorder()
+
+			h.Routes().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("pickUpOrder handler returned wrong status code: got %v, want %v",
+					status, tt.status)
+			}
+
+			if tt.status == http.StatusNoContent && rr.Header().Get("ETag") != `"2"` {
+				t.Errorf("pickUpOrder handler returned unexpected ETag: got %v, want %v",
+					rr.Header().Get("ETag"), `"2"`)
+			}
+		})
+	}
+}
+
+func TestGetCourierRouteForbidden(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/couriers/me/route", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer seller-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockAuthStorage.p = &auth.Principal{ID: 2, Role: auth.Seller, SubjectID: 1}
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithRouting(routing.New(routing.DefaultConfiguration), new(mockCourierStorage)))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("getRoute handler returned wrong status code: got %v, want %v",
+			status, http.StatusForbidden)
+	}
+}
+
+func TestDeleteAssignment(t *testing.T) {
+	json := []byte(`{"reason" : "courier is sick"}`)
+	req, err := http.NewRequest("DELETE", "/api/v1/admin/orders/2/assignment", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer admin-key")
+	req.Header.Set("If-Match", `"4"`)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockDispatchStorage := new(mockDispatchStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 5, Role: auth.Admin}
+	mockDispatchStorage.courierID = 7
+	mockDispatchStorage.version = 4
+
+	h := New(newProductStorage(t), newOrderStorage(t), l,
+		WithAuth(mockAuthStorage), WithDispatch(mockDispatchStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("deleteAssignment handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"id":3,"order_id":2,"courier_id":7,"action":"unassign","actor":"admin:5",` +
+		`"reason":"courier is sick","ip":"192.0.2.1","created_at":"2020-06-15T12:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("deleteAssignment handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+
+	if tag := rr.Header().Get("ETag"); tag != `"5"` {
+		t.Errorf("deleteAssignment handler returned unexpected ETag: got %v, want %v", tag, `"5"`)
+	}
+}
+
+func TestDeleteAssignmentLongReason(t *testing.T) {
+	json := []byte(fmt.Sprintf(`{"reason" : "%s"}`, strings.Repeat("я", 501)))
+	req, err := http.NewRequest("DELETE", "/api/v1/admin/orders/2/assignment", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer admin-key")
+	req.Header.Set("If-Match", `"4"`)
+
+	l := new(mockLogger)
+	mockAuthStorage := &mockAuthStorage{p: &auth.Principal{ID: 5, Role: auth.Admin}}
+	mockDispatchStorage := &mockDispatchStorage{courierID: 7, version: 4}
+
+	h := New(newProductStorage(t), newOrderStorage(t), l,
+		WithAuth(mockAuthStorage), WithDispatch(mockDispatchStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("deleteAssignment handler returned wrong status code: got %v, want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+
+	p := problem(t, rr)
+	if len(p.Violations) != 1 || p.Violations[0].Field != "reason" || p.Violations[0].Rule != validation.RuleMaxLength {
+		t.Errorf("deleteAssignment handler returned unexpected violations: got %v", p.Violations)
+	}
+}
+
+func TestDeleteAssignmentIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifMatch  string
+		status   int
+		code     ehttp.Code
+		expected string
+	}{
+		{"missing", "", http.StatusPreconditionRequired, ehttp.CodePreconditionRequired,
+			"If-Match header with order ETag is required"},
+		{"malformed", "4", http.StatusBadRequest, ehttp.CodeBadRequest, "If-Match header must contain order ETag"},
+		{"stale", `"3"`, http.StatusPreconditionFailed, ehttp.CodePreconditionFailed,
+			"order has been changed, get it again and retry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("DELETE", "/api/v1/admin/orders/2/assignment", http.NoBody)
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
+
+			req.Header.Set("Authorization", "Bearer admin-key")
+
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			l := new(mockLogger)
+			mockAuthStorage := &mockAuthStorage{p: &auth.Principal{ID: 5, Role: auth.Admin}}
+			mockDispatchStorage := &mockDispatchStorage{courierID: 7, version: 4}
+
+			h := New(newProductStorage(t), newOrderStorage(t), l,
+				WithAuth(mockAuthStorage), WithDispatch(mockDispatchStorage))
+
+			rr := httptest.NewRecorder()
+
+			h.Routes().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("deleteAssignment handler returned wrong status code: got %v, want %v",
+					status, tt.status)
+			}
+
+			if p := problem(t, rr); p.Code != tt.code || p.Detail != tt.expected {
+				t.Errorf("deleteAssignment handler returned unexpected error: got %v %v, want %v %v",
+					p.Code, p.Detail, tt.code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDeleteAssignmentNotAssigned(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/api/v1/admin/orders/2/assignment", http.NoBody)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer admin-key")
+	req.Header.Set("If-Match", `"1"`)
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockAuthStorage.p = &auth.Principal{ID: 5, Role: auth.Admin}
+
+	h := New(newProductStorage(t), newOrderStorage(t), l,
+		WithAuth(mockAuthStorage), WithDispatch(new(mockDispatchStorage)))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("deleteAssignment handler returned wrong status code: got %v, want %v",
+			status, http.StatusConflict)
+	}
+
+	expected := "order with id= 2 has no courier"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("deleteAssignment handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func TestClockIn(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/v1/couriers/me/shift/clock-in", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+	mockShiftStorage := new(mockShiftStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+	mockShiftStorage.s = &shift.Shift{
+		ID:        4,
+		CourierID: 7,
+		Zones:     []string{"center"},
+		Start:     time.Date(2020, 6, 15, 9, 0, 0, 0, time.UTC),
+		End:       time.Date(2020, 6, 15, 17, 0, 0, 0, time.UTC),
+	}
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithShifts(shift.DefaultConfiguration, mockShiftStorage, mockCourierStorage))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 45, 0, 0, time.UTC)
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("clockIn handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"id":4,"courier_id":7,"zones":["center"],"start":"2020-06-15T09:00:00Z","end":"2020-06-15T17:00:00Z",` +
+		`"clock_in":"2020-06-15T08:45:00Z","status":"active"}`
+	if rr.Body.String() != expected {
+		t.Errorf("clockIn handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestClockInTwice(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/v1/couriers/me/shift/clock-in", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+	mockShiftStorage := new(mockShiftStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+	mockShiftStorage.s = &shift.Shift{ID: 4, CourierID: 7, Zones: []string{"center"}}
+	mockShiftStorage.err = shift.ErrClockedIn
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithShifts(shift.DefaultConfiguration, mockShiftStorage, mockCourierStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("clockIn handler returned wrong status code: got %v, want %v",
+			status, http.StatusConflict)
+	}
+
+	expected := "already clocked in"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("clockIn handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func TestGetShiftCoverage(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/shifts/coverage?days=1", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer seller-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockShiftStorage := new(mockShiftStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 2, Role: auth.Seller, SubjectID: 1}
+	mockShiftStorage.planned = []*shift.Shift{
+		{
+			ID:        4,
+			CourierID: 1,
+			Zones:     []string{"center"},
+			Start:     time.Date(2020, 6, 15, 9, 0, 0, 0, time.UTC),
+			End:       time.Date(2020, 6, 15, 19, 0, 0, 0, time.UTC),
+		},
+		{
+			ID:        5,
+			CourierID: 2,
+			Zones:     []string{"center", "north"},
+			Start:     time.Date(2020, 6, 15, 18, 0, 0, 0, time.UTC),
+			End:       time.Date(2020, 6, 15, 21, 0, 0, 0, time.UTC),
+		},
+		// вторая смена курьера 1 в том же интервале не добавляет курьеров
+		{
+			ID:        6,
+			CourierID: 1,
+			Zones:     []string{"center"},
+			Start:     time.Date(2020, 6, 15, 19, 30, 0, 0, time.UTC),
+			End:       time.Date(2020, 6, 15, 21, 0, 0, 0, time.UTC),
+		},
+	}
+
+	zones := geo.Zones{{Name: "center", City: "moscow", Bounds: geo.MoscowBounds}}
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithGeo(geo.NewHashGeocoder(geo.MoscowBounds), zones), WithCalendars(newTestCalendars(t)),
+		WithShifts(shift.Configuration{CoverageStep: ftime.Duration{Duration: 4 * time.Hour}, CoverageDays: 7},
+			mockShiftStorage, new(mockCourierStorage)))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getCoverage handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `[{"zone":"center","intervals":[` +
+		`{"from":"2020-06-15T09:00:00Z","to":"2020-06-15T13:00:00Z","couriers":1},` +
+		`{"from":"2020-06-15T13:00:00Z","to":"2020-06-15T17:00:00Z","couriers":1},` +
+		`{"from":"2020-06-15T17:00:00Z","to":"2020-06-15T21:00:00Z","couriers":2}]}]`
+	if rr.Body.String() != expected {
+		t.Errorf("getCoverage handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestDeliverOrder(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/v1/couriers/me/orders/1/delivered", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+	req.Header.Set("If-Match", `"1"`)
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	arrived := now.Add(-16 * time.Minute)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+
+	orders := newOrderStorage(t, &order.Order{From: "Арбат, 10", Destination: "Арбат, 10",
+		CourierID: 7, Status: order.Assigned, ArrivedAt: &arrived})
+
+	h := New(newProductStorage(t), orders, l, WithAuth(mockAuthStorage),
+		WithEarnings(earnings.New(earnings.DefaultConfiguration), new(mockEarningsStorage), mockCourierStorage))
+	h.now = func() time.Time {
+		return now
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("deliverOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"order_id":1,"courier_id":7,"delivered_at":"2020-06-15T12:00:00Z","distance":0,"waiting":"16m0s",` +
+		`"base":150,"distance_fee":0,"waiting_bonus":30,"total":180}`
+	if rr.Body.String() != expected {
+		t.Errorf("deliverOrder handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+
+	if tag := rr.Header().Get("ETag"); tag != `"2"` {
+		t.Errorf("deliverOrder handler returned unexpected ETag: got %v, want %v", tag, `"2"`)
+	}
+}
+
+func TestDeliverOrderStale(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/v1/couriers/me/orders/1/delivered", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+	req.Header.Set("If-Match", `"2"`)
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+
+	orders := newOrderStorage(t, &order.Order{CourierID: 7, Status: order.Assigned})
+
+	h := New(newProductStorage(t), orders, l, WithAuth(mockAuthStorage),
+		WithEarnings(earnings.New(earnings.DefaultConfiguration), new(mockEarningsStorage), mockCourierStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("deliverOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusPreconditionFailed)
+	}
+
+	expected := "order has been changed, get it again and retry"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("deliverOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func TestDeliverOrderOfAnotherCourier(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/v1/couriers/me/orders/1/delivered", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+
+	orders := newOrderStorage(t, &order.Order{CourierID: 8, Status: order.Assigned})
+
+	h := New(newProductStorage(t), orders, l, WithAuth(mockAuthStorage),
+		WithEarnings(earnings.New(earnings.DefaultConfiguration), new(mockEarningsStorage), mockCourierStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("deliverOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestGetEarnings(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/couriers/me/earnings?from=2020-06-01&to=2020-06-30", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+	mockEarningsStorage := new(mockEarningsStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+	mockEarningsStorage.ee = newTestEarnings()
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithEarnings(earnings.New(earnings.DefaultConfiguration), mockEarningsStorage, mockCourierStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getEarnings handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"courier_id":7,"days":[` +
+		`{"date":"2020-06-15","deliveries":2,"distance":4.75,"base":300,"distance_fee":72,"waiting_bonus":25,"total":397},` +
+		`{"date":"2020-06-16","deliveries":1,"distance":2,"base":150,"distance_fee":30,"waiting_bonus":0,"total":180}],` +
+		`"total":577}`
+	if rr.Body.String() != expected {
+		t.Errorf("getEarnings handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestGetStatement(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/couriers/me/statements/2020-06", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer courier-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := new(mockAuthStorage)
+	mockCourierStorage := new(mockCourierStorage)
+	mockEarningsStorage := new(mockEarningsStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 7}
+	mockCourierStorage.c = &courier.Courier{ID: 7, Vehicle: courier.Bike, Zone: "center"}
+	mockEarningsStorage.ee = newTestEarnings()
+
+	h := New(newProductStorage(t), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithEarnings(earnings.New(earnings.DefaultConfiguration), mockEarningsStorage, mockCourierStorage))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getStatement handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := "order_id,delivered_at,distance_km,waiting_min,base,distance_fee,waiting_bonus,total\n" +
+		"1,2020-06-15T10:00:00Z,3.50,0,150,53,0,203\n" +
+		"2,2020-06-15T12:00:00Z,1.25,15,150,19,25,194\n" +
+		"3,2020-06-16T09:30:00Z,2.00,0,150,30,0,180\n" +
+		"total,,6.75,,450,102,25,577\n"
+	if rr.Body.String() != expected {
+		t.Errorf("getStatement handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+// buyerKey - API-ключ покупателя 42, которому принадлежит заказ из newRatedOrderHandler
+var buyerKey = &auth.Principal{ID: 3, Role: auth.Buyer, SubjectID: 42}
+
+func newRatedOrderHandler(t *testing.T, ratings rating.Storage, p *auth.Principal) *Handler {
+	t.Helper()
+
+	delivered := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	orders := newOrderStorage(t, &order.Order{From: "Арбат, 10", Destination: "Тверская, 1", Buyer: "42",
+		CourierID: 7, Status: order.Delivered, DeliveredAt: &delivered})
+
+	h := New(newProductStorage(t), orders, new(mockLogger), WithAuth(mockAuthStorage{p: p}),
+		WithRatings(rating.New(rating.DefaultConfiguration, ratings)))
+	h.now = func() time.Time {
+		return delivered.Add(time.Hour)
+	}
+
+	return h
+}
+
+func TestRateOrder(t *testing.T) {
+	body := bytes.NewBufferString(`{"score": 5, "tags": ["on_time", "polite"], "comment": "спасибо"}`)
+
+	req, err := http.NewRequest("POST", "/api/v1/orders/1/rating", body)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer buyer-key")
+
+	h := newRatedOrderHandler(t, new(mockRatingStorage), buyerKey)
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("rateOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusCreated)
+	}
+
+	expected := `{"order_id":1,"courier_id":7,"pickup":"Арбат, 10","score":5,"tags":["on_time","polite"],` +
+		`"comment":"спасибо","created_at":"2020-06-15T13:00:00Z"}`
+	if rr.Body.String() != expected {
+		t.Errorf("rateOrder handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestRateOrderTwice(t *testing.T) {
+	body := bytes.NewBufferString(`{"score": 4}`)
+
+	req, err := http.NewRequest("POST", "/api/v1/orders/1/rating", body)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer buyer-key")
+
+	h := newRatedOrderHandler(t, &mockRatingStorage{err: rating.ErrRated}, buyerKey)
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("rateOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusConflict)
+	}
+}
+
+func TestRateOrderIncorrect(t *testing.T) {
+	anotherBuyer := &auth.Principal{ID: 4, Role: auth.Buyer, SubjectID: 43}
+	courierKey := &auth.Principal{ID: 5, Role: auth.Courier, SubjectID: 7}
+
+	tests := []struct {
+		name   string
+		p      *auth.Principal
+		body   string
+		status int
+	}{
+		{name: "score out of range", p: buyerKey, body: `{"score": 6}`, status: http.StatusUnprocessableEntity},
+		{name: "unknown tag", p: buyerKey, body: `{"score": 3, "tags": ["fast"]}`, status: http.StatusUnprocessableEntity},
+		{name: "without api key", body: `{"score": 3}`, status: http.StatusUnauthorized},
+		{name: "courier api key", p: courierKey, body: `{"score": 3}`, status: http.StatusForbidden},
+		{name: "another buyer", p: anotherBuyer, body: `{"score": 3}`, status: http.StatusForbidden},
+		{name: "buyer in body", p: anotherBuyer, body: `{"buyer": "42", "score": 3}`, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/v1/orders/1/rating", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
+
+			if tt.p != nil {
+				req.Header.Set("Authorization", "Bearer some-key")
+			}
+
+			h := newRatedOrderHandler(t, new(mockRatingStorage), tt.p)
+
+			rr := httptest.NewRecorder()
+
+			h.Routes().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("rateOrder handler returned wrong status code: got %v, want %v",
+					status, tt.status)
+			}
+		})
+	}
+}
+
+func TestGetCourierRatings(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/ratings/couriers", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer seller-key")
+
+	mockAuthStorage := new(mockAuthStorage)
+	mockRatingStorage := new(mockRatingStorage)
+
+	mockAuthStorage.p = &auth.Principal{ID: 2, Role: auth.Seller, SubjectID: 1}
+	mockRatingStorage.couriers = []*rating.Aggregate{
+		{CourierID: 7, Count: 3, Average: 4.67, Tags: map[string]int{"on_time": 2}},
+	}
+
+	h := New(newProductStorage(t), newOrderStorage(t), new(mockLogger), WithAuth(mockAuthStorage),
+		WithRatings(rating.New(rating.DefaultConfiguration, mockRatingStorage)))
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getRatings handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"window":"720h0m0s","ratings":[{"courier_id":7,"count":3,"average":4.67,"tags":{"on_time":2}}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("getRatings handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestCreateOrderCorrect(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+
+	place := "Тверской бульвар, 25"
+
+	p := &product.Product{
+		ID:    1,
+		Name:  "Название",
+		Place: place,
+	}
+
+	orders := newOrderStorage(t)
+
+	h := New(newProductStorage(t, p), orders, l)
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.createOrder, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusCreated)
+	}
+
+	if got := rr.Header().Get("Location"); got != "/api/v1/orders/1" {
+		t.Errorf("createOrder handler returned wrong Location: got %q, want %q", got, "/api/v1/orders/1")
+	}
+
+	if got := rr.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("createOrder handler returned wrong ETag: got %v, want %v", got, `"1"`)
+	}
+
+	expected := `{"id":1,"product":{"id":1,"name":"Название","width":0,"length":0,"height":0,"weight":0,` +
+		`"place":"Тверской бульвар, 25"},"from":"Тверской бульвар, 25",` +
+		`"destination":"Большая Садовая, 302-бис, пятый этаж, кв. № 50","time":"2020-06-15T13:30:00Z",` +
+		`"price":300,"status":"confirmed"}`
+	if rr.Body.String() != expected {
+		t.Errorf("createOrder handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+
+	o, err := orders.FindByID(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("createOrder handler didn't save order: %v", err)
+	}
+
+	if o.ProductID != 1 || o.Price != 300 || o.Status != order.Confirmed {
+		t.Errorf("createOrder handler saved unexpected order %+v", o)
+	}
+}
+
+func TestCreateOrderInUnitOfWork(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+	uow := new(mockUnitOfWork)
+
+	h := New(newProductStorage(t, &product.Product{Name: "Название", Place: "Тверской бульвар, 25"}), newOrderStorage(t), l, WithUnitOfWork(uow))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.createOrder, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusCreated)
+	}
+
+	if uow.calls != 1 {
+		t.Errorf("createOrder handler ran %v transactions, want 1", uow.calls)
+	}
+}
+
+func TestCreateOrderPromoCode(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", ` +
+		`"time" : "2020-06-15T13:30:00Z", "promo_code" : "ONCE"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer buyer-key")
+
+	l := new(mockLogger)
+	mockAuthStorage := &mockAuthStorage{p: &auth.Principal{ID: 1, Role: auth.Buyer, SubjectID: 42}}
+	mockPromoStorage := new(mockPromoStorage)
+	uow := new(mockUnitOfWork)
+
+	mockPromoStorage.c = &promo.Code{
+		ID:              1,
+		Code:            "ONCE",
+		Type:            promo.Fixed,
+		Value:           100,
+		ValidFrom:       time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		MaxUsesPerBuyer: 1,
+	}
+	mockPromoStorage.u = &promo.Usage{}
+
+	h := New(newProductStorage(t, &product.Product{Name: "Название", Place: "Тверской бульвар, 25"}), newOrderStorage(t), l, WithAuth(mockAuthStorage),
+		WithPromo(mockPromoStorage), WithUnitOfWork(uow))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.Routes().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("createOrder handler returned wrong status code: got %v, want %v, body %v",
+			status, http.StatusCreated, rr.Body.String())
+	}
+
+	if len(mockPromoStorage.reserved) != 1 {
+		t.Fatalf("createOrder handler reserved promo code %v times, want 1", len(mockPromoStorage.reserved))
+	}
+
+	if r := mockPromoStorage.reserved[0]; r.OrderID != 1 || r.Buyer != "42" {
+		t.Errorf("createOrder handler reserved promo code for order %v and buyer %q, want 1 and %q",
+			r.OrderID, r.Buyer, "42")
+	}
+
+	if !mockPromoStorage.inTx {
+		t.Errorf("createOrder handler reserved promo code outside the order transaction")
+	}
+
+	if uow.calls != 1 {
+		t.Errorf("createOrder handler ran %v transactions, want 1", uow.calls)
+	}
+}
+
+func TestCreateOrderBuyerRequiresKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    *auth.Principal
+		status int
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized},
+		{name: "not a buyer", key: &auth.Principal{ID: 1, Role: auth.Courier, SubjectID: 42}, status: http.StatusForbidden},
+		{name: "another buyer", key: &auth.Principal{ID: 1, Role: auth.Buyer, SubjectID: 7}, status: http.StatusForbidden},
+		{name: "same buyer", key: &auth.Principal{ID: 1, Role: auth.Buyer, SubjectID: 42}, status: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			json := []byte(`{"destination" : "Арбат, 10", "time" : "2020-06-15T13:30:00Z", "buyer" : "42"}`)
+			req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
+
+			if tt.key != nil {
+				req.Header.Set("Authorization", "Bearer key")
+			}
+
+			l := new(mockLogger)
+			products := newProductStorage(t, &product.Product{Place: "Тверской бульвар, 25"})
+
+			h := New(products, newOrderStorage(t), l, WithAuth(mockAuthStorage{p: tt.key}))
+			h.now = func() time.Time {
+				return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+			}
+
+			rr := httptest.NewRecorder()
+
+			h.Routes().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+					status, tt.status)
+			}
+		})
+	}
+}
+
+func TestCreateAndGetOrderInMemory(t *testing.T) {
+	products := memory.NewProductStorage()
+	orders := memory.NewOrderStorage()
+
+	for _, name := range []string{"Сноуборд", "Лыжи"} {
+		if err := products.Create(&product.Product{Name: name, Place: "Тверской бульвар, 25"}); err != nil {
+			t.Fatalf("can't create product %v", err)
+		}
+	}
+
+	h := New(products, orders, new(mockLogger))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	serve := func(method string, url string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	for _, id := range []int{2, 1} {
+		url := fmt.Sprintf("/api/v1/products/%d/order", id)
+		rr := serve("POST", url, `{"destination" : "Арбат, 10", "time" : "2020-06-15T13:30:00Z"}`)
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("createOrder handler returned wrong status code: got %v, want %v",
+				status, http.StatusCreated)
+		}
+	}
+
+	rr := serve("GET", "/api/v1/orders", "")
+
+	expected := `[{"id":1,"product_id":2,"name":"Лыжи"},{"id":2,"product_id":1,"name":"Сноуборд"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("getOrders handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+
+	rr = serve("GET", "/api/v1/orders/2", "")
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), `"product":{"id":1,"name":"Сноуборд"`) {
+		t.Errorf("getOrder handler returned unexpected body: got %v", rr.Body.String())
+	}
+
+	rr = serve("GET", "/api/v1/orders/3", "")
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("getOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestCreateOrderIdempotent(t *testing.T) {
+	products := memory.NewProductStorage()
+	orders := memory.NewOrderStorage()
+
+	if err := products.Create(&product.Product{Name: "Сноуборд", Place: "Тверской бульвар, 25"}); err != nil {
+		t.Fatalf("can't create product %v", err)
+	}
+
+	keys := memory.NewIdempotencyStorage()
+	keeper := idempotency.New(idempotency.DefaultConfiguration, keys)
+
+	h := New(products, orders, new(mockLogger), WithIdempotency(keeper))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	serveFrom := func(ip string, key string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		req.Header.Set("Idempotency-Key", key)
+		req.RemoteAddr = ip + ":1234"
+
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	serve := func(key string, body string) *httptest.ResponseRecorder {
+		return serveFrom("192.0.2.1", key, body)
+	}
+
+	body := `{"destination" : "Арбат, 10", "time" : "2020-06-15T13:30:00Z"}`
+
+	var first string
+
+	for i, replayed := range []string{"", "true"} {
+		rr := serve("a5b3c1", body)
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("createOrder handler returned wrong status code on try %d: got %v, want %v",
+				i+1, status, http.StatusCreated)
+		}
+
+		if got := rr.Header().Get("Idempotent-Replayed"); got != replayed {
+			t.Errorf("createOrder handler returned wrong Idempotent-Replayed on try %d: got %q, want %q",
+				i+1, got, replayed)
+		}
+
+		if got := rr.Header().Get("Location"); got != "/api/v1/orders/1" {
+			t.Errorf("createOrder handler returned wrong Location on try %d: got %q", i+1, got)
+		}
+
+		if first == "" {
+			first = rr.Body.String()
+		} else if rr.Body.String() != first {
+			t.Errorf("createOrder handler replayed unexpected body: got %v, want %v", rr.Body.String(), first)
+		}
+	}
+
+	all, err := orders.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("can't get orders %v", err)
+	}
+
+	if len(all) != 1 {
+		t.Errorf("createOrder handler created %v orders, want 1", len(all))
+	}
+
+	rr := serve("a5b3c1", `{"destination" : "Арбат, 12", "time" : "2020-06-15T13:30:00Z"}`)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+
+	rr = serve("d4e2f0", body)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusCreated)
+	}
+
+	// тот же ключ другого клиента не получает чужой ответ
+	rr = serveFrom("192.0.2.2", "a5b3c1", `{"destination" : "Арбат, 12", "time" : "2020-06-15T13:30:00Z"}`)
+
+	if status := rr.Code; status != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("createOrder handler returned wrong status code for another client: got %v, want %v",
+			status, http.StatusCreated)
+	}
+}
+
+func TestCreateOrderIdempotentLease(t *testing.T) {
+	keys := memory.NewIdempotencyStorage()
+	keeper := idempotency.New(idempotency.DefaultConfiguration, keys)
+	products := newProductStorage(t, &product.Product{Name: "Сноуборд", Place: "Тверской бульвар, 25"})
+
+	h := New(products, newOrderStorage(t), new(mockLogger), WithIdempotency(keeper))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	body := `{"destination" : "Арбат, 10", "time" : "2020-06-15T13:30:00Z"}`
+	fingerprint := idempotency.Fingerprint("POST", "/api/v1/products/1/order", []byte(body))
+
+	tests := []struct {
+		name    string
+		started time.Duration
+		status  int
+	}{
+		{name: "in progress", started: time.Minute, status: http.StatusConflict},
+		{name: "abandoned", started: idempotency.DefaultConfiguration.Lease.Duration + time.Minute,
+			status: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "lease-" + strings.ReplaceAll(tt.name, " ", "-")
+
+			// запрос с этим ключом начат tt.started назад и еще не сохранил ответ
+			r := &idempotency.Record{Key: idempotency.Scoped("ip:192.0.2.1", key), Fingerprint: fingerprint,
+				CreatedAt: time.Now().Add(-tt.started)}
+			if _, err := keys.Start(context.Background(), r, time.Time{}, time.Time{}); err != nil {
+				t.Fatalf("can't start idempotency key %v", err)
+			}
+
+			req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
+
+			req.Header.Set("Idempotency-Key", key)
+			req.RemoteAddr = "192.0.2.1:1234"
+
+			rr := httptest.NewRecorder()
+			h.Routes().ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("createOrder handler returned wrong status code: got %v, want %v", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestIdempotencyLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	keys := memory.NewIdempotencyStorage()
+	keeper := idempotency.New(idempotency.DefaultConfiguration, keys)
+
+	a, err := keeper.Begin(ctx, "key", "fingerprint")
+	if err != nil {
+		t.Fatalf("Begin returned error %v", err)
+	}
+
+	// запрос a выполняется дольше Lease, и ключ занимает запрос b
+	b := &idempotency.Record{Key: "key", Fingerprint: "fingerprint", CreatedAt: a.CreatedAt.Add(time.Second)}
+	if _, err = keys.Start(ctx, b, time.Time{}, b.CreatedAt); err != nil {
+		t.Fatalf("can't start idempotency key %v", err)
+	}
+
+	for _, status := range []int{http.StatusCreated, http.StatusInternalServerError} {
+		err = keeper.Finish(ctx, a, &idempotency.Response{Status: status})
+		if !errors.Is(err, idempotency.ErrLeaseLost) {
+			t.Errorf("Finish with status %v returned error %v for lost lease, want %v",
+				status, err, idempotency.ErrLeaseLost)
+		}
+	}
+
+	if _, err = keeper.Begin(ctx, "key", "fingerprint"); !errors.Is(err, idempotency.ErrInProgress) {
+		t.Fatalf("Begin returned error %v for key taken by b, want %v", err, idempotency.ErrInProgress)
+	}
+
+	if err = keeper.Finish(ctx, b, &idempotency.Response{Status: http.StatusCreated}); err != nil {
+		t.Fatalf("Finish returned error %v", err)
+	}
+
+	r, err := keeper.Begin(ctx, "key", "fingerprint")
+	if err != nil {
+		t.Fatalf("Begin returned error %v", err)
+	}
+
+	if r.Response == nil || r.Response.Status != http.StatusCreated {
+		t.Errorf("Begin returned response %+v, want response of b", r.Response)
+	}
+}
+
+func TestProductCache(t *testing.T) {
+	products := memory.NewProductStorage()
+	if err := products.Create(&product.Product{Name: "Сноуборд", Place: "Тверской бульвар, 25"}); err != nil {
+		t.Fatalf("can't create product %v", err)
+	}
+
+	storage := &countingProductStorage{Storage: products}
+	cache := product.NewCache(storage, product.DefaultCacheConfiguration)
+	l := new(mockLogger)
+
+	h := New(cache, memory.NewOrderStorage(), l, WithProductCache(cache), WithUnitOfWork(new(mockUnitOfWork)))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
+
+	serve := func(handler handlerFunc, url string, body string, want int) {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		MWError(handler, l).ServeHTTP(rr, req)
+
+		if rr.Code != want {
+			t.Fatalf("handler returned wrong status code: got %v, want %v", rr.Code, want)
+		}
+	}
+
+	cost := `{"destination" : "Арбат, 10"}`
+	serve(h.costOfDelivery, "/api/v1/products/1/cost-of-delivery", cost, http.StatusOK)
+	serve(h.costOfDelivery, "/api/v1/products/1/cost-of-delivery", cost, http.StatusOK)
+
+	if storage.calls != 1 {
+		t.Errorf("product storage called %v times, want 1", storage.calls)
+	}
+
+	// в транзакции создания заказа товар читается из хранилища
+	order := `{"destination" : "Арбат, 10", "time" : "2020-06-15T13:30:00Z"}`
+	serve(h.createOrder, "/api/v1/products/1/order", order, http.StatusCreated)
+
+	if storage.calls != 2 {
+		t.Errorf("product storage called %v times, want 2", storage.calls)
+	}
+
+	if err := cache.Invalidate(context.Background(), 1); err != nil {
+		t.Fatalf("can't invalidate product %v", err)
+	}
+
+	serve(h.costOfDelivery, "/api/v1/products/1/cost-of-delivery", cost, http.StatusOK)
+
+	if storage.calls != 3 {
+		t.Errorf("product storage called %v times, want 3", storage.calls)
+	}
+
+	req, err := http.NewRequest("GET", "/api/v1/admin/cache/products", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	MWError(h.getProductCacheStats, l).ServeHTTP(rr, req)
+
+	expected := `{"hits":2,"misses":2,"evictions":0,"size":1}`
+	if rr.Body.String() != expected {
+		t.Errorf("getProductCacheStats handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestRateLimitSharedByReplicas(t *testing.T) {
+	srv, err := resp.StartServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't start resp server %v", err)
+	}
+	defer srv.Close()
+
+	store := resp.NewClient(srv.Addr(), resp.DefaultConfiguration)
+	defer store.Close()
+
+	replicas := []http.Handler{
+		New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger), WithRateLimit(ratelimit.New(ratelimit.DefaultConfiguration, store))).Routes(),
+		New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger), WithRateLimit(ratelimit.New(ratelimit.DefaultConfiguration, store))).Routes(),
+	}
+
+	serve := func(h http.Handler) int {
+		req, err := http.NewRequest("GET", "/api/v1/orders", nil)
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	for i := 0; i < 10; i++ {
+		if status := serve(replicas[i%2]); status != http.StatusOK {
+			t.Fatalf("request %d returned wrong status code: got %v, want %v", i+1, status, http.StatusOK)
+		}
+	}
+
+	for _, h := range replicas {
+		if status := serve(h); status != http.StatusTooManyRequests {
+			t.Errorf("request over the limit returned wrong status code: got %v, want %v",
+				status, http.StatusTooManyRequests)
+		}
+	}
+}
+
+func TestSharedProductCache(t *testing.T) {
+	srv, err := resp.StartServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't start resp server %v", err)
+	}
+	defer srv.Close()
+
+	store := resp.NewClient(srv.Addr(), resp.DefaultConfiguration)
+	defer store.Close()
+
+	products := memory.NewProductStorage()
+	if err = products.Create(&product.Product{Name: "Сноуборд", Place: "Тверской бульвар, 25"}); err != nil {
+		t.Fatalf("can't create product %v", err)
+	}
+
+	storage := &countingProductStorage{Storage: products}
+	ttl := product.DefaultCacheConfiguration.TTL.Duration
+
+	// кэши двух экземпляров сервиса с общим хранилищем kv
+	replicas := []*product.Cache{
+		product.NewCache(product.NewSharedCache(storage, store, ttl), product.DefaultCacheConfiguration),
+		product.NewCache(product.NewSharedCache(storage, store, ttl), product.DefaultCacheConfiguration),
+	}
+
+	ctx := context.Background()
+
+	for _, c := range replicas {
+		p, err := c.FindByID(ctx, 1)
+		if err != nil {
+			t.Fatalf("can't find product %v", err)
+		}
+
+		if p.Name != "Сноуборд" {
+			t.Errorf("cache returned unexpected product: got %v", p.Name)
+		}
+	}
+
+	if storage.calls != 1 {
+		t.Errorf("product storage called %v times, want 1", storage.calls)
+	}
+
+	for _, c := range replicas {
+		if err = c.Purge(ctx); err != nil {
+			t.Fatalf("can't purge cache %v", err)
+		}
+	}
+
+	if _, err = replicas[1].FindByID(ctx, 1); err != nil {
+		t.Fatalf("can't find product %v", err)
+	}
+
+	if storage.calls != 2 {
+		t.Errorf("product storage called %v times, want 2", storage.calls)
+	}
+
+	stats := replicas[1].Stats()
+	if stats.Shared == nil || stats.Shared.Hits != 1 || stats.Shared.Misses != 1 {
+		t.Errorf("cache returned unexpected shared stats: got %+v", stats.Shared)
+	}
+}
+
+// cachingStorage - кэш товаров, который можно сбросить
+type cachingStorage interface {
 	product.Storage
+	product.Invalidator
+}
+
+func TestProductCacheInvalidateDuringRead(t *testing.T) {
+	srv, err := resp.StartServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't start resp server %v", err)
+	}
+	defer srv.Close()
+
+	store := resp.NewClient(srv.Addr(), resp.DefaultConfiguration)
+	defer store.Close()
+
+	ttl := product.DefaultCacheConfiguration.TTL.Duration
+
+	tests := []struct {
+		name  string
+		cache func(s product.Storage) cachingStorage
+	}{
+		{
+			name: "cache",
+			cache: func(s product.Storage) cachingStorage {
+				return product.NewCache(s, product.DefaultCacheConfiguration)
+			},
+		},
+		{
+			name: "shared cache",
+			cache: func(s product.Storage) cachingStorage {
+				return product.NewSharedCache(s, store, ttl)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counting := &countingProductStorage{
+				Storage: newProductStorage(t, &product.Product{Name: "Сноуборд", Place: "Тверской бульвар, 25"}),
+			}
+			storage := &invalidatingProductStorage{Storage: counting}
+
+			c := tt.cache(storage)
+			storage.cache = c
+
+			ctx := context.Background()
+
+			for i := 0; i < 2; i++ {
+				if _, err := c.FindByID(ctx, 1); err != nil {
+					t.Fatalf("can't find product %v", err)
+				}
+			}
+
+			// товар, сброшенный во время первого чтения, не должен читаться из кэша
+			if counting.calls != 2 {
+				t.Errorf("product storage called %v times, want 2", counting.calls)
+			}
+		})
+	}
+}
+
+// keyAuthStorage находит только ключ key и считает обращения к хранилищу
+type keyAuthStorage struct {
+	key   string
+	p     *auth.Principal
+	calls int
+	auth.Storage
+}
+
+func (m *keyAuthStorage) FindByKey(ctx context.Context, key string) (*auth.Principal, error) {
+	m.calls++
+
+	if key != m.key {
+		return nil, domain.NotFound("can't find api key")
+	}
+
+	return m.p, nil
+}
+
+func TestRateLimitFailedAuth(t *testing.T) {
+	minute := ftime.Duration{Duration: time.Minute}
+	limits := ratelimit.Configuration{FailedAuth: ratelimit.Rule{Name: "failed-auth", Limit: 3, Window: minute}}
+
+	keys := &keyAuthStorage{key: "seller-key", p: &auth.Principal{ID: 7, Role: auth.Seller}}
+	h := New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger),
+		WithAuth(keys), WithRateLimit(ratelimit.New(limits, memory.NewKVStore())))
+
+	serve := func(ip string, header string) int {
+		req, err := http.NewRequest("GET", "/api/v1/orders", nil)
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		req.RemoteAddr = ip + ":1234"
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	// удачные попытки не учитываются
+	for i := 0; i < 5; i++ {
+		if code := serve("192.0.2.1", "Bearer seller-key"); code != http.StatusOK {
+			t.Fatalf("request with valid key returned wrong status code: got %v, want %v", code, http.StatusOK)
+		}
+	}
+
+	failed := []string{"Bearer wrong-key", "Basic seller-key", "Bearer other-key"}
+	for _, header := range failed {
+		if code := serve("192.0.2.1", header); code != http.StatusUnauthorized {
+			t.Fatalf("request with %q returned wrong status code: got %v, want %v",
+				header, code, http.StatusUnauthorized)
+		}
+	}
+
+	calls := keys.calls
+
+	tests := []struct {
+		name     string
+		ip       string
+		header   string
+		expected int
+	}{
+		{name: "wrong key over the limit", ip: "192.0.2.1", header: "Bearer next-key",
+			expected: http.StatusTooManyRequests},
+		{name: "valid key over the limit", ip: "192.0.2.1", header: "Bearer seller-key",
+			expected: http.StatusTooManyRequests},
+		{name: "without key", ip: "192.0.2.1", expected: http.StatusOK},
+		{name: "wrong key from other ip", ip: "192.0.2.2", header: "Bearer wrong-key",
+			expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := serve(tt.ip, tt.header); code != tt.expected {
+				t.Errorf("wrong status code: got %v, want %v", code, tt.expected)
+			}
+		})
+	}
+
+	// после исчерпания лимита ключи с этого IP-адреса не ищутся в хранилище
+	if keys.calls != calls+1 {
+		t.Errorf("auth storage called %v times after the limit, want 1", keys.calls-calls)
+	}
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	minute := ftime.Duration{Duration: time.Minute}
+	limits := ratelimit.Configuration{Rules: []ratelimit.Rule{
+		{Name: "seller-orders", Method: "GET", Path: "/api/v1/orders", Roles: []string{"seller"},
+			Limit: 3, Window: minute, Burst: 2},
+		{Name: "default", Limit: 2, Window: minute},
+	}}
+
+	seller := &auth.Principal{ID: 7, Role: auth.Seller}
+	h := New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger),
+		WithAuth(mockAuthStorage{p: seller}), WithRateLimit(ratelimit.New(limits, memory.NewKVStore())))
+
+	serve := func(url string, key string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
+
+		req.RemoteAddr = "192.0.2.1:1234"
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		key     string
+		allowed int
+	}{
+		// в первом окне прошлое окно не использовано, поэтому доступен Burst
+		{name: "seller orders with burst", url: "/api/v1/orders", key: "seller-key", allowed: 5},
+		// запросы без ключа с того же IP считаются отдельно от запросов продавца
+		{name: "anonymous", url: "/api/v1/orders", allowed: 2},
+		{name: "seller other route", url: "/api/v1/orders/1", key: "seller-key", allowed: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.allowed; i++ {
+				if rr := serve(tt.url, tt.key); rr.Code == http.StatusTooManyRequests {
+					t.Fatalf("request %d was rate limited, want %d allowed", i+1, tt.allowed)
+				}
+			}
+
+			rr := serve(tt.url, tt.key)
+			if rr.Code != http.StatusTooManyRequests {
+				t.Fatalf("request over the limit returned wrong status code: got %v, want %v",
+					rr.Code, http.StatusTooManyRequests)
+			}
+
+			if rr.Header().Get("Retry-After") == "" {
+				t.Errorf("request over the limit returned no Retry-After header")
+			}
+
+			if got := rr.Header().Get("X-RateLimit-Limit"); got != strconv.Itoa(tt.allowed) {
+				t.Errorf("wrong X-RateLimit-Limit: got %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimitByClientIP(t *testing.T) {
+	limits := ratelimit.Configuration{Rules: []ratelimit.Rule{
+		{Name: "default", Limit: 1, Window: ftime.Duration{Duration: time.Minute}},
+	}}
+
+	resolver, err := clientip.New(clientip.Configuration{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.7"}})
+	if err != nil {
+		t.Fatalf("can't create client ip resolver %v", err)
+	}
+
+	type request struct {
+		remote string
+		header string
+		value  string
+	}
+
+	tests := []struct {
+		name    string
+		first   request
+		second  request
+		limited bool
+	}{
+		{
+			name:    "spoofed header from untrusted client",
+			first:   request{remote: "203.0.113.5:1234", header: "X-Forwarded-For", value: "198.51.100.1"},
+			second:  request{remote: "203.0.113.5:1234", header: "X-Forwarded-For", value: "198.51.100.2"},
+			limited: true,
+		},
+		{
+			name:   "different clients behind proxy",
+			first:  request{remote: "10.0.0.1:1234", header: "X-Forwarded-For", value: "198.51.100.1"},
+			second: request{remote: "10.0.0.1:1234", header: "X-Forwarded-For", value: "198.51.100.2"},
+		},
+		{
+			name:    "spoofed header behind proxy",
+			first:   request{remote: "10.0.0.1:1234", header: "X-Forwarded-For", value: "1.1.1.1, 198.51.100.1"},
+			second:  request{remote: "10.0.0.2:1234", header: "X-Forwarded-For", value: "2.2.2.2, 198.51.100.1"},
+			limited: true,
+		},
+		{
+			name:   "forwarded header behind chain of proxies",
+			first:  request{remote: "192.0.2.7:1234", header: "Forwarded", value: `for="[2001:db8::1]:80", for=10.1.1.1`},
+			second: request{remote: "192.0.2.7:1234", header: "Forwarded", value: `for="[2001:db8::2]:80", for=10.1.1.1`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger),
+				WithRateLimit(ratelimit.New(limits, memory.NewKVStore())), WithTrustedProxies(resolver)).Routes()
+
+			serve := func(in request) int {
+				req, err := http.NewRequest("GET", "/api/v1/orders", nil)
+				if err != nil {
+					t.Fatalf("can't create request %v", err)
+				}
+
+				req.RemoteAddr = in.remote
+				req.Header.Set(in.header, in.value)
+
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, req)
+
+				return rr.Code
+			}
+
+			if status := serve(tt.first); status != http.StatusOK {
+				t.Fatalf("first request returned wrong status code: got %v, want %v", status, http.StatusOK)
+			}
+
+			expected := http.StatusOK
+			if tt.limited {
+				expected = http.StatusTooManyRequests
+			}
+
+			if status := serve(tt.second); status != expected {
+				t.Errorf("second request returned wrong status code: got %v, want %v", status, expected)
+			}
+		})
+	}
 }
 
-func (m mockProductStorage) FindByID(id int64) (*product.Product, error) {
-	return m.p, nil
-}
+func TestProblemResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		requestID string
+		status    int
+		code      ehttp.Code
+	}{
+		{name: "unknown route", method: "GET", url: "/api/v2/orders", status: http.StatusNotFound,
+			code: ehttp.CodeRouteNotFound},
+		{name: "unknown method", method: "PUT", url: "/api/v1/orders", status: http.StatusMethodNotAllowed,
+			code: ehttp.CodeMethodNotAllowed},
+		{name: "client request id", method: "GET", url: "/api/v1/problems/unknown", requestID: "trace-42",
+			status: http.StatusNotFound, code: ehttp.CodeNotFound},
+		{name: "disabled feature", method: "GET", url: "/api/v1/delivery-slots", status: http.StatusNotFound,
+			code: ehttp.CodeFeatureDisabled},
+	}
+
+	h := New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger)).Routes()
 
-type mockOrderStorage struct {
-	o  *order.Order
-	oo []*order.Order
-	order.Storage
-}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
 
-func (m mockOrderStorage) Create(o *order.Order) error {
-	o.ID = m.o.ID
-	return nil
-}
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-Id", tt.requestID)
+			}
 
-func (m mockOrderStorage) GetAll() ([]*order.Order, error) {
-	return m.oo, nil
-}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
 
-func (m mockOrderStorage) FindByID(id int64) (*order.Order, error) {
-	return m.o, nil
-}
+			if rr.Code != tt.status {
+				t.Errorf("handler returned wrong status code: got %v, want %v", rr.Code, tt.status)
+			}
 
-type mockLogger struct {
-	logger.Logger
-}
+			p := problem(t, rr)
+			if p.Code != tt.code || p.Status != tt.status || p.Type != ehttp.TypeBase+string(tt.code) {
+				t.Errorf("handler returned unexpected error: got %+v, want code %v", p, tt.code)
+			}
 
-func (m mockLogger) Debugf(format string, args ...interface{}) {}
-func (m mockLogger) Infof(format string, args ...interface{})  {}
-func (m mockLogger) Warnf(format string, args ...interface{})  {}
-func (m mockLogger) Errorf(format string, args ...interface{}) {}
-func (m mockLogger) Fatalf(format string, args ...interface{}) {}
-func (m mockLogger) Panicf(format string, args ...interface{}) {}
+			if p.RequestID == "" || p.RequestID != rr.Header().Get("X-Request-Id") {
+				t.Errorf("request id %q does not match X-Request-Id header %q",
+					p.RequestID, rr.Header().Get("X-Request-Id"))
+			}
 
-func respContains(in string, want string) bool {
-	if in == "" {
-		return want == ""
+			if tt.requestID != "" && p.RequestID != tt.requestID {
+				t.Errorf("handler returned unexpected request id: got %v, want %v", p.RequestID, tt.requestID)
+			}
+		})
 	}
-
-	return strings.Contains(in, want)
 }
 
-func TestCostOfDeliveryCorrect(t *testing.T) {
-	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
-	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
-	if err != nil {
-		t.Fatalf("can't create request %v", err)
-	}
+func TestProblemCatalogue(t *testing.T) {
+	// без правил ограничения запросов, кодов больше, чем запросов по умолчанию
+	h := New(memory.NewProductStorage(), memory.NewOrderStorage(), new(mockLogger),
+		WithRateLimit(ratelimit.New(ratelimit.Configuration{}, memory.NewKVStore()))).Routes()
 
-	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
+	for _, e := range ehttp.Catalogue {
+		req, err := http.NewRequest("GET", e.Type, nil)
+		if err != nil {
+			t.Fatalf("can't create request %v", err)
+		}
 
-	place := "Тверской бульвар, 25"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
 
-	p := &product.Product{
-		ID:    1,
-		Place: place,
+		var got ehttp.CatalogueEntry
+		if err = json.Unmarshal(rr.Body.Bytes(), &got); err != nil || got != e {
+			t.Errorf("getProblem handler returned unexpected body for %v: got %v", e.Code, rr.Body.String())
+		}
 	}
+}
 
-	mockProductStorage.p = p
+func TestRequestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		expected validation.Errors
+	}{
+		{
+			name: "empty destination",
+			url:  "/api/v1/products/1/cost-of-delivery",
+			body: `{"destination" : "  "}`,
+			expected: validation.Errors{
+				{Field: "destination", Rule: validation.RuleRequired, Message: "destination is required"},
+			},
+		},
+		{
+			name: "long fields",
+			url:  "/api/v1/products/1/cost-of-delivery",
+			body: fmt.Sprintf(`{"destination" : "%s", "promo_code" : "%s"}`,
+				strings.Repeat("д", 201), strings.Repeat("X", 51)),
+			expected: validation.Errors{
+				{Field: "destination", Rule: validation.RuleMaxLength,
+					Message: "destination must be at most 200 characters"},
+				{Field: "promo_code", Rule: validation.RuleMaxLength, Message: "promo_code must be at most 50 characters"},
+			},
+		},
+		{
+			name: "order without destination and time",
+			url:  "/api/v1/products/1/order",
+			body: fmt.Sprintf(`{"buyer" : "%s"}`, strings.Repeat("b", 201)),
+			expected: validation.Errors{
+				{Field: "destination", Rule: validation.RuleRequired, Message: "destination is required"},
+				{Field: "buyer", Rule: validation.RuleMaxLength, Message: "buyer must be at most 200 characters"},
+				{Field: "time", Rule: validation.RuleRequired, Message: "time is required"},
+			},
+		},
+	}
 
-	h := New(mockProductStorage, mockOrderStorage, l)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("can't create request %v", err)
+			}
 
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+			h := New(newProductStorage(t, &product.Product{Place: "Тверской бульвар, 25"}),
+				newOrderStorage(t), new(mockLogger))
 
-	handler.ServeHTTP(rr, req)
+			rr := httptest.NewRecorder()
+			h.Routes().ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
-			status, http.StatusOK)
-	}
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("handler returned wrong status code: got %v, want %v", rr.Code, http.StatusUnprocessableEntity)
+			}
 
-	expected := `{"destination":"Большая Садовая, 302-бис, пятый этаж, кв. № 50","from":"Тверской бульвар, 25"`
-	if !respContains(rr.Body.String(), expected) {
-		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+			p := problem(t, rr)
+			if p.Code != ehttp.CodeValidationFailed {
+				t.Errorf("handler returned wrong error code: got %v, want %v", p.Code, ehttp.CodeValidationFailed)
+			}
+
+			if fmt.Sprint(p.Violations) != fmt.Sprint(tt.expected) {
+				t.Errorf("handler returned unexpected violations: got %+v, want %+v", p.Violations, tt.expected)
+			}
+		})
 	}
 }
 
-func TestCostOfDeliveryNotFound(t *testing.T) {
-	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
-	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+func TestCreateOrderPastTime(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T10:30:00.5+03:00"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
 	if err != nil {
 		t.Fatalf("can't create request %v", err)
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
 	p := &product.Product{
-		ID: 0, // zero value => can't find product in storage
+		ID:    1,
+		Place: "Тверской бульвар, 25",
 	}
 
-	mockProductStorage.p = p
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+	handler := http.HandlerFunc(MWError(h.createOrder, l))
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusNotFound {
-		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
-			status, http.StatusNotFound)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusUnprocessableEntity)
 	}
 
-	expected := `{"error":"can't find product with id= 1"}`
-	if rr.Body.String() != expected {
-		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := "delivery time must be in the future"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("createOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
 	}
 }
 
-func TestCostOfDeliveryIncorrectID(t *testing.T) {
-	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
-	req, err := http.NewRequest("POST", "/api/v1/products/-1/cost-of-delivery", bytes.NewBuffer(json))
+func TestCreateOrderIncorrectID(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/-1/order", bytes.NewBuffer(json))
 	if err != nil {
 		t.Fatalf("can't create request %v", err)
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
 	place := "Тверской бульвар, 25"
 
@@ -154,38 +2787,34 @@ func TestCostOfDeliveryIncorrectID(t *testing.T) {
 		Place: place,
 	}
 
-	mockProductStorage.p = p
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+	handler := http.HandlerFunc(MWError(h.createOrder, l))
 
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusBadRequest {
-		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
 			status, http.StatusBadRequest)
 	}
 
-	expected := fmt.Sprintf("{\"error\":\"incorrect id= %v\"}", -1)
-	if rr.Body.String() != expected {
-		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := fmt.Sprintf("incorrect id= %v", -1)
+	if p := problem(t, rr); p.Code != ehttp.CodeInvalidID || p.Detail != expected {
+		t.Errorf("createOrder handler returned unexpected error: got %v %v, want %v %v",
+			p.Code, p.Detail, ehttp.CodeInvalidID, expected)
 	}
 }
 
-func TestCostOfDeliveryIncorrectJSON(t *testing.T) {
+func TestCreateOrderIncorrectJSON(t *testing.T) {
 	// body contains incorrect json(missing a open bracket)
-	json := []byte(`"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50"}`)
-	req, err := http.NewRequest("POST", "/api/v1/products/1/cost-of-delivery", bytes.NewBuffer(json))
+	json := []byte(`"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
 	if err != nil {
 		t.Fatalf("can't create request %v", err)
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
 	place := "Тверской бульвар, 25"
 
@@ -194,28 +2823,24 @@ func TestCostOfDeliveryIncorrectJSON(t *testing.T) {
 		Place: place,
 	}
 
-	mockProductStorage.p = p
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(MWError(h.costOfDelivery, l))
+	handler := http.HandlerFunc(MWError(h.createOrder, l))
 
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusBadRequest {
-		t.Errorf("costOfDelivery handler returned wrong status code: got %v, want %v",
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
 			status, http.StatusBadRequest)
 	}
 
-	expected := ""
-	if rr.Body.String() != expected {
-		t.Errorf("costOfDelivery handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	if p := problem(t, rr); p.Code != ehttp.CodeMalformedJSON {
+		t.Errorf("createOrder handler returned unexpected error code: got %v, want %v", p.Code, ehttp.CodeMalformedJSON)
 	}
 }
 
-func TestCreateOrderCorrect(t *testing.T) {
+func TestCreateOrderNotFound(t *testing.T) {
 	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
 	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
 	if err != nil {
@@ -223,159 +2848,185 @@ func TestCreateOrderCorrect(t *testing.T) {
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
-	place := "Тверской бульвар, 25"
+	h := New(newProductStorage(t), newOrderStorage(t), l)
 
-	p := &product.Product{
-		ID:    1,
-		Name:  "Название",
-		Place: place,
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.createOrder, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusNotFound)
 	}
 
-	o := &order.Order{
-		ID: 5,
+	expected := "can't find product with id= 1"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("createOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
+	}
+}
+
+func newTestCalendars(t *testing.T) *ftime.Calendars {
+	cal, err := ftime.NewCalendar(ftime.CalendarConfiguration{
+		TimeZone: "UTC",
+		Opens:    ftime.NewClock(9, 0),
+		Closes:   ftime.NewClock(21, 0),
+		Holidays: "../../../holidays/ru.json",
+	})
+	if err != nil {
+		t.Fatalf("can't create calendar %v", err)
+	}
+
+	return ftime.NewCalendars(cal, nil)
+}
+
+func TestCreateOrderWithoutSlot(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
 	}
 
-	mockProductStorage.p = p
-	mockOrderStorage.o = o
+	l := new(mockLogger)
+	mockSlotStorage := new(mockSlotStorage)
+
+	p := &product.Product{
+		ID:    1,
+		Place: "Тверской бульвар, 25",
+	}
 
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l,
+		WithSlots(slot.New(slot.DefaultConfiguration), mockSlotStorage), WithCalendars(newTestCalendars(t)))
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.createOrder, l))
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusCreated {
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
-			status, http.StatusCreated)
+			status, http.StatusUnprocessableEntity)
 	}
 
-	expected := ""
-	if !respContains(rr.Body.String(), expected) {
-		t.Errorf("createOrder handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := "delivery slot is required"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("createOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
 	}
 }
 
-func TestCreateOrderIncorrectID(t *testing.T) {
-	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
-	req, err := http.NewRequest("POST", "/api/v1/products/-1/order", bytes.NewBuffer(json))
+func TestCreateOrderSlotFull(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "slot" : "2020-06-15T13:00:00Z"}`)
+	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
 	if err != nil {
 		t.Fatalf("can't create request %v", err)
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
-
-	place := "Тверской бульвар, 25"
+	mockSlotStorage := new(mockSlotStorage)
 
 	p := &product.Product{
 		ID:    1,
-		Place: place,
+		Place: "Тверской бульвар, 25",
 	}
 
-	mockProductStorage.p = p
+	mockSlotStorage.full = true
 
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l,
+		WithSlots(slot.New(slot.DefaultConfiguration), mockSlotStorage), WithCalendars(newTestCalendars(t)))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 8, 0, 0, 0, time.UTC)
+	}
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.createOrder, l))
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusBadRequest {
+	if status := rr.Code; status != http.StatusConflict {
 		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
-			status, http.StatusBadRequest)
+			status, http.StatusConflict)
 	}
 
-	expected := fmt.Sprintf("{\"error\":\"incorrect id= %v\"}", -1)
-	if rr.Body.String() != expected {
-		t.Errorf("createOrder handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := "delivery slot is full"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("createOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
 	}
 }
 
-func TestCreateOrderIncorrectJSON(t *testing.T) {
-	// body contains incorrect json(missing a open bracket)
-	json := []byte(`"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
+func TestCreateOrderOnHoliday(t *testing.T) {
+	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "slot" : "2026-06-12T11:00:00Z"}`)
 	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
 	if err != nil {
 		t.Fatalf("can't create request %v", err)
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
-
-	place := "Тверской бульвар, 25"
+	mockSlotStorage := new(mockSlotStorage)
 
 	p := &product.Product{
 		ID:    1,
-		Place: place,
+		Place: "Тверской бульвар, 25",
 	}
 
-	mockProductStorage.p = p
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l,
+		WithSlots(slot.New(slot.DefaultConfiguration), mockSlotStorage), WithCalendars(newTestCalendars(t)))
+	h.now = func() time.Time {
+		return time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	}
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.createOrder, l))
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusBadRequest {
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
-			status, http.StatusBadRequest)
+			status, http.StatusUnprocessableEntity)
 	}
 
-	expected := ""
-	if rr.Body.String() != expected {
-		t.Errorf("createOrder handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := `delivery is not available on 2026-06-12: public holiday "День России"`
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("createOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
 	}
 }
 
-func TestCreateOrderNotFound(t *testing.T) {
-	json := []byte(`{"destination" : "Большая Садовая, 302-бис, пятый этаж, кв. № 50", "time" : "2020-06-15T13:30:00Z"}`)
-	req, err := http.NewRequest("POST", "/api/v1/products/1/order", bytes.NewBuffer(json))
+func TestGetDeliverySlots(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/delivery-slots?destination=Тверская,%201", nil)
 	if err != nil {
 		t.Fatalf("can't create request %v", err)
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
+	mockSlotStorage := new(mockSlotStorage)
 
-	p := &product.Product{
-		ID: 0, // zero value => can't find product in storage
-	}
+	config := slot.DefaultConfiguration
+	config.DaysAhead = 1
 
-	o := &order.Order{
-		ID: 5,
+	mockSlotStorage.rr = []*slot.Reservation{
+		{Start: time.Date(2020, 6, 15, 19, 0, 0, 0, time.UTC), Reserved: 4},
 	}
 
-	mockProductStorage.p = p
-	mockOrderStorage.o = o
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t), newOrderStorage(t), l,
+		WithSlots(slot.New(config), mockSlotStorage), WithCalendars(newTestCalendars(t)))
+	h.now = func() time.Time {
+		return time.Date(2020, 6, 15, 16, 30, 0, 0, time.UTC)
+	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(MWError(h.createOrder, l))
+	handler := http.HandlerFunc(MWError(h.getDeliverySlots, l))
 
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusNotFound {
-		t.Errorf("createOrder handler returned wrong status code: got %v, want %v",
-			status, http.StatusNotFound)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getDeliverySlots handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
 	}
 
-	expected := `{"error":"can't find product with id= 1"}`
+	expected := `"slots":[{"from":"2020-06-15T17:00:00Z","to":"2020-06-15T19:00:00Z","capacity":10,"available":10},` +
+		`{"from":"2020-06-15T19:00:00Z","to":"2020-06-15T21:00:00Z","capacity":10,"available":6}]}`
 	if !respContains(rr.Body.String(), expected) {
-		t.Errorf("createOrder handler returned unexpected body: got %v, want %v",
+		t.Errorf("getDeliverySlots handler returned unexpected body: got %v, want %v",
 			rr.Body.String(), expected)
 	}
 }
@@ -387,17 +3038,13 @@ func TestGetOrdersCorrect(t *testing.T) {
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
-
-	orders := []*order.Order{
-		{ID: 1, ProductID: 1, Name: "Первое название"},
-		{ID: 2, ProductID: 1, Name: "Второе название"},
-	}
 
-	mockOrderStorage.oo = orders
+	orders := newOrderStorage(t,
+		&order.Order{ProductID: 1, Name: "Первое название"},
+		&order.Order{ProductID: 1, Name: "Второе название"},
+	)
 
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t), orders, l)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.getOrders, l))
@@ -423,8 +3070,6 @@ func TestGetOrderCorrect(t *testing.T) {
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
 	place := "Большой Патриарший пер., 7, строение 1"
 
@@ -443,7 +3088,6 @@ func TestGetOrderCorrect(t *testing.T) {
 	time := ftime.New(tt)
 
 	o := &order.Order{
-		ID:          2,
 		ProductID:   1,
 		Name:        "Сноуборд",
 		From:        place,
@@ -451,10 +3095,7 @@ func TestGetOrderCorrect(t *testing.T) {
 		Time:        time,
 	}
 
-	mockProductStorage.p = p
-	mockOrderStorage.o = o
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t, o), l)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.getOrder, l))
@@ -466,9 +3107,113 @@ func TestGetOrderCorrect(t *testing.T) {
 			status, http.StatusOK)
 	}
 
-	expected := `{"id":2,"product":{"id":1,"name":"Сноуборд","width":40.5,"length":143,"height":20,"weight":3.3,` +
+	if tag := rr.Header().Get("ETag"); tag != `"1"` {
+		t.Errorf("getOrder handler returned unexpected ETag: got %v, want %v", tag, `"1"`)
+	}
+
+	expected := `{"id":1,"product":{"id":1,"name":"Сноуборд","width":40.5,"length":143,"height":20,"weight":3.3,` +
 		`"place":"Большой Патриарший пер., 7, строение 1"},"from":"Большой Патриарший пер., 7, строение 1",` +
-		`"destination":"Большая Садовая, 302-бис, пятый этаж, кв. № 50","time":"2020-06-17T15:30:00Z"}`
+		`"destination":"Большая Садовая, 302-бис, пятый этаж, кв. № 50","time":"2020-06-17T15:30:00Z",` +
+		`"status":"confirmed"}`
+	if !respContains(rr.Body.String(), expected) {
+		t.Errorf("getOrder handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestGetOrderETA(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/orders/1", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+	mockCourierStorage := new(mockCourierStorage)
+
+	now := time.Date(2020, 6, 15, 16, 0, 0, 0, time.UTC)
+
+	p := &product.Product{ID: 1, Name: "Сноуборд", Place: "Большой Патриарший пер., 7"}
+	o := &order.Order{
+		ProductID:   1,
+		Name:        "Сноуборд",
+		From:        "Большой Патриарший пер., 7",
+		Destination: "Тверская, 1",
+		Time:        ftime.New(time.Date(2020, 6, 15, 19, 0, 0, 0, time.UTC)),
+		CourierID:   7,
+		Status:      order.Assigned,
+	}
+	mockCourierStorage.c = &courier.Courier{
+		ID:        7,
+		Vehicle:   courier.Bike,
+		Location:  &geo.Point{Lat: 55.75, Lon: 37.6},
+		LocatedAt: &now,
+	}
+
+	h := New(newProductStorage(t, p), newOrderStorage(t, o), l,
+		WithRouting(routing.New(routing.DefaultConfiguration), mockCourierStorage))
+	h.now = func() time.Time {
+		return now
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.getOrder, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `"eta":"2020-06-15T19:00:00Z","eta_confidence":0.95}`
+	if !respContains(rr.Body.String(), expected) {
+		t.Errorf("getOrder handler returned unexpected body: got %v, want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestGetOrderCityTimeZone(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/v1/orders/1", nil)
+	if err != nil {
+		t.Fatalf("can't create request %v", err)
+	}
+
+	l := new(mockLogger)
+
+	p := &product.Product{
+		ID:    1,
+		Name:  "Сноуборд",
+		Place: "Большой Патриарший пер., 7, строение 1",
+	}
+
+	o := &order.Order{
+		ProductID: 1,
+		Zone:      "center",
+		Time:      ftime.New(time.Date(2020, 6, 17, 12, 30, 0, 0, time.UTC)),
+	}
+
+	moscow, err := ftime.NewCalendar(ftime.CalendarConfiguration{TimeZone: "Europe/Moscow"})
+	if err != nil {
+		t.Fatalf("can't create calendar %v", err)
+	}
+
+	zones := geo.Zones{{Name: "center", City: "moscow", Bounds: geo.MoscowBounds}}
+	calendars := ftime.NewCalendars(ftime.AlwaysOpen(), map[string]*ftime.Calendar{"moscow": moscow})
+
+	h := New(newProductStorage(t, p), newOrderStorage(t, o), l,
+		WithGeo(geo.NewHashGeocoder(geo.MoscowBounds), zones), WithCalendars(calendars))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(MWError(h.getOrder, l))
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("getOrder handler returned wrong status code: got %v, want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `"time":"2020-06-17T15:30:00+03:00"`
 	if !respContains(rr.Body.String(), expected) {
 		t.Errorf("getOrder handler returned unexpected body: got %v, want %v",
 			rr.Body.String(), expected)
@@ -482,8 +3227,6 @@ func TestGetOrderNotFoundOrder(t *testing.T) {
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
 	place := "Большой Патриарший пер., 7, строение 1"
 
@@ -497,14 +3240,7 @@ func TestGetOrderNotFoundOrder(t *testing.T) {
 		Place:  place,
 	}
 
-	o := &order.Order{
-		ID: 0, // zero value => can't find order in storage
-	}
-
-	mockProductStorage.p = p
-	mockOrderStorage.o = o
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t, p), newOrderStorage(t), l)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.getOrder, l))
@@ -516,10 +3252,9 @@ func TestGetOrderNotFoundOrder(t *testing.T) {
 			status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"can't find order with id= 1"}`
-	if !respContains(rr.Body.String(), expected) {
-		t.Errorf("getOrder handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := "can't find order with id= 1"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("getOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
 	}
 }
 
@@ -530,21 +3265,14 @@ func TestGetOrderNotFoundProduct(t *testing.T) {
 	}
 
 	l := new(mockLogger)
-	mockProductStorage := new(mockProductStorage)
-	mockOrderStorage := new(mockOrderStorage)
 
 	place := "Большой Патриарший пер., 7, строение 1"
 
-	p := &product.Product{
-		ID: 0, // zero value => can't find product in storage
-	}
-
 	str := "2020-06-17T15:30:00Z"
 	tt, _ := time.Parse(ftime.Layout, str)
 	time := ftime.New(tt)
 
 	o := &order.Order{
-		ID:          2,
 		ProductID:   1,
 		Name:        "Сноуборд",
 		From:        place,
@@ -552,10 +3280,7 @@ func TestGetOrderNotFoundProduct(t *testing.T) {
 		Time:        time,
 	}
 
-	mockProductStorage.p = p
-	mockOrderStorage.o = o
-
-	h := New(mockProductStorage, mockOrderStorage, l)
+	h := New(newProductStorage(t), newOrderStorage(t, o), l)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(MWError(h.getOrder, l))
@@ -567,9 +3292,8 @@ func TestGetOrderNotFoundProduct(t *testing.T) {
 			status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"can't find product with id= 1"}`
-	if !respContains(rr.Body.String(), expected) {
-		t.Errorf("getOrder handler returned unexpected body: got %v, want %v",
-			rr.Body.String(), expected)
+	expected := "can't find product with id= 1"
+	if p := problem(t, rr); p.Detail != expected {
+		t.Errorf("getOrder handler returned unexpected error: got %v, want %v", p.Detail, expected)
 	}
 }
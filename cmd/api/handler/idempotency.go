@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/idempotency"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// replayedHeader отмечает ответ, повторенный из сохраненного
+	replayedHeader = "Idempotent-Replayed"
+	// maxIdempotentBody - наибольший размер тела запроса с ключом идемпотентности
+	maxIdempotentBody = 1 << 20
+	// finishTimeout ограничивает сохранение ответа, которое выполняется уже без контекста запроса
+	finishTimeout = 5 * time.Second
+)
+
+// savedHeaders - заголовки ответа, которые сохраняются вместе с ним для повторов
+var savedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotent выполняет запрос с заголовком Idempotency-Key один раз: повтор с тем же ключом
+// и телом получает сохраненный ответ, а запрос с тем же ключом и другим телом отклоняется.
+// Ключи разных клиентов не пересекаются. Запросы без заголовка выполняются как обычно
+func (h *Handler) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || h.idempotency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > idempotency.MaxKey {
+			msg := fmt.Sprintf("%s header must be at most %d characters", idempotencyKeyHeader, idempotency.MaxKey)
+			respondError(w, r, ehttp.BadRequestErr(msg, msg), h.logger)
+
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			msg := "can't read request body"
+			respondError(w, r, ehttp.BadRequestErr(msg, err.Error()), h.logger)
+
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		key = idempotency.Scoped(idempotencyScope(r.Context()), key)
+
+		lease, err := h.idempotency.Begin(r.Context(), key, idempotency.Fingerprint(r.Method, r.URL.Path, body))
+		if err != nil {
+			respondError(w, r, err, h.logger)
+			return
+		}
+
+		if lease.Response != nil {
+			replay(w, lease.Response)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// ответ уже отправлен, и отмена запроса не должна оставить ключ занятым до истечения Lease
+		ctx, cancel := context.WithTimeout(context.Background(), finishTimeout)
+		defer cancel()
+
+		if err = h.idempotency.Finish(ctx, lease, rec.response()); err != nil {
+			h.logger.Errorf("can't save response for idempotency key %q: %v", key, err)
+		}
+	})
+}
+
+// idempotencyScope возвращает клиента, которому принадлежит ключ идемпотентности:
+// API-ключ запроса, а для анонимного запроса - IP клиента
+func idempotencyScope(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return fmt.Sprintf("key:%d", p.ID)
+	}
+
+	return "ip:" + clientip.FromContext(ctx)
+}
+
+func replay(w http.ResponseWriter, resp *idempotency.Response) {
+	for name, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(resp.Status)
+
+	// no need to handle error here
+	_, _ = w.Write(resp.Body)
+}
+
+// responseRecorder передает ответ клиенту и запоминает его для повторов
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.header == nil {
+		rec.status = status
+		rec.header = make(http.Header)
+
+		for _, name := range savedHeaders {
+			if v := rec.Header().Values(name); len(v) > 0 {
+				rec.header[name] = v
+			}
+		}
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	rec.body.Write(b)
+
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) response() *idempotency.Response {
+	if rec.header == nil {
+		rec.header = make(http.Header)
+	}
+
+	return &idempotency.Response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+}
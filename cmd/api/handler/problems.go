@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/ehttp"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// requestID присваивает запросу ID из заголовка X-Request-Id или новый и возвращает его в ответе,
+// чтобы по ID из ответа с ошибкой можно было найти запись в журнале
+func requestID(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func routeNotFound(w http.ResponseWriter, r *http.Request) error {
+	msg := fmt.Sprintf("route %s is not found", r.URL.Path)
+	return ehttp.HTTPError{Msg: msg, StatusCode: http.StatusNotFound, Code: ehttp.CodeRouteNotFound}
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) error {
+	msg := fmt.Sprintf("method %s is not allowed for route %s", r.Method, r.URL.Path)
+	return ehttp.HTTPError{Msg: msg, StatusCode: http.StatusMethodNotAllowed, Code: ehttp.CodeMethodNotAllowed}
+}
+
+// getProblems возвращает каталог кодов ошибок
+func (h *Handler) getProblems(w http.ResponseWriter, r *http.Request) error {
+	err := respondJSON(w, ehttp.Catalogue)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with error codes: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// getProblem возвращает описание кода ошибки, на которое ссылается поле type ответа с ошибкой
+func (h *Handler) getProblem(w http.ResponseWriter, r *http.Request) error {
+	code := chi.URLParam(r, "code")
+
+	e, ok := ehttp.Lookup(ehttp.Code(code))
+	if !ok {
+		msg := fmt.Sprintf("can't find error code %q", code)
+		return ehttp.NotFoundErr(msg, msg)
+	}
+
+	err := respondJSON(w, e)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with error code: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
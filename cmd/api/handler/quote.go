@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/pricing"
+	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/promo"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// destination - адрес доставки с координатами и зоной
+type destination struct {
+	address string
+	point   geo.Point
+	zone    string
+}
+
+func (h *Handler) locate(address string) (*destination, error) {
+	p, err := h.geocoder.Geocode(address)
+	if err != nil {
+		msg := "can't find destination address"
+		detail := fmt.Sprintf("%v %q: %v", msg, address, err)
+
+		return nil, ehttp.UnprocessableEntityErr(msg, detail)
+	}
+
+	return &destination{
+		address: address,
+		point:   p,
+		zone:    h.zones.Locate(p),
+	}, nil
+}
+
+// calendar возвращает рабочий календарь города, к которому относится зона
+func (h *Handler) calendar(zone string) *ftime.Calendar {
+	return h.calendars.For(h.zones.City(zone))
+}
+
+// quote - рассчитанная стоимость доставки товара до адреса
+type quote struct {
+	from      geo.Point
+	to        geo.Point
+	zone      string
+	breakdown *pricing.Breakdown
+	promo     *promo.Code
+}
+
+// quote рассчитывает стоимость доставки ко времени at: к базовой цене
+// применяется коэффициент спроса, затем скидка по промокоду
+func (h *Handler) quote(ctx context.Context, p *product.Product, dest *destination, at time.Time,
+	code string, buyer string) (*quote, error) {
+	from, err := h.geocoder.Geocode(p.Place)
+	if err != nil {
+		detail := fmt.Sprintf("can't geocode place of product with id= %v: %v", p.ID, err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	q := &quote{
+		from:      from,
+		to:        dest.point,
+		zone:      dest.zone,
+		breakdown: h.pricing.Calculate(p.Weight),
+	}
+
+	m, err := h.surgeMultiplier(ctx, q.zone, at)
+	if err != nil {
+		return nil, err
+	}
+
+	q.breakdown.ApplySurge(m)
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return q, nil
+	}
+
+	c, err := h.findPromo(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &promo.Request{
+		ProductID:  p.ID,
+		Zone:       q.zone,
+		OrderValue: p.Price,
+		Buyer:      buyer,
+		At:         h.now(),
+	}
+
+	if err := h.checkPromo(ctx, c, req); err != nil {
+		return nil, err
+	}
+
+	q.promo = c
+	q.breakdown.ApplyDiscount(c.Discount(q.breakdown.Total))
+
+	return q, nil
+}
+
+func (h *Handler) findPromo(ctx context.Context, code string) (*promo.Code, error) {
+	if h.promoStorage == nil {
+		msg := "promo codes are not supported"
+		return nil, ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	c, err := h.promoStorage.FindByCode(ctx, code)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ehttp.UnprocessableEntityErr(err.Error(), err.Error())
+	}
+
+	if err != nil {
+		detail := fmt.Sprintf("can't find promo code %q: %v", code, err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	return c, nil
+}
+
+func (h *Handler) checkPromo(ctx context.Context, c *promo.Code, req *promo.Request) error {
+	if err := c.Check(req); err != nil {
+		return errors.Wrapf(err, "promo code %q", c.Code)
+	}
+
+	u, err := h.promoStorage.Usage(ctx, c.ID, req.Buyer)
+	if err != nil {
+		detail := fmt.Sprintf("can't get usage of promo code %q: %v", c.Code, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	if err := c.CheckUsage(u); err != nil {
+		return errors.Wrapf(err, "promo code %q", c.Code)
+	}
+
+	return nil
+}
+
+// redeemPromo погашает промокод в транзакции создания заказа, чтобы лимиты
+// использования не были превышены параллельными заказами
+func (h *Handler) redeemPromo(ctx context.Context, q *quote, o *order.Order) error {
+	if q.promo == nil {
+		return nil
+	}
+
+	if _, err := h.promoStorage.Reserve(ctx, q.promo, o.Buyer, o.ID); err != nil {
+		return errors.Wrapf(err, "can't reserve promo code %q", q.promo.Code)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net/http"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ratelimit"
+	"safedeal-backend-trainee/pkg/log/logger"
+	"strconv"
+	"time"
+)
+
+// rateLimit ограничивает число запросов клиента по первому подходящему правилу. Клиент - владелец
+// API-ключа, поэтому запрос выполняется после authenticate, а для запросов без ключа - IP-адрес,
+// определенный resolveClientIP.
+// Если счетчики недоступны, запросы пропускаются, чтобы сбой счетчиков не останавливал сервис
+func (h *Handler) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, client := ratelimit.Anonymous, ""
+
+		if p, ok := auth.FromContext(r.Context()); ok {
+			role, client = string(p.Role), "key:"+strconv.FormatInt(p.ID, 10)
+		} else {
+			client = "ip:" + clientip.FromContext(r.Context())
+		}
+
+		rule := h.limiter.Match(r.Method, r.URL.Path, role)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d, err := h.limiter.Allow(r.Context(), rule, client)
+		if err != nil {
+			h.logger.Errorf("can't check rate limit %q: %v", rule.Name, err)
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(d.Reset.Unix(), 10))
+
+		if !d.Allowed {
+			respondTooManyRequests(w, r, d, h.logger)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondTooManyRequests отвечает 429 с заголовком Retry-After до начала следующего окна
+func respondTooManyRequests(w http.ResponseWriter, r *http.Request, d *ratelimit.Decision, l logger.Logger) {
+	retry := int(time.Until(d.Reset).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+
+	msg := "too many requests, retry after " + strconv.Itoa(retry) + " seconds"
+	respondError(w, r, ehttp.TooManyRequestsErr(msg, msg), l)
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/rating"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func ratingsDisabledErr() error {
+	msg := "ratings are not configured"
+	return ehttp.FeatureDisabledErr(msg)
+}
+
+// rateOrder сохраняет отзыв покупателя о доставленном заказе. Отзыв оставляет
+// покупатель заказа по своему API-ключу, и только один раз
+func (h *Handler) rateOrder(w http.ResponseWriter, r *http.Request) error {
+	if h.ratings == nil {
+		return ratingsDisabledErr()
+	}
+
+	var in struct {
+		Buyer   string   `json:"buyer"`
+		Score   int      `json:"score"`
+		Tags    []string `json:"tags"`
+		Comment string   `json:"comment"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return ehttp.JSONUnmarshalErr(err)
+	}
+
+	buyer, err := requestBuyer(r.Context(), in.Buyer)
+	if err != nil {
+		return err
+	}
+
+	orderID, err := orderIDFromURL(r)
+	if err != nil {
+		return err
+	}
+
+	o, err := h.orderStorage.FindByID(r.Context(), orderID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get order with id= %v", orderID)
+	}
+
+	if o.Buyer != buyer {
+		msg := fmt.Sprintf("order with id= %v belongs to another buyer", o.ID)
+		return ehttp.ForbiddenErr(msg, msg)
+	}
+
+	if o.Status != order.Delivered {
+		msg := fmt.Sprintf("order with id= %v is not delivered yet", o.ID)
+		return ehttp.ConflictErr(msg, msg)
+	}
+
+	if in.Tags == nil {
+		in.Tags = []string{}
+	}
+
+	rt := &rating.Rating{
+		OrderID:   o.ID,
+		CourierID: o.CourierID,
+		Pickup:    o.From,
+		Score:     in.Score,
+		Tags:      in.Tags,
+		Comment:   in.Comment,
+		CreatedAt: h.now(),
+	}
+
+	if err = h.ratings.Validate(rt); err != nil {
+		return ehttp.UnprocessableEntityErr(err.Error(), err.Error())
+	}
+
+	err = h.ratings.Add(r.Context(), rt)
+	if err == rating.ErrRated {
+		msg := fmt.Sprintf("order with id= %v is already rated", o.ID)
+		return ehttp.ConflictErr(msg, msg)
+	}
+
+	if err != nil {
+		detail := fmt.Sprintf("can't save rating of order with id= %v: %v", o.ID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	err = respondJSONStatus(w, http.StatusCreated, rt)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with rating: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// ratingsFunc - скользящие оценки курьеров или мест забора
+type ratingsFunc func(b *rating.Board, ctx context.Context, now time.Time) ([]*rating.Aggregate, error)
+
+var (
+	courierRatings ratingsFunc = (*rating.Board).Couriers
+	pickupRatings  ratingsFunc = (*rating.Board).Pickups
+)
+
+// ratingsHandler показывает продавцам скользящие оценки за последние Window
+func (h *Handler) ratingsHandler(list ratingsFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if h.ratings == nil {
+			return ratingsDisabledErr()
+		}
+
+		aa, err := list(h.ratings, r.Context(), h.now())
+		if err != nil {
+			detail := fmt.Sprintf("can't get ratings: %v", err)
+			return ehttp.InternalServerErr(detail)
+		}
+
+		err = respondJSON(w, struct {
+			Window  ftime.Duration      `json:"window"`
+			Ratings []*rating.Aggregate `json:"ratings"`
+		}{
+			Window:  ftime.Duration{Duration: h.ratings.Window()},
+			Ratings: aa,
+		})
+		if err != nil {
+			detail := fmt.Sprintf("can't respond json with ratings: %v", err)
+			return ehttp.InternalServerErr(detail)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/shift"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func shiftsDisabledErr() error {
+	msg := "courier shifts are not configured"
+	return ehttp.FeatureDisabledErr(msg)
+}
+
+type shiftView struct {
+	*shift.Shift
+	Status shift.Status `json:"status"`
+}
+
+func viewShift(s *shift.Shift) *shiftView {
+	return &shiftView{Shift: s, Status: s.Status()}
+}
+
+// createShift планирует смену курьера
+func (h *Handler) createShift(w http.ResponseWriter, r *http.Request) error {
+	if h.shiftStorage == nil {
+		return shiftsDisabledErr()
+	}
+
+	var in struct {
+		CourierID int64             `json:"courier_id"`
+		Zones     []string          `json:"zones"`
+		Start     *ftime.FormatTime `json:"start"`
+		End       *ftime.FormatTime `json:"end"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return ehttp.JSONUnmarshalErr(err)
+	}
+
+	if in.Start == nil || in.End == nil || !in.End.After(in.Start.Time) {
+		msg := "shift must have start and end, end must be after start"
+		return ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	if err := h.checkZones(in.Zones); err != nil {
+		return err
+	}
+
+	c, err := h.courierStorage.FindByID(r.Context(), in.CourierID)
+	if errors.Is(err, domain.ErrNotFound) {
+		return ehttp.UnprocessableEntityErr(err.Error(), err.Error())
+	}
+
+	if err != nil {
+		detail := fmt.Sprintf("can't find courier with id= %v: %v", in.CourierID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	s := &shift.Shift{CourierID: c.ID, Zones: in.Zones, Start: in.Start.Time, End: in.End.Time}
+
+	if err = h.shiftStorage.Create(r.Context(), s); err != nil {
+		detail := fmt.Sprintf("can't create shift: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	err = respondJSONStatus(w, http.StatusCreated, viewShift(s))
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with shift: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// checkZones проверяет, что у смены есть зоны и все они настроены
+func (h *Handler) checkZones(zones []string) error {
+	if len(zones) == 0 {
+		msg := "shift must cover at least one zone"
+		return ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	for _, z := range zones {
+		if len(h.zones) > 0 && h.zones.City(z) == "" {
+			msg := fmt.Sprintf("unknown zone %q", z)
+			return ehttp.UnprocessableEntityErr(msg, msg)
+		}
+	}
+
+	return nil
+}
+
+// getShifts возвращает текущую и будущие смены курьера
+func (h *Handler) getShifts(w http.ResponseWriter, r *http.Request) error {
+	if h.shiftStorage == nil {
+		return shiftsDisabledErr()
+	}
+
+	c, err := h.currentCourier(r)
+	if err != nil {
+		return err
+	}
+
+	shifts, err := h.shiftStorage.Upcoming(r.Context(), c.ID, h.now())
+	if err != nil {
+		detail := fmt.Sprintf("can't get shifts of courier with id= %v: %v", c.ID, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	views := make([]*shiftView, 0, len(shifts))
+	for _, s := range shifts {
+		views = append(views, viewShift(s))
+	}
+
+	err = respondJSON(w, views)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with shifts: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// shiftAction - отметка курьера на текущей смене
+type shiftAction func(s shift.Storage, ctx context.Context, shiftID int64, at time.Time) error
+
+var (
+	clockIn    shiftAction = shift.Storage.ClockIn
+	clockOut   shiftAction = shift.Storage.ClockOut
+	startBreak shiftAction = shift.Storage.StartBreak
+	endBreak   shiftAction = shift.Storage.EndBreak
+)
+
+// shiftHandler выполняет отметку на текущей смене курьера и возвращает смену
+// в новом состоянии. Ошибки состояния смены возвращаются с кодом 409
+func (h *Handler) shiftHandler(action shiftAction) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if h.shiftStorage == nil {
+			return shiftsDisabledErr()
+		}
+
+		c, err := h.currentCourier(r)
+		if err != nil {
+			return err
+		}
+
+		now := h.now()
+		early := h.shifts.EarlyClockIn.Duration
+
+		s, err := h.shiftStorage.Current(r.Context(), c.ID, now, early)
+		if err != nil {
+			return errors.Wrapf(err, "can't get current shift of courier with id= %v", c.ID)
+		}
+
+		if err = action(h.shiftStorage, r.Context(), s.ID, now); err != nil {
+			return errors.Wrapf(err, "can't update shift with id= %v", s.ID)
+		}
+
+		shiftID := s.ID
+
+		s, err = h.shiftStorage.FindByID(r.Context(), shiftID)
+		if err != nil {
+			return errors.Wrapf(err, "can't get shift with id= %v", shiftID)
+		}
+
+		err = respondJSON(w, viewShift(s))
+		if err != nil {
+			detail := fmt.Sprintf("can't respond json with shift: %v", err)
+			return ehttp.InternalServerErr(detail)
+		}
+
+		return nil
+	}
+}
+
+type zoneCoverage struct {
+	Zone      string            `json:"zone"`
+	Intervals []*shift.Interval `json:"intervals"`
+}
+
+// getCoverage показывает продавцам, сколько курьеров запланировано в каждой зоне
+// по рабочим часам на ближайшие дни
+func (h *Handler) getCoverage(w http.ResponseWriter, r *http.Request) error {
+	if h.shiftStorage == nil {
+		return shiftsDisabledErr()
+	}
+
+	days := h.shifts.CoverageDays
+
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > h.shifts.CoverageDays {
+			msg := fmt.Sprintf("days must be from 1 to %v", h.shifts.CoverageDays)
+			return ehttp.BadRequestErr(msg, msg)
+		}
+
+		days = n
+	}
+
+	now := h.now()
+	from := now.Add(-24 * time.Hour)
+	to := now.AddDate(0, 0, days+1)
+
+	shifts, err := h.shiftStorage.Planned(r.Context(), from, to)
+	if err != nil {
+		detail := fmt.Sprintf("can't get planned shifts: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	coverage := make([]*zoneCoverage, 0, len(h.zones))
+
+	for _, z := range h.zones {
+		coverage = append(coverage, &zoneCoverage{
+			Zone:      z.Name,
+			Intervals: shift.Coverage(h.calendar(z.Name), now, days, h.shifts.CoverageStep.Duration, z.Name, shifts),
+		})
+	}
+
+	err = respondJSON(w, coverage)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with coverage: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
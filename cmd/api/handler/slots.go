@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/slot"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (h *Handler) getDeliverySlots(w http.ResponseWriter, r *http.Request) error {
+	if h.slots == nil {
+		msg := "delivery slots are not configured"
+		return ehttp.FeatureDisabledErr(msg)
+	}
+
+	address := r.URL.Query().Get("destination")
+	if address == "" {
+		msg := "destination is required"
+		return ehttp.BadRequestErr(msg, msg)
+	}
+
+	dest, err := h.locate(address)
+	if err != nil {
+		return err
+	}
+
+	slots := h.slots.Slots(h.calendar(dest.zone), h.now())
+
+	if len(slots) > 0 {
+		rr, err := h.slotStorage.Reservations(r.Context(), dest.zone, slots[0].From, slots[len(slots)-1].To)
+		if err != nil {
+			detail := fmt.Sprintf("can't get reservations of delivery slots in zone %q: %v", dest.zone, err)
+			return ehttp.InternalServerErr(detail)
+		}
+
+		slot.Fill(slots, rr)
+	}
+
+	err = respondJSON(w, struct {
+		Destination string       `json:"destination"`
+		Zone        string       `json:"zone"`
+		Slots       []*slot.Slot `json:"slots"`
+	}{
+		Destination: dest.address,
+		Zone:        dest.zone,
+		Slots:       slots,
+	})
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with delivery slots: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+// orderSlot проверяет интервал доставки, выбранный покупателем. Если время доставки
+// не указано, заказ доставляется к началу интервала. Без интервалов доставки
+// время заказа проверяется только по рабочему календарю
+func (h *Handler) orderSlot(dest *destination, start *ftime.FormatTime, at *time.Time) (*slot.Slot, error) {
+	cal := h.calendar(dest.zone)
+
+	if h.slots == nil {
+		return nil, h.checkDeliveryTime(cal, *at)
+	}
+
+	if start == nil {
+		msg := "delivery slot is required"
+		return nil, ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	sl, err := h.slots.Find(cal, start.Time, h.now())
+	if err != nil {
+		detail := fmt.Sprintf("delivery slot %v: %v", start, err)
+		return nil, ehttp.UnprocessableEntityErr(err.Error(), detail)
+	}
+
+	if at.IsZero() {
+		*at = sl.From
+	}
+
+	if !sl.Contains(*at) {
+		msg := "delivery time is outside of delivery slot"
+		detail := fmt.Sprintf("%v: time= %v, slot= [%v, %v)", msg, at, sl.From, sl.To)
+
+		return nil, ehttp.UnprocessableEntityErr(msg, detail)
+	}
+
+	return sl, nil
+}
+
+// checkDeliveryTime проверяет, что время доставки указано, еще не наступило
+// и попадает в рабочее время по календарю
+func (h *Handler) checkDeliveryTime(cal *ftime.Calendar, at time.Time) error {
+	if at.IsZero() {
+		msg := "delivery time is required"
+		return ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	if !at.After(h.now()) {
+		msg := "delivery time must be in the future"
+		detail := fmt.Sprintf("%v: time= %v, now= %v", msg, at, h.now())
+
+		return ehttp.UnprocessableEntityErr(msg, detail)
+	}
+
+	if err := cal.Check(at); err != nil {
+		detail := fmt.Sprintf("delivery time %v: %v", at, err)
+		return ehttp.UnprocessableEntityErr(err.Error(), detail)
+	}
+
+	return nil
+}
+
+func (h *Handler) reserveSlot(ctx context.Context, zone string, sl *slot.Slot) error {
+	if sl == nil {
+		return nil
+	}
+
+	err := h.slotStorage.Reserve(ctx, zone, sl.From, sl.Capacity)
+	if err != nil {
+		return errors.Wrapf(err, "can't reserve delivery slot %v in zone %q", sl.From, zone)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ehttp"
+	"safedeal-backend-trainee/internal/surge"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+)
+
+type surgeState struct {
+	Zone       string          `json:"zone"`
+	Multiplier float64         `json:"multiplier"`
+	Computed   float64         `json:"computed"`
+	Orders     int             `json:"orders"`
+	Couriers   int             `json:"couriers"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Override   *surge.Override `json:"override,omitempty"`
+}
+
+// surgeMultiplier возвращает коэффициент для зоны и времени доставки
+// (если повышение цены не настроено, коэффициент равен 1)
+func (h *Handler) surgeMultiplier(ctx context.Context, zone string, at time.Time) (float64, error) {
+	if h.surge == nil {
+		return surge.NoSurge, nil
+	}
+
+	st, err := h.surgeState(ctx, zone, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return st.Multiplier, nil
+}
+
+func (h *Handler) surgeState(ctx context.Context, zone string, at time.Time) (*surgeState, error) {
+	from, to := h.surge.Bucket(at)
+
+	orders, err := h.orderStorage.CountByZone(ctx, zone, from, to)
+	if err != nil {
+		detail := fmt.Sprintf("can't count orders in zone %q: %v", zone, err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	couriers, err := h.courierStorage.CountAvailable(ctx, zone)
+	if err != nil {
+		detail := fmt.Sprintf("can't count available couriers in zone %q: %v", zone, err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	o, err := h.surgeStorage.FindOverride(ctx, zone)
+	if errors.Is(err, domain.ErrNotFound) {
+		o, err = nil, nil
+	}
+
+	if err != nil {
+		detail := fmt.Sprintf("can't find surge override for zone %q: %v", zone, err)
+		return nil, ehttp.InternalServerErr(detail)
+	}
+
+	st := &surgeState{
+		Zone:     zone,
+		Computed: h.surge.Multiplier(orders, couriers),
+		Orders:   orders,
+		Couriers: couriers,
+		From:     from,
+		To:       to,
+	}
+
+	st.Multiplier = st.Computed
+
+	if o != nil && o.Active(h.now()) {
+		st.Override = o
+		st.Multiplier = h.surge.Limit(o.Multiplier)
+	}
+
+	return st, nil
+}
+
+func (h *Handler) getSurge(w http.ResponseWriter, r *http.Request) error {
+	if h.surge == nil {
+		return surgeDisabledErr()
+	}
+
+	st, err := h.surgeState(r.Context(), chi.URLParam(r, "zone"), h.now())
+	if err != nil {
+		return err
+	}
+
+	err = respondJSON(w, st)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with surge info: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+func (h *Handler) setSurge(w http.ResponseWriter, r *http.Request) error {
+	if h.surge == nil {
+		return surgeDisabledErr()
+	}
+
+	var o surge.Override
+
+	err := json.NewDecoder(r.Body).Decode(&o)
+	if err != nil {
+		return ehttp.JSONUnmarshalErr(err)
+	}
+
+	o.Zone = chi.URLParam(r, "zone")
+
+	switch o.Mode {
+	case surge.ModeOverride:
+		if o.Multiplier < surge.NoSurge {
+			msg := fmt.Sprintf("multiplier must be at least %v", surge.NoSurge)
+			return ehttp.UnprocessableEntityErr(msg, msg)
+		}
+
+		o.Multiplier = h.surge.Limit(o.Multiplier)
+	case surge.ModeFreeze:
+		st, err := h.surgeState(r.Context(), o.Zone, h.now())
+		if err != nil {
+			return err
+		}
+
+		o.Multiplier = st.Computed
+	default:
+		msg := fmt.Sprintf("unknown surge mode %q", o.Mode)
+		return ehttp.UnprocessableEntityErr(msg, msg)
+	}
+
+	err = h.surgeStorage.SaveOverride(r.Context(), &o)
+	if err != nil {
+		detail := fmt.Sprintf("can't save surge override for zone %q: %v", o.Zone, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	err = respondJSON(w, o)
+	if err != nil {
+		detail := fmt.Sprintf("can't respond json with surge override: %v", err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	return nil
+}
+
+func (h *Handler) deleteSurge(w http.ResponseWriter, r *http.Request) error {
+	if h.surge == nil {
+		return surgeDisabledErr()
+	}
+
+	zone := chi.URLParam(r, "zone")
+
+	err := h.surgeStorage.DeleteOverride(r.Context(), zone)
+	if err != nil {
+		detail := fmt.Sprintf("can't delete surge override for zone %q: %v", zone, err)
+		return ehttp.InternalServerErr(detail)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func surgeDisabledErr() error {
+	msg := "surge pricing is not configured"
+	return ehttp.FeatureDisabledErr(msg)
+}
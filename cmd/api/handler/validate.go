@@ -0,0 +1,19 @@
+package handler
+
+import "safedeal-backend-trainee/internal/validation"
+
+// Ограничения длины полей запросов совпадают с размерами столбцов таблиц orders и order_assignments
+const (
+	maxDestination = 200
+	maxBuyer       = 200
+	maxPromoCode   = 50
+	maxReason      = 500
+)
+
+// validateDelivery проверяет поля, общие для запросов стоимости доставки и создания заказа
+func validateDelivery(v *validation.Validator, destination string, promoCode string, buyer string) {
+	v.Required("destination", destination)
+	v.MaxLength("destination", destination, maxDestination)
+	v.MaxLength("promo_code", promoCode, maxPromoCode)
+	v.MaxLength("buyer", buyer, maxBuyer)
+}
@@ -2,17 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"safedeal-backend-trainee/cmd/api/handler"
+	"safedeal-backend-trainee/internal/clientip"
+	"safedeal-backend-trainee/internal/dispatch"
+	"safedeal-backend-trainee/internal/earnings"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/idempotency"
+	"safedeal-backend-trainee/internal/kv"
+	"safedeal-backend-trainee/internal/memory"
+	"safedeal-backend-trainee/internal/order"
 	"safedeal-backend-trainee/internal/postgres"
+	"safedeal-backend-trainee/internal/pricing"
+	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/ratelimit"
+	"safedeal-backend-trainee/internal/rating"
+	"safedeal-backend-trainee/internal/resp"
+	"safedeal-backend-trainee/internal/routing"
+	"safedeal-backend-trainee/internal/slot"
+	"safedeal-backend-trainee/internal/surge"
 	"safedeal-backend-trainee/pkg/log/logger"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,26 +42,180 @@ import (
 
 func main() {
 	var port = flag.String("port", "5000", "The port which server listen")
+	var migrate = flag.Bool("migrate", false, "Apply pending database migrations on startup")
+	var storage = flag.String("storage", postgresBackend, "Storage backend: memory or postgres")
+	var products = flag.String("products", "", "JSON file with products for the memory storage")
 
 	flag.Parse()
 
 	logger := initLogger()
 
-	st, closers := initStorages(logger)
+	filename := configFilename(logger)
 
-	defer handleClosers(logger, closers)
+	config, err := parseConfig(filename)
+	if err != nil {
+		logger.Fatalf("can't parse configuration: %v", err)
+	}
+
+	calendars, err := ftime.LoadCalendars(config.Cities, config.DefaultCity)
+	if err != nil {
+		logger.Fatalf("can't load calendars: %v", err)
+	}
+
+	geocoder := geo.NewHashGeocoder(config.Geo.Bounds)
+
+	opts := []handler.Option{
+		handler.WithGeo(geocoder, config.Geo.Zones),
+		handler.WithPricing(pricing.New(config.Pricing)),
+		handler.WithCalendars(calendars),
+	}
+
+	// фоновые циклы останавливаются при завершении сервера до закрытия БД
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var background sync.WaitGroup
+
+	store, storeClosers := initStore(logger, config.KV)
+
+	defer handleClosers(logger, storeClosers)
+
+	if err = config.RateLimits.Validate(); err != nil {
+		logger.Fatalf("invalid rate limits: %v", err)
+	}
+
+	opts = append(opts, handler.WithRateLimit(ratelimit.New(config.RateLimits, store)))
+
+	if err = config.Idempotency.Validate(); err != nil {
+		logger.Fatalf("invalid idempotency configuration: %v", err)
+	}
+
+	if err = config.Dispatch.Validate(); err != nil {
+		logger.Fatalf("invalid dispatch configuration: %v", err)
+	}
+
+	resolver, err := clientip.New(config.ClientIP)
+	if err != nil {
+		logger.Fatalf("invalid client ip configuration: %v", err)
+	}
+
+	opts = append(opts, handler.WithTrustedProxies(resolver))
+
+	var (
+		p    product.Storage
+		o    order.Storage
+		keys idempotency.Storage
+	)
+
+	switch *storage {
+	case memoryBackend:
+		p, o = initMemoryStorages(logger, *products)
+		keys = memory.NewIdempotencyStorage()
+	case postgresBackend:
+		st, closers := initStorages(logger, filename, *migrate, config.ProductCache, store)
+
+		defer handleClosers(logger, closers)
+
+		ratings := rating.New(config.Ratings, st.rating)
+
+		p, o, keys = st.p, st.o, st.idempotency
+		opts = append(opts,
+			handler.WithPromo(st.promo),
+			handler.WithAuth(st.auth),
+			handler.WithSurge(surge.New(config.Surge), st.surge, st.courier),
+			handler.WithSlots(slot.New(config.Slots), st.slot),
+			handler.WithRouting(routing.New(config.Routing), st.courier),
+			handler.WithDispatch(st.dispatch),
+			handler.WithShifts(config.Shifts, st.shift, st.courier),
+			handler.WithEarnings(earnings.New(config.Earnings), st.earnings, st.courier),
+			handler.WithRatings(ratings),
+			handler.WithUnitOfWork(st.uow),
+		)
+
+		if st.cache != nil {
+			opts = append(opts, handler.WithProductCache(st.cache))
+			goBackground(&background, func() { st.listener.Run(ctx, st.cache) })
+		}
+
+		if config.Dispatch.Enabled {
+			d := dispatch.New(config.Dispatch, st.dispatch, st.o, st.p, geocoder, ratings, logger)
+			goBackground(&background, func() { d.Run(ctx) })
+		}
+	default:
+		logger.Fatalf("unknown storage %q, expected %q or %q", *storage, memoryBackend, postgresBackend)
+	}
+
+	keeper := idempotency.New(config.Idempotency, keys)
+	opts = append(opts, handler.WithIdempotency(keeper))
+
+	goBackground(&background, func() { keeper.Run(ctx, logger) })
+
+	h := handler.New(p, o, logger, opts...)
 
-	h := handler.New(st.p, st.o, logger)
 	srv := initServer(h, "", *port)
 
 	const Duration = 5
-	go gracefulShutdown(srv, Duration*time.Second, logger)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		gracefulShutdown(srv, Duration*time.Second, logger)
+		close(stopped)
+	}()
 
 	logger.Infof("Server is running at %s", *port)
 
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+
+	// ListenAndServe возвращается сразу после начала Shutdown, а запросы и фоновые циклы
+	// должны завершиться до того, как отложенные вызовы закроют БД
+	<-stopped
+	cancel()
+	background.Wait()
+}
+
+// goBackground запускает f в отдельной горутине, wg ждет ее завершения
+func goBackground(wg *sync.WaitGroup, f func()) {
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		f()
+	}()
+}
+
+const (
+	memoryBackend   = "memory"
+	postgresBackend = "postgres"
+)
+
+// initMemoryStorages создает хранилища в памяти. Товары загружаются из filename, если он задан.
+// Остальные возможности сервиса хранят данные только в БД и в этом режиме выключены
+func initMemoryStorages(logger logger.Logger, filename string) (*memory.ProductStorage, *memory.OrderStorage) {
+	ps := memory.NewProductStorage()
+
+	if filename != "" {
+		byteData, err := ioutil.ReadFile(filename)
+		if err != nil {
+			logger.Fatalf("can't read products: %v", err)
+		}
+
+		var pp []*product.Product
+
+		if err = json.Unmarshal(byteData, &pp); err != nil {
+			logger.Fatalf("can't unmarshal json with products: %v", err)
+		}
+
+		for _, p := range pp {
+			if err = ps.Create(p); err != nil {
+				logger.Fatalf("can't create product: %v", err)
+			}
+		}
+	}
+
+	return ps, memory.NewOrderStorage()
 }
 
 func handleClosers(l logger.Logger, m map[string]io.Closer) {
@@ -53,13 +227,25 @@ func handleClosers(l logger.Logger, m map[string]io.Closer) {
 }
 
 type storages struct {
-	p *postgres.ProductStorage
-	o *postgres.OrderStorage
+	p           product.Storage
+	o           *postgres.OrderStorage
+	promo       *postgres.PromoStorage
+	auth        *postgres.AuthStorage
+	courier     *postgres.CourierStorage
+	surge       *postgres.SurgeStorage
+	slot        *postgres.SlotStorage
+	dispatch    *postgres.DispatchStorage
+	shift       *postgres.ShiftStorage
+	earnings    *postgres.EarningsStorage
+	rating      *postgres.RatingStorage
+	idempotency *postgres.IdempotencyStorage
+	uow         *postgres.UnitOfWork
+	// cache - кэш товаров поверх p, nil, если выключен
+	cache    *product.Cache
+	listener *postgres.ProductListener
 }
 
-func initStorages(logger logger.Logger) (*storages, map[string]io.Closer) {
-	closers := make(map[string]io.Closer)
-
+func configFilename(logger logger.Logger) string {
 	_ = os.Chdir("../..")
 
 	pwd, err := os.Getwd()
@@ -67,7 +253,14 @@ func initStorages(logger logger.Logger) (*storages, map[string]io.Closer) {
 		logger.Fatalf("can't get path: %v", err)
 	}
 
-	db, err := postgres.New(logger, fmt.Sprintf("%s/configuration.json", pwd))
+	return fmt.Sprintf("%s/configuration.json", pwd)
+}
+
+func initStorages(logger logger.Logger, filename string, migrate bool,
+	cache product.CacheConfiguration, store kv.Store) (*storages, map[string]io.Closer) {
+	closers := make(map[string]io.Closer)
+
+	db, err := postgres.New(logger, filename)
 	if err != nil {
 		logger.Fatalf("can't create database instance %v", err)
 	}
@@ -79,6 +272,10 @@ func initStorages(logger logger.Logger) (*storages, map[string]io.Closer) {
 		logger.Fatalf("can't connect to database %v", err)
 	}
 
+	if migrate {
+		applyMigrations(logger, db)
+	}
+
 	productStorage, err := postgres.NewProductStorage(db)
 	if err != nil {
 		logger.Fatalf("can't create product storage: %s", err)
@@ -93,7 +290,142 @@ func initStorages(logger logger.Logger) (*storages, map[string]io.Closer) {
 
 	closers["order_storage"] = productStorage
 
-	return &storages{productStorage, orderStorage}, closers
+	promoStorage, err := postgres.NewPromoStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create promo storage: %s", err)
+	}
+
+	closers["promo_storage"] = promoStorage
+
+	authStorage, err := postgres.NewAuthStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create auth storage: %s", err)
+	}
+
+	closers["auth_storage"] = authStorage
+
+	courierStorage, err := postgres.NewCourierStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create courier storage: %s", err)
+	}
+
+	closers["courier_storage"] = courierStorage
+
+	surgeStorage, err := postgres.NewSurgeStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create surge storage: %s", err)
+	}
+
+	closers["surge_storage"] = surgeStorage
+
+	slotStorage, err := postgres.NewSlotStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create slot storage: %s", err)
+	}
+
+	closers["slot_storage"] = slotStorage
+
+	dispatchStorage, err := postgres.NewDispatchStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create dispatch storage: %s", err)
+	}
+
+	closers["dispatch_storage"] = dispatchStorage
+
+	shiftStorage, err := postgres.NewShiftStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create shift storage: %s", err)
+	}
+
+	closers["shift_storage"] = shiftStorage
+
+	earningsStorage, err := postgres.NewEarningsStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create earnings storage: %s", err)
+	}
+
+	closers["earnings_storage"] = earningsStorage
+
+	ratingStorage, err := postgres.NewRatingStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create rating storage: %s", err)
+	}
+
+	closers["rating_storage"] = ratingStorage
+
+	idempotencyStorage, err := postgres.NewIdempotencyStorage(db)
+	if err != nil {
+		logger.Fatalf("can't create idempotency storage: %s", err)
+	}
+
+	closers["idempotency_storage"] = idempotencyStorage
+
+	st := &storages{
+		productStorage, orderStorage, promoStorage, authStorage, courierStorage, surgeStorage, slotStorage,
+		dispatchStorage, shiftStorage, earningsStorage, ratingStorage, idempotencyStorage, postgres.NewUnitOfWork(db),
+		nil, nil,
+	}
+
+	if cache.Enabled {
+		st.listener, err = postgres.NewProductListener(db)
+		if err != nil {
+			logger.Fatalf("can't listen product changes: %s", err)
+		}
+
+		closers["product_listener"] = st.listener
+
+		var products product.Storage = productStorage
+		if cache.Shared {
+			products = product.NewSharedCache(productStorage, store, cache.TTL.Duration)
+		}
+
+		st.cache = product.NewCache(products, cache)
+		st.p = st.cache
+	}
+
+	return st, closers
+}
+
+// initStore подключается к серверу с протоколом Redis, в котором хранятся счетчики ограничения
+// запросов и общий кэш товаров. Без адреса в конфигурации запускается встроенный сервер
+func initStore(logger logger.Logger, c resp.Configuration) (*resp.Client, map[string]io.Closer) {
+	closers := make(map[string]io.Closer)
+
+	addr := c.Addr
+	if addr == "" {
+		srv, err := resp.StartServer("127.0.0.1:0")
+		if err != nil {
+			logger.Fatalf("can't start embedded resp server: %v", err)
+		}
+
+		closers["resp_server"] = srv
+		addr = srv.Addr()
+
+		logger.Infof("Embedded resp server is running at %s, counters are local to this instance", addr)
+	}
+
+	client := resp.NewClient(addr, c)
+	closers["resp_client"] = client
+
+	return client, closers
+}
+
+// applyMigrations применяет миграции до подготовки запросов хранилищ,
+// которые ссылаются на новые таблицы и столбцы
+func applyMigrations(logger logger.Logger, db *postgres.DB) {
+	m, err := postgres.NewMigrator(db)
+	if err != nil {
+		logger.Fatalf("can't load migrations: %v", err)
+	}
+
+	done, err := m.Up()
+	if err != nil {
+		logger.Fatalf("can't apply migrations: %v", err)
+	}
+
+	for _, mg := range done {
+		logger.Infof("applied migration %04d_%s", mg.Version, mg.Name)
+	}
 }
 
 func initServer(h *handler.Handler, host string, port string) *http.Server {
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"safedeal-backend-trainee/internal/postgres"
+	"safedeal-backend-trainee/pkg/log/logger"
+)
+
+const usage = `Usage: migrate [-config configuration.json] up|down|status
+
+  up      apply all pending migrations
+  down    revert the last applied migration
+  status  list migrations and when they were applied
+`
+
+func main() {
+	var config = flag.String("config", "configuration.json", "Path to the file with database settings")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	l, err := logger.New(logger.Configuration{
+		EnableConsole: true,
+		ConsoleLevel:  logger.Info,
+	}, logger.InstanceZapLogger)
+	if err != nil {
+		log.Fatal("could not instantiate logger: ", err)
+	}
+
+	db, err := postgres.New(l, *config)
+	if err != nil {
+		l.Fatalf("can't create database instance %v", err)
+	}
+
+	defer db.Close()
+
+	if err = db.CheckConnection(); err != nil {
+		l.Fatalf("can't connect to database %v", err)
+	}
+
+	m, err := postgres.NewMigrator(db)
+	if err != nil {
+		l.Fatalf("can't load migrations: %v", err)
+	}
+
+	if err = run(m, flag.Arg(0)); err != nil {
+		l.Fatalf("%v", err)
+	}
+}
+
+func run(m *postgres.Migrator, command string) error {
+	switch command {
+	case "up":
+		done, err := m.Up()
+		for _, mg := range done {
+			fmt.Printf("applied %04d_%s\n", mg.Version, mg.Name)
+		}
+
+		if err == nil && len(done) == 0 {
+			fmt.Println("no pending migrations")
+		}
+
+		return err
+	case "down":
+		mg, err := m.Down()
+		if err != nil {
+			return err
+		}
+
+		if mg == nil {
+			fmt.Println("no applied migrations")
+			return nil
+		}
+
+		fmt.Printf("reverted %04d_%s\n", mg.Version, mg.Name)
+
+		return nil
+	case "status":
+		ss, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range ss {
+			fmt.Println(s)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, expected up, down or status", command)
+	}
+}
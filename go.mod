@@ -1,12 +1,10 @@
 module safedeal-backend-trainee
 
-go 1.13
+go 1.16
 
 require (
 	github.com/go-chi/chi v4.1.2+incompatible
-	github.com/go-chi/httprate v0.4.0
 	github.com/lib/pq v1.7.0
 	github.com/pkg/errors v0.9.1
 	go.uber.org/zap v1.15.0
-	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
 )
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+type Role string
+
+const (
+	Admin   Role = "admin"
+	Seller  Role = "seller"
+	Courier Role = "courier"
+	Buyer   Role = "buyer"
+)
+
+// Principal - владелец API-ключа. SubjectID указывает на сущность роли
+// (например, на курьера), для администратора он не задан
+type Principal struct {
+	ID        int64
+	Role      Role
+	SubjectID int64
+}
+
+func (p *Principal) HasRole(roles ...Role) bool {
+	for _, r := range roles {
+		if p.Role == r {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BuyerID - идентификатор покупателя, под которым сохраняются его заказы и погашения промокодов
+func (p *Principal) BuyerID() string {
+	return strconv.FormatInt(p.SubjectID, 10)
+}
+
+type Storage interface {
+	// FindByKey возвращает ошибку вида domain.ErrNotFound, если ключ не найден
+	FindByKey(ctx context.Context, key string) (*Principal, error)
+}
+
+// HashKey возвращает хэш ключа, в БД ключи в открытом виде не хранятся
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+	return p, ok
+}
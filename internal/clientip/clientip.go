@@ -0,0 +1,151 @@
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type Configuration struct {
+	// TrustedProxies - адреса и подсети (CIDR) прокси и балансировщиков перед сервисом.
+	// Заголовки X-Forwarded-For и Forwarded учитываются, только если запрос пришел от них
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// Resolver определяет IP-адрес клиента. Цепочка адресов из заголовков проверяется справа налево:
+// клиент - первый адрес, который не принадлежит доверенному прокси. Адреса левее него мог
+// подставить сам клиент, поэтому они не учитываются
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New создает Resolver. Без доверенных прокси адрес клиента - адрес соединения
+func New(c Configuration) (*Resolver, error) {
+	r := &Resolver{trusted: make([]*net.IPNet, 0, len(c.TrustedProxies))}
+
+	for _, s := range c.TrustedProxies {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy address %q", s)
+			}
+
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted proxy subnet %q", s)
+		}
+
+		r.trusted = append(r.trusted, n)
+	}
+
+	return r, nil
+}
+
+// Resolve возвращает IP-адрес клиента. Если в цепочке прокси есть адрес, который не удалось
+// разобрать, клиентом считается последний доверенный прокси перед ним
+func (r *Resolver) Resolve(req *http.Request) string {
+	ip := parseIP(req.RemoteAddr)
+	if ip == nil {
+		return req.RemoteAddr
+	}
+
+	if !r.isTrusted(ip) {
+		return ip.String()
+	}
+
+	hops := forwarded(req.Header)
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseIP(hops[i])
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, n := range r.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded возвращает цепочку адресов из заголовка Forwarded (RFC 7239), а если его нет - из X-Forwarded-For
+func forwarded(h http.Header) []string {
+	values := h.Values("Forwarded")
+	if len(values) == 0 {
+		var hops []string
+
+		for _, v := range h.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+
+		return hops
+	}
+
+	var hops []string
+
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			// адрес прокси без параметра for неизвестен, поэтому цепочка ему не доверяется
+			hop := ""
+
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hop = strings.Trim(kv[1], `"`)
+				}
+			}
+
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// parseIP разбирает адрес вида 1.2.3.4, 1.2.3.4:80, ::1 или [::1]:80. Для скрытых
+// идентификаторов RFC 7239 вроде unknown и _hidden возвращается nil
+func parseIP(s string) net.IP {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ip)
+}
+
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKey{}).(string)
+	return ip
+}
@@ -0,0 +1,41 @@
+package courier
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/geo"
+	"time"
+)
+
+type Vehicle string
+
+const (
+	Foot Vehicle = "foot"
+	Bike Vehicle = "bike"
+	Car  Vehicle = "car"
+)
+
+type Courier struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Vehicle   Vehicle `json:"vehicle"`
+	Zone      string  `json:"zone"`
+	Available bool    `json:"available"`
+	// Location - последнее местоположение, о котором сообщил курьер
+	Location  *geo.Point `json:"location,omitempty"`
+	LocatedAt *time.Time `json:"located_at,omitempty"`
+}
+
+type Storage interface {
+	// FindByID возвращает ошибку вида domain.ErrNotFound, если курьера нет
+	FindByID(ctx context.Context, id int64) (*Courier, error)
+	// CountAvailable возвращает число свободных курьеров, работающих на смене в зоне
+	CountAvailable(ctx context.Context, zone string) (int, error)
+	UpdateLocation(ctx context.Context, id int64, p geo.Point, at time.Time) error
+	// PickUp отмечает, что курьер забрал заказ версии version. Если заказ не назначен курьеру,
+	// возвращается ErrNotAssigned, если версия заказа изменилась - order.ErrStale
+	PickUp(ctx context.Context, orderID int64, courierID int64, version int64, at time.Time) error
+}
+
+// ErrNotAssigned - заказ не назначен курьеру или уже доставлен
+var ErrNotAssigned = domain.Conflict("order is not assigned to courier")
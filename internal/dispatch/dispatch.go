@@ -0,0 +1,202 @@
+package dispatch
+
+import (
+	"context"
+	"math"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/product"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Weights struct {
+	Distance float64 `json:"distance"`
+	Capacity float64 `json:"capacity"`
+	Workload float64 `json:"workload"`
+	Rating   float64 `json:"rating"`
+}
+
+// Capacity - вместимость транспорта: суммарный вес заказов в кг
+// и наибольший размер одного товара в см
+type Capacity struct {
+	Weight float64 `json:"weight"`
+	Size   float64 `json:"size"`
+}
+
+type Configuration struct {
+	Enabled bool `json:"enabled"`
+	// Interval - период, с которым диспетчер распределяет заказы
+	Interval ftime.Duration `json:"interval"`
+	// BatchSize - сколько заказов распределяется за один проход
+	BatchSize int     `json:"batch_size"`
+	Weights   Weights `json:"weights"`
+	// MaxDistance - курьеры дальше этого расстояния (км) от места забора не рассматриваются
+	MaxDistance float64 `json:"max_distance"`
+	// MaxOrders - наибольшее число активных заказов курьера, полностью загруженному курьеру
+	// новые заказы не назначаются
+	MaxOrders  int                          `json:"max_orders"`
+	Capacities map[courier.Vehicle]Capacity `json:"capacities"`
+}
+
+var DefaultConfiguration = Configuration{
+	Interval:    ftime.Duration{Duration: time.Minute},
+	BatchSize:   50,
+	Weights:     Weights{Distance: 0.4, Capacity: 0.15, Workload: 0.25, Rating: 0.2},
+	MaxDistance: 10,
+	MaxOrders:   5,
+	Capacities: map[courier.Vehicle]Capacity{
+		courier.Foot: {Weight: 10, Size: 60},
+		courier.Bike: {Weight: 20, Size: 80},
+		courier.Car:  {Weight: 300, Size: 200},
+	},
+}
+
+// Validate проверяет, что у включенного диспетчера положительные период и размер прохода
+func (c Configuration) Validate() error {
+	if c.Enabled && (c.Interval.Duration <= 0 || c.BatchSize <= 0) {
+		return errors.New("dispatch interval and batch size must be positive")
+	}
+
+	return nil
+}
+
+type Action string
+
+const (
+	Assign   Action = "assign"
+	Unassign Action = "unassign"
+)
+
+// ActorDispatcher - автор назначений, сделанных диспетчером
+const ActorDispatcher = "dispatcher"
+
+// Assignment - запись журнала назначений. Назначение отменяется записью Unassign,
+// после которой заказ снова попадает к диспетчеру. IP - адрес клиента, изменившего назначение
+// через API, у назначений диспетчера он пустой
+type Assignment struct {
+	ID        int64     `json:"id"`
+	OrderID   int64     `json:"order_id"`
+	CourierID int64     `json:"courier_id"`
+	Action    Action    `json:"action"`
+	Score     float64   `json:"score,omitempty"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NeutralRating - оценка курьера, о котором еще мало отзывов
+const NeutralRating = 0.5
+
+// Load - свободный курьер и его текущие активные заказы. Rating - оценка
+// курьера покупателями от 0 до 1
+type Load struct {
+	Courier *courier.Courier
+	Orders  int
+	Weight  float64
+	Rating  float64
+}
+
+var (
+	// ErrTaken - заказ уже назначен или перестал ждать курьера
+	ErrTaken = domain.Conflict("order is already assigned")
+	// ErrNotAssigned - у заказа нет курьера, отменять нечего
+	ErrNotAssigned = domain.Conflict("order is not assigned")
+)
+
+type Storage interface {
+	// Couriers возвращает свободных курьеров, работающих на смене в зоне, с их загрузкой
+	Couriers(ctx context.Context, zone string) ([]*Load, error)
+	// Assign назначает курьера, если заказ еще не назначен, и пишет запись в журнал
+	Assign(ctx context.Context, a *Assignment) error
+	// Unassign снимает курьера с заказа версии version и пишет запись в журнал.
+	// Если версия заказа изменилась, возвращается order.ErrStale
+	Unassign(ctx context.Context, a *Assignment, version int64) error
+	History(ctx context.Context, orderID int64) ([]*Assignment, error)
+}
+
+// Candidate - оценка курьера для заказа
+type Candidate struct {
+	Load     *Load
+	Distance float64
+	Score    float64
+}
+
+type Scorer struct {
+	config Configuration
+}
+
+func NewScorer(c Configuration) *Scorer {
+	return &Scorer{config: c}
+}
+
+// Rank возвращает подходящих курьеров по убыванию оценки. Курьеры, у которых
+// не хватает вместимости, которые дальше MaxDistance или уже везут MaxOrders заказов, отбрасываются.
+// Оценка - взвешенная сумма близости к месту забора, оставшейся вместимости,
+// незагруженности курьера и его рейтинга (каждая составляющая от 0 до 1)
+func (s *Scorer) Rank(pickup geo.Point, p *product.Product, loads []*Load, excluded map[int64]bool) []*Candidate {
+	cc := make([]*Candidate, 0, len(loads))
+
+	for _, l := range loads {
+		if excluded[l.Courier.ID] {
+			continue
+		}
+
+		if s.config.MaxOrders > 0 && l.Orders >= s.config.MaxOrders {
+			continue
+		}
+
+		capacity, ok := s.config.Capacities[l.Courier.Vehicle]
+		if !ok || !fits(capacity, p) {
+			continue
+		}
+
+		remaining := capacity.Weight - l.Weight - float64(p.Weight)
+		if remaining < 0 {
+			continue
+		}
+
+		c := &Candidate{Load: l, Distance: s.config.MaxDistance}
+
+		if l.Courier.Location != nil {
+			c.Distance = geo.Distance(*l.Courier.Location, pickup)
+			if c.Distance > s.config.MaxDistance {
+				continue
+			}
+		}
+
+		w := s.config.Weights
+		c.Score = w.Distance*ratio(s.config.MaxDistance-c.Distance, s.config.MaxDistance) +
+			w.Capacity*ratio(remaining, capacity.Weight) +
+			w.Workload*ratio(float64(s.config.MaxOrders-l.Orders), float64(s.config.MaxOrders)) +
+			w.Rating*ratio(l.Rating, 1)
+		c.Score = math.Round(c.Score*1000) / 1000 // nolint: gomnd
+
+		cc = append(cc, c)
+	}
+
+	sort.SliceStable(cc, func(i, j int) bool {
+		return cc[i].Score > cc[j].Score
+	})
+
+	return cc
+}
+
+// fits проверяет, что самая длинная сторона товара помещается в транспорт
+func fits(c Capacity, p *product.Product) bool {
+	size := math.Max(float64(p.Width), math.Max(float64(p.Length), float64(p.Height)))
+	return size <= c.Size
+}
+
+func ratio(v float64, max float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+
+	return math.Max(0, math.Min(1, v/max))
+}
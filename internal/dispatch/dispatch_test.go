@@ -0,0 +1,183 @@
+package dispatch
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/memory"
+	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/pkg/log/logger"
+	"testing"
+	"time"
+)
+
+type mockStorage struct {
+	loads    []*Load
+	assigned []*Assignment
+	history  map[int64][]*Assignment
+	Storage
+}
+
+func (m *mockStorage) Couriers(ctx context.Context, zone string) ([]*Load, error) {
+	return m.loads, nil
+}
+
+func (m *mockStorage) Assign(ctx context.Context, a *Assignment) error {
+	m.assigned = append(m.assigned, a)
+	return nil
+}
+
+func (m *mockStorage) History(ctx context.Context, orderID int64) ([]*Assignment, error) {
+	return m.history[orderID], nil
+}
+
+type mockLogger struct {
+	logger.Logger
+}
+
+func (m mockLogger) Infof(format string, args ...interface{})  {}
+func (m mockLogger) Errorf(format string, args ...interface{}) {}
+
+func load(id int64, v courier.Vehicle, at *geo.Point, orders int) *Load {
+	return &Load{
+		Courier: &courier.Courier{ID: id, Vehicle: v, Location: at},
+		Orders:  orders,
+		Rating:  NeutralRating,
+	}
+}
+
+func TestRank(t *testing.T) {
+	pickup := geo.Point{Lat: 55.75, Lon: 37.61}
+	near := &geo.Point{Lat: 55.751, Lon: 37.611}
+	farther := &geo.Point{Lat: 55.77, Lon: 37.64}
+	tooFar := &geo.Point{Lat: 56.5, Lon: 38.5}
+
+	p := &product.Product{Width: 50, Length: 40, Height: 30, Weight: 5}
+
+	loads := []*Load{
+		load(1, courier.Car, farther, 0),
+		load(2, courier.Car, tooFar, 0),
+		load(3, courier.Car, near, 0),
+		load(4, courier.Foot, near, 0),
+		load(5, courier.Car, near, DefaultConfiguration.MaxOrders),
+		load(6, courier.Car, near, 0),
+	}
+
+	// у пешего курьера 4 не хватает вместимости
+	loads[3].Weight = 8
+
+	cc := NewScorer(DefaultConfiguration).Rank(pickup, p, loads, map[int64]bool{6: true})
+
+	ids := make([]int64, 0, len(cc))
+	for _, c := range cc {
+		ids = append(ids, c.Load.Courier.ID)
+	}
+
+	// 2 дальше max_distance, 4 не хватает вместимости, 5 полностью загружен, 6 уже снимали с заказа
+	expected := []int64{3, 1}
+	if len(ids) != len(expected) || ids[0] != expected[0] || ids[1] != expected[1] {
+		t.Fatalf("Rank returned couriers %v, want %v", ids, expected)
+	}
+
+	if cc[0].Score <= cc[1].Score {
+		t.Errorf("Rank returned scores %v and %v, want descending", cc[0].Score, cc[1].Score)
+	}
+}
+
+func TestRankTooLargeProduct(t *testing.T) {
+	p := &product.Product{Width: 250}
+
+	cc := NewScorer(DefaultConfiguration).Rank(geo.Point{}, p, []*Load{load(1, courier.Car, nil, 0)}, nil)
+	if len(cc) != 0 {
+		t.Errorf("Rank returned %v couriers for product larger than any vehicle, want none", len(cc))
+	}
+}
+
+// dispatcher создает диспетчера над заказами в памяти: первый заказ не помещается ни в один транспорт
+func dispatcher(t *testing.T, c Configuration, s *mockStorage, orders int) *Dispatcher {
+	t.Helper()
+
+	products := memory.NewProductStorage()
+	for _, p := range []*product.Product{{Name: "Шкаф", Width: 250}, {Name: "Книга", Weight: 1}} {
+		if err := products.Create(p); err != nil {
+			t.Fatalf("can't create product %v", err)
+		}
+	}
+
+	oo := memory.NewOrderStorage()
+	at := time.Date(2020, 6, 15, 13, 0, 0, 0, time.UTC)
+
+	for i := 0; i < orders; i++ {
+		productID := int64(2)
+		if i == 0 {
+			productID = 1
+		}
+
+		o := &order.Order{
+			ProductID: productID,
+			From:      "Тверской бульвар, 25",
+			Zone:      "center",
+			Time:      ftime.New(at.Add(time.Duration(i) * time.Minute)),
+		}
+
+		if err := oo.Create(context.Background(), o); err != nil {
+			t.Fatalf("can't create order %v", err)
+		}
+	}
+
+	return New(c, s, oo, products, geo.NewHashGeocoder(geo.MoscowBounds), nil, new(mockLogger))
+}
+
+func TestDispatchSkipsUnassignableOrders(t *testing.T) {
+	c := DefaultConfiguration
+	c.BatchSize = 1
+	c.MaxDistance = 1000
+
+	s := &mockStorage{loads: []*Load{load(1, courier.Car, nil, 0)}}
+	d := dispatcher(t, c, s, 2)
+
+	// первый проход упирается в заказ, который некому везти, второй продолжает очередь
+	for pass, want := range []int{0, 1} {
+		n, err := d.Dispatch(context.Background())
+		if err != nil {
+			t.Fatalf("Dispatch returned error %v", err)
+		}
+
+		if n != want {
+			t.Errorf("pass %v: Dispatch assigned %v orders, want %v", pass+1, n, want)
+		}
+	}
+
+	if len(s.assigned) != 1 || s.assigned[0].OrderID != 2 {
+		t.Errorf("Dispatch made assignments %v, want order 2 only", s.assigned)
+	}
+
+	// очередь закончилась, следующий проход начинается сначала
+	if n, _ := d.Dispatch(context.Background()); n != 0 {
+		t.Errorf("Dispatch assigned %v orders at the end of the queue, want 0", n)
+	}
+
+	if d.cursor != (order.Cursor{}) {
+		t.Errorf("Dispatch left cursor at %v, want start of the queue", d.cursor)
+	}
+}
+
+func TestDispatchMaxOrders(t *testing.T) {
+	c := DefaultConfiguration
+	c.MaxOrders = 2
+	c.MaxDistance = 1000
+
+	s := &mockStorage{loads: []*Load{load(1, courier.Car, nil, 1)}}
+	d := dispatcher(t, c, s, 4)
+
+	n, err := d.Dispatch(context.Background())
+	if err != nil {
+		t.Fatalf("Dispatch returned error %v", err)
+	}
+
+	if n != 1 {
+		t.Errorf("Dispatch assigned %v orders to courier with %v of %v orders, want 1", n, 1, c.MaxOrders)
+	}
+}
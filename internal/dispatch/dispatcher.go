@@ -0,0 +1,197 @@
+package dispatch
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/geo"
+	"safedeal-backend-trainee/internal/order"
+	"safedeal-backend-trainee/internal/product"
+	"safedeal-backend-trainee/internal/rating"
+	"safedeal-backend-trainee/pkg/log/logger"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Dispatcher периодически назначает подтвержденные заказы без курьера
+// курьерам с наибольшей оценкой
+type Dispatcher struct {
+	config   Configuration
+	scorer   *Scorer
+	storage  Storage
+	orders   order.Storage
+	products product.Storage
+	geocoder geo.Geocoder
+	ratings  *rating.Board
+	logger   logger.Logger
+	// cursor - заказ, на котором остановился предыдущий проход. Заказы, для которых нет курьера,
+	// не мешают следующим проходам дойти до остальных заказов очереди
+	cursor order.Cursor
+}
+
+// New создает диспетчера. Если ratings не задан, все курьеры считаются одинаково оцененными
+func New(c Configuration, s Storage, o order.Storage, p product.Storage, g geo.Geocoder,
+	ratings *rating.Board, l logger.Logger) *Dispatcher {
+	return &Dispatcher{
+		config:   c,
+		scorer:   NewScorer(c),
+		storage:  s,
+		orders:   o,
+		products: p,
+		geocoder: g,
+		ratings:  ratings,
+		logger:   l,
+	}
+}
+
+// Run распределяет заказы каждые Interval, пока не отменен ctx
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := d.Dispatch(ctx)
+			if err != nil {
+				d.logger.Errorf("dispatch failed: %v", err)
+			}
+
+			if n > 0 {
+				d.logger.Infof("dispatcher assigned %d orders", n)
+			}
+		}
+	}
+}
+
+// Dispatch делает один проход по следующим BatchSize заказам без курьера и возвращает число назначений.
+// Заказ, для которого нет подходящего курьера, остается до следующего прохода по очереди.
+// Dispatch не предназначен для одновременного вызова
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	orders, err := d.orders.FindUnassigned(ctx, d.cursor, d.config.BatchSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't find unassigned orders")
+	}
+
+	// дойдя до конца очереди, следующий проход начинается сначала
+	if len(orders) < d.config.BatchSize {
+		d.cursor = order.Cursor{}
+	} else {
+		last := orders[len(orders)-1]
+		d.cursor = order.Cursor{Time: last.Time.Time, ID: last.ID}
+	}
+
+	if len(orders) == 0 {
+		return 0, nil
+	}
+
+	scores, err := d.scores(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	loads := make(map[string][]*Load)
+	assigned := 0
+
+	for _, o := range orders {
+		if _, ok := loads[o.Zone]; !ok {
+			ll, err := d.storage.Couriers(ctx, o.Zone)
+			if err != nil {
+				return assigned, errors.Wrapf(err, "can't get couriers in zone %q", o.Zone)
+			}
+
+			for _, l := range ll {
+				l.Rating = NeutralRating
+				if score, ok := scores[l.Courier.ID]; ok {
+					l.Rating = score
+				}
+			}
+
+			loads[o.Zone] = ll
+		}
+
+		a, err := d.dispatch(ctx, o, loads[o.Zone])
+		if err != nil {
+			d.logger.Errorf("can't dispatch order with id= %v: %v", o.ID, err)
+			continue
+		}
+
+		if a != nil {
+			assigned++
+		}
+	}
+
+	return assigned, nil
+}
+
+func (d *Dispatcher) scores(ctx context.Context) (map[int64]float64, error) {
+	if d.ratings == nil {
+		return nil, nil
+	}
+
+	scores, err := d.ratings.Scores(ctx, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get courier ratings")
+	}
+
+	return scores, nil
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, o *order.Order, loads []*Load) (*Assignment, error) {
+	p, err := d.products.FindByID(ctx, o.ProductID)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't find product")
+	}
+
+	pickup, err := d.geocoder.Geocode(o.From)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't geocode pickup place")
+	}
+
+	history, err := d.storage.History(ctx, o.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get assignment history")
+	}
+
+	cc := d.scorer.Rank(pickup, p, loads, Excluded(history))
+	if len(cc) == 0 {
+		return nil, nil
+	}
+
+	best := cc[0]
+	a := &Assignment{
+		OrderID:   o.ID,
+		CourierID: best.Load.Courier.ID,
+		Action:    Assign,
+		Score:     best.Score,
+		Actor:     ActorDispatcher,
+	}
+
+	if err := d.storage.Assign(ctx, a); err != nil {
+		if err == ErrTaken {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "can't assign courier")
+	}
+
+	best.Load.Orders++
+	best.Load.Weight += float64(p.Weight)
+
+	return a, nil
+}
+
+// Excluded возвращает курьеров, которых уже снимали с заказа:
+// диспетчер не назначает их на этот заказ повторно
+func Excluded(history []*Assignment) map[int64]bool {
+	m := make(map[int64]bool)
+
+	for _, a := range history {
+		if a.Action == Unassign {
+			m[a.CourierID] = true
+		}
+	}
+
+	return m
+}
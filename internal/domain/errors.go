@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Виды ошибок предметной области. Хранилища и пакеты с бизнес-логикой возвращают
+// ошибки этих видов, а обработчики переводят их в коды ответа в одном месте
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid")
+	// ErrPrecondition - сущность изменилась с тех пор, как клиент ее прочитал
+	ErrPrecondition = errors.New("precondition failed")
+)
+
+// Error - ошибка одного из видов с сообщением, которое можно показать клиенту.
+// errors.Is(err, ErrNotFound) проверяет вид ошибки и для ошибок, обернутых errors.Wrap
+type Error struct {
+	kind error
+	msg  string
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+func NotFound(format string, args ...interface{}) error {
+	return &Error{kind: ErrNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...interface{}) error {
+	return &Error{kind: ErrConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+func Invalid(format string, args ...interface{}) error {
+	return &Error{kind: ErrInvalid, msg: fmt.Sprintf(format, args...)}
+}
+
+func Precondition(format string, args ...interface{}) error {
+	return &Error{kind: ErrPrecondition, msg: fmt.Sprintf(format, args...)}
+}
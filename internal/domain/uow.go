@@ -0,0 +1,24 @@
+package domain
+
+import "context"
+
+// UnitOfWork выполняет f в одной транзакции: изменения хранилищ, вызванных с контекстом,
+// который получила f, фиксируются вместе. Если f вернула ошибку, изменения откатываются.
+// f может выполняться несколько раз, поэтому в ней не должно быть других побочных эффектов
+type UnitOfWork interface {
+	Do(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+type unitOfWorkKey struct{}
+
+// WithUnitOfWork отмечает контекст, который UnitOfWork передает в f
+func WithUnitOfWork(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unitOfWorkKey{}, true)
+}
+
+// InUnitOfWork сообщает, выполняется ли ctx в транзакции UnitOfWork. Кэши в ней читают
+// из хранилища, иначе транзакция не увидит и не проверит актуальные данные
+func InUnitOfWork(ctx context.Context) bool {
+	in, _ := ctx.Value(unitOfWorkKey{}).(bool)
+	return in
+}
@@ -0,0 +1,168 @@
+package earnings
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"math"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"strconv"
+	"time"
+)
+
+type Configuration struct {
+	// Base - оплата за каждую доставку
+	Base int `json:"base"`
+	// PerKm - оплата за километр от места забора до адреса доставки
+	PerKm float64 `json:"per_km"`
+	// FreeWaiting - сколько курьер ждет покупателя бесплатно
+	FreeWaiting ftime.Duration `json:"free_waiting"`
+	// WaitingPerMinute - доплата за каждую минуту ожидания сверх FreeWaiting
+	WaitingPerMinute float64 `json:"waiting_per_minute"`
+}
+
+var DefaultConfiguration = Configuration{
+	Base:             150,
+	PerKm:            15,
+	FreeWaiting:      ftime.Duration{Duration: 10 * time.Minute},
+	WaitingPerMinute: 5,
+}
+
+// Earning - оплата курьеру за одну доставку. Она рассчитывается в момент доставки
+// и не меняется при изменении правил
+type Earning struct {
+	OrderID      int64          `json:"order_id"`
+	CourierID    int64          `json:"courier_id"`
+	DeliveredAt  time.Time      `json:"delivered_at"`
+	Distance     float64        `json:"distance"`
+	Waiting      ftime.Duration `json:"waiting"`
+	Base         int            `json:"base"`
+	DistanceFee  int            `json:"distance_fee"`
+	WaitingBonus int            `json:"waiting_bonus"`
+	Total        int            `json:"total"`
+}
+
+var ErrNotDeliverable = domain.Conflict("order is not assigned to the courier")
+
+type Storage interface {
+	// Arrive отмечает, что курьер приехал по адресу доставки заказа версии version
+	Arrive(ctx context.Context, orderID int64, courierID int64, version int64, at time.Time) error
+	// Deliver атомарно отмечает заказ версии version доставленным и сохраняет оплату курьеру.
+	// Если заказ не назначен курьеру или уже доставлен, возвращается ErrNotDeliverable,
+	// если версия заказа изменилась - order.ErrStale
+	Deliver(ctx context.Context, e *Earning, version int64) error
+	// Find возвращает оплату курьеру за доставки в интервале [from, to) по времени доставки
+	Find(ctx context.Context, courierID int64, from time.Time, to time.Time) ([]*Earning, error)
+}
+
+type Rules struct {
+	config Configuration
+}
+
+func New(c Configuration) *Rules {
+	return &Rules{config: c}
+}
+
+// Pay рассчитывает оплату за доставку на distance км с ожиданием waiting
+func (r *Rules) Pay(distance float64, waiting time.Duration) *Earning {
+	e := &Earning{
+		Distance:    math.Round(distance*100) / 100, // nolint: gomnd
+		Waiting:     ftime.Duration{Duration: waiting},
+		Base:        r.config.Base,
+		DistanceFee: int(math.Round(distance * r.config.PerKm)),
+	}
+
+	if extra := waiting - r.config.FreeWaiting.Duration; extra > 0 {
+		e.WaitingBonus = int(math.Round(extra.Minutes() * r.config.WaitingPerMinute))
+	}
+
+	e.Total = e.Base + e.DistanceFee + e.WaitingBonus
+
+	return e
+}
+
+// Day - итог за день по календарю города курьера
+type Day struct {
+	Date         string  `json:"date"`
+	Deliveries   int     `json:"deliveries"`
+	Distance     float64 `json:"distance"`
+	Base         int     `json:"base"`
+	DistanceFee  int     `json:"distance_fee"`
+	WaitingBonus int     `json:"waiting_bonus"`
+	Total        int     `json:"total"`
+}
+
+const dateLayout = "2006-01-02"
+
+// Daily суммирует оплату по дням доставки в часовом поясе loc. Дни без доставок пропускаются
+func Daily(ee []*Earning, loc *time.Location) []*Day {
+	days := make([]*Day, 0)
+	index := make(map[string]*Day)
+
+	for _, e := range ee {
+		date := e.DeliveredAt.In(loc).Format(dateLayout)
+
+		d, ok := index[date]
+		if !ok {
+			d = &Day{Date: date}
+			index[date] = d
+			days = append(days, d)
+		}
+
+		d.Deliveries++
+		d.Distance = math.Round((d.Distance+e.Distance)*100) / 100 // nolint: gomnd
+		d.Base += e.Base
+		d.DistanceFee += e.DistanceFee
+		d.WaitingBonus += e.WaitingBonus
+		d.Total += e.Total
+	}
+
+	return days
+}
+
+// WriteStatement выгружает выписку в CSV: строка на каждую доставку и строка с суммами
+func WriteStatement(w io.Writer, ee []*Earning, loc *time.Location) error {
+	out := csv.NewWriter(w)
+
+	header := []string{"order_id", "delivered_at", "distance_km", "waiting_min",
+		"base", "distance_fee", "waiting_bonus", "total"}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	var total Day
+
+	for _, e := range ee {
+		total.Distance += e.Distance
+		total.Base += e.Base
+		total.DistanceFee += e.DistanceFee
+		total.WaitingBonus += e.WaitingBonus
+		total.Total += e.Total
+
+		row := []string{
+			strconv.FormatInt(e.OrderID, 10),
+			e.DeliveredAt.In(loc).Format(time.RFC3339),
+			strconv.FormatFloat(e.Distance, 'f', 2, 64),
+			strconv.Itoa(int(e.Waiting.Minutes())),
+			strconv.Itoa(e.Base),
+			strconv.Itoa(e.DistanceFee),
+			strconv.Itoa(e.WaitingBonus),
+			strconv.Itoa(e.Total),
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	footer := []string{"total", "", strconv.FormatFloat(total.Distance, 'f', 2, 64), "",
+		strconv.Itoa(total.Base), strconv.Itoa(total.DistanceFee), strconv.Itoa(total.WaitingBonus),
+		strconv.Itoa(total.Total)}
+	if err := out.Write(footer); err != nil {
+		return err
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
@@ -1,25 +1,34 @@
 package ehttp
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/validation"
 )
 
+// HTTPError - ошибка, которую обработчик возвращает клиенту. Msg показывается клиенту в поле detail,
+// Detail пишется только в лог. Code - код ошибки из Catalogue, Violations - нарушения в полях запроса
 type HTTPError struct {
-	Msg        string `json:"error,omitempty"`
-	StatusCode int    `json:"-"`
-	Detail     string `json:"-"`
+	Msg        string
+	StatusCode int
+	Detail     string
+	Code       Code
+	Violations validation.Errors
 }
 
 func (h HTTPError) Error() string {
 	return fmt.Sprintf("message: %v; status code: %v; detail: %v", h.Msg, h.StatusCode, h.Detail)
 }
 
+// New создает ошибку с кодом по умолчанию для статуса status
 func New(msg string, status int, detail string) error {
 	return HTTPError{
 		Msg:        msg,
 		StatusCode: status,
 		Detail:     detail,
+		Code:       codeForStatus(status),
 	}
 }
 
@@ -30,22 +39,28 @@ func IncorrectID(id int64) error {
 		Msg:        msg,
 		StatusCode: http.StatusBadRequest,
 		Detail:     msg,
+		Code:       CodeInvalidID,
 	}
 }
 
 func JSONUnmarshalErr(err error) error {
+	msg := fmt.Sprintf("can't unmarshal input json: %v", err)
+
 	return HTTPError{
-		Msg:        "",
+		Msg:        msg,
 		StatusCode: http.StatusBadRequest,
-		Detail:     fmt.Sprintf("can't unmarshal input json: %v", err),
+		Detail:     msg,
+		Code:       CodeMalformedJSON,
 	}
 }
 
+// InternalServerErr не показывает клиенту detail, в нем могут быть подробности устройства сервиса
 func InternalServerErr(detail string) error {
 	return HTTPError{
 		Msg:        "",
 		StatusCode: http.StatusInternalServerError,
 		Detail:     detail,
+		Code:       CodeInternal,
 	}
 }
 
@@ -54,5 +69,128 @@ func NotFoundErr(msg string, detail string) error {
 		Msg:        msg,
 		StatusCode: http.StatusNotFound,
 		Detail:     detail,
+		Code:       CodeNotFound,
+	}
+}
+
+// FeatureDisabledErr - ответ на запрос к возможности, которая не включена в настройках сервиса
+func FeatureDisabledErr(msg string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusNotFound,
+		Detail:     msg,
+		Code:       CodeFeatureDisabled,
+	}
+}
+
+func UnprocessableEntityErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusUnprocessableEntity,
+		Detail:     detail,
+		Code:       CodeUnprocessable,
+	}
+}
+
+func UnauthorizedErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusUnauthorized,
+		Detail:     detail,
+		Code:       CodeUnauthorized,
+	}
+}
+
+func ForbiddenErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusForbidden,
+		Detail:     detail,
+		Code:       CodeForbidden,
+	}
+}
+
+func BadRequestErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusBadRequest,
+		Detail:     detail,
+		Code:       CodeBadRequest,
+	}
+}
+
+func ConflictErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusConflict,
+		Detail:     detail,
+		Code:       CodeConflict,
 	}
 }
+
+func PreconditionRequiredErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusPreconditionRequired,
+		Detail:     detail,
+		Code:       CodePreconditionRequired,
+	}
+}
+
+func TooManyRequestsErr(msg string, detail string) error {
+	return HTTPError{
+		Msg:        msg,
+		StatusCode: http.StatusTooManyRequests,
+		Detail:     detail,
+		Code:       CodeRateLimited,
+	}
+}
+
+// domainCodes - коды ошибок для видов ошибок предметной области
+var domainCodes = []struct {
+	kind error
+	code Code
+}{
+	{domain.ErrNotFound, CodeNotFound},
+	{domain.ErrConflict, CodeConflict},
+	{domain.ErrInvalid, CodeUnprocessable},
+	{domain.ErrPrecondition, CodePreconditionFailed},
+}
+
+// FromError переводит ошибку в HTTPError. Ошибка предметной области получает код своего вида,
+// клиенту возвращается ее сообщение, а в лог пишется вся цепочка. Нарушения из validation.Errors
+// возвращаются клиенту списком. Остальные ошибки - внутренние
+func FromError(err error) HTTPError {
+	var e HTTPError
+	if errors.As(err, &e) {
+		if e.Code == "" {
+			e.Code = codeForStatus(e.StatusCode)
+		}
+
+		return e
+	}
+
+	var ve validation.Errors
+	if errors.As(err, &ve) {
+		msg := "request has invalid fields"
+
+		return HTTPError{
+			Msg:        msg,
+			StatusCode: http.StatusUnprocessableEntity,
+			Detail:     fmt.Sprintf("%s: %v", msg, ve),
+			Code:       CodeValidationFailed,
+			Violations: ve,
+		}
+	}
+
+	var de *domain.Error
+	if errors.As(err, &de) {
+		for _, c := range domainCodes {
+			if errors.Is(de, c.kind) {
+				return HTTPError{Msg: de.Error(), StatusCode: c.code.Status(), Detail: err.Error(), Code: c.code}
+			}
+		}
+	}
+
+	return HTTPError{StatusCode: http.StatusInternalServerError, Detail: err.Error(), Code: CodeInternal}
+}
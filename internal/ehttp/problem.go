@@ -0,0 +1,150 @@
+package ehttp
+
+import (
+	"net/http"
+	"safedeal-backend-trainee/internal/validation"
+)
+
+// ProblemContentType - тип ответа с ошибкой по RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// TypeBase - начало URI в поле type. По этому адресу сервис отдает описание кода из Catalogue
+const TypeBase = "/api/v1/problems/"
+
+// Code - код ошибки, по которому клиент выбирает, как ее обработать. В отличие от текста ошибки
+// коды не меняются, новые коды добавляются в конец Catalogue
+type Code string
+
+const (
+	CodeBadRequest           Code = "bad_request"
+	CodeInvalidID            Code = "invalid_id"
+	CodeMalformedJSON        Code = "malformed_json"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeFeatureDisabled      Code = "feature_disabled"
+	CodeRouteNotFound        Code = "route_not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeConflict             Code = "conflict"
+	CodePreconditionFailed   Code = "precondition_failed"
+	CodeUnprocessable        Code = "unprocessable"
+	CodePreconditionRequired Code = "precondition_required"
+	CodeRateLimited          Code = "rate_limited"
+	CodeInternal             Code = "internal_error"
+	CodeValidationFailed     Code = "validation_failed"
+)
+
+// CatalogueEntry описывает код ошибки. Title совпадает с полем title всех ответов с этим кодом
+type CatalogueEntry struct {
+	Code        Code   `json:"code"`
+	Type        string `json:"type"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Catalogue - все коды ошибок, которые возвращает сервис
+var Catalogue = []CatalogueEntry{
+	entry(CodeBadRequest, http.StatusBadRequest, "Bad request",
+		"Request headers or parameters are malformed, detail names the wrong one."),
+	entry(CodeInvalidID, http.StatusBadRequest, "Invalid id",
+		"Identifier in the request path is not a positive integer."),
+	entry(CodeMalformedJSON, http.StatusBadRequest, "Malformed JSON",
+		"Request body is not valid JSON or does not match the expected structure."),
+	entry(CodeUnauthorized, http.StatusUnauthorized, "Unauthorized",
+		"API key is required for the resource or the key is unknown or revoked."),
+	entry(CodeForbidden, http.StatusForbidden, "Forbidden",
+		"Role of the API key has no access to the resource or the entity belongs to someone else."),
+	entry(CodeNotFound, http.StatusNotFound, "Not found",
+		"Requested entity does not exist."),
+	entry(CodeFeatureDisabled, http.StatusNotFound, "Feature disabled",
+		"Feature is not enabled in the service configuration."),
+	entry(CodeRouteNotFound, http.StatusNotFound, "Route not found",
+		"No resource at the requested path."),
+	entry(CodeMethodNotAllowed, http.StatusMethodNotAllowed, "Method not allowed",
+		"Resource does not support the request method."),
+	entry(CodeConflict, http.StatusConflict, "Conflict",
+		"Request conflicts with the current state, e.g. delivery slot is full or courier is already on shift."),
+	entry(CodePreconditionFailed, http.StatusPreconditionFailed, "Precondition failed",
+		"Entity has changed since the version in If-Match was read, get it again and retry."),
+	entry(CodeUnprocessable, http.StatusUnprocessableEntity, "Unprocessable entity",
+		"Request is well-formed but can't be applied, e.g. promo code or delivery time is not acceptable."),
+	entry(CodePreconditionRequired, http.StatusPreconditionRequired, "Precondition required",
+		"Change requires If-Match header with the entity ETag."),
+	entry(CodeRateLimited, http.StatusTooManyRequests, "Too many requests",
+		"Rate limit is exceeded, retry after the number of seconds in Retry-After header."),
+	entry(CodeInternal, http.StatusInternalServerError, "Internal server error",
+		"Unexpected error, report request_id to the service maintainers."),
+	entry(CodeValidationFailed, http.StatusUnprocessableEntity, "Validation failed",
+		"Request fields break validation rules, violations lists field, rule and message for each of them."),
+}
+
+func entry(c Code, status int, title string, description string) CatalogueEntry {
+	return CatalogueEntry{Code: c, Type: TypeBase + string(c), Status: status, Title: title, Description: description}
+}
+
+// Lookup возвращает описание кода c из Catalogue
+func Lookup(c Code) (CatalogueEntry, bool) {
+	for _, e := range Catalogue {
+		if e.Code == c {
+			return e, true
+		}
+	}
+
+	return CatalogueEntry{}, false
+}
+
+// Status возвращает статус ответа с кодом c
+func (c Code) Status() int {
+	e, ok := Lookup(c)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	return e.Status
+}
+
+// codeForStatus возвращает первый код в Catalogue со статусом status
+func codeForStatus(status int) Code {
+	for _, e := range Catalogue {
+		if e.Status == status {
+			return e.Code
+		}
+	}
+
+	if status < http.StatusInternalServerError {
+		return CodeBadRequest
+	}
+
+	return CodeInternal
+}
+
+// Problem - тело ответа с ошибкой по RFC 7807. RequestID совпадает с заголовком X-Request-Id ответа,
+// Violations есть только у ошибок с кодом CodeValidationFailed
+type Problem struct {
+	Type       string            `json:"type"`
+	Title      string            `json:"title"`
+	Status     int               `json:"status"`
+	Detail     string            `json:"detail,omitempty"`
+	Code       Code              `json:"code"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Violations validation.Errors `json:"violations,omitempty"`
+}
+
+// Problem возвращает тело ответа с ошибкой на запрос requestID
+func (h HTTPError) Problem(requestID string) Problem {
+	e, ok := Lookup(h.Code)
+	if !ok {
+		e, _ = Lookup(codeForStatus(h.StatusCode))
+	}
+
+	return Problem{
+		Type:       e.Type,
+		Title:      e.Title,
+		Status:     h.StatusCode,
+		Detail:     h.Msg,
+		Code:       e.Code,
+		RequestID:  requestID,
+		Violations: h.Violations,
+	}
+}
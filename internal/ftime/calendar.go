@@ -0,0 +1,241 @@
+package ftime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DateLayout = "2006-01-02"
+
+type CalendarConfiguration struct {
+	TimeZone string `json:"time_zone"`
+	// Opens, Closes - рабочие часы доставки
+	Opens  Clock `json:"opens"`
+	Closes Clock `json:"closes"`
+	// DaysOff - выходные дни недели ("saturday", "sunday", ...)
+	DaysOff []string `json:"days_off"`
+	// Holidays - путь к JSON файлу с праздничными днями, относительный путь в configuration.json
+	// отсчитывается от каталога этого файла
+	Holidays string `json:"holidays"`
+}
+
+var DefaultCalendarConfiguration = CalendarConfiguration{
+	TimeZone: "UTC",
+	Opens:    NewClock(9, 0),
+	Closes:   NewClock(21, 0),
+}
+
+// AlwaysOpen возвращает календарь без выходных, праздников и ограничения рабочих часов
+func AlwaysOpen() *Calendar {
+	return &Calendar{
+		location: time.UTC,
+		opens:    NewClock(0, 0),
+		closes:   NewClock(24, 0), // nolint: gomnd
+		daysOff:  make(map[time.Weekday]bool),
+		holidays: make(map[string]string),
+	}
+}
+
+type Holiday struct {
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+// Calendar - рабочий календарь города: часовой пояс, рабочие часы, выходные и праздники
+type Calendar struct {
+	location *time.Location
+	opens    Clock
+	closes   Clock
+	daysOff  map[time.Weekday]bool
+	holidays map[string]string
+}
+
+func NewCalendar(c CalendarConfiguration) (*Calendar, error) {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load time zone %q", c.TimeZone)
+	}
+
+	cal := &Calendar{
+		location: loc,
+		opens:    c.Opens,
+		closes:   c.Closes,
+		daysOff:  make(map[time.Weekday]bool),
+		holidays: make(map[string]string),
+	}
+
+	for _, d := range c.DaysOff {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return nil, errors.Errorf("unknown day of week %q", d)
+		}
+
+		cal.daysOff[wd] = true
+	}
+
+	if c.Holidays != "" {
+		if err := cal.loadHolidays(c.Holidays); err != nil {
+			return nil, err
+		}
+	}
+
+	return cal, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func (c *Calendar) loadHolidays(filename string) error {
+	byteData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, "unable to read holidays file: "+filename)
+	}
+
+	var hh []Holiday
+
+	if err := json.Unmarshal(byteData, &hh); err != nil {
+		return errors.Wrap(err, "can't unmarshal json with holidays")
+	}
+
+	for _, h := range hh {
+		if _, err := time.Parse(DateLayout, h.Date); err != nil {
+			return errors.Wrapf(err, "incorrect date of holiday %q", h.Name)
+		}
+
+		c.holidays[h.Date] = h.Name
+	}
+
+	return nil
+}
+
+func (c *Calendar) Location() *time.Location {
+	return c.location
+}
+
+// In возвращает t в часовом поясе календаря
+func (c *Calendar) In(t time.Time) time.Time {
+	return t.In(c.location)
+}
+
+// WorkingHours возвращает начало и конец рабочего времени в день day.
+// Для выходных и праздников ok равен false
+func (c *Calendar) WorkingHours(day time.Time) (time.Time, time.Time, bool) {
+	day = c.In(day)
+
+	if c.closed(day) != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return c.opens.On(day), c.closes.On(day), true
+}
+
+// Check проверяет, что в момент t доставка работает, и объясняет причину, если нет
+func (c *Calendar) Check(t time.Time) error {
+	t = c.In(t)
+
+	if err := c.closed(t); err != nil {
+		return err
+	}
+
+	opens, closes := c.opens.On(t), c.closes.On(t)
+	if t.Before(opens) || !t.Before(closes) {
+		return errors.Errorf("delivery time %v is outside of business hours %v-%v",
+			Of(t), c.opens, c.closes)
+	}
+
+	return nil
+}
+
+func (c *Calendar) closed(day time.Time) error {
+	date := day.Format(DateLayout)
+
+	if name, ok := c.holidays[date]; ok {
+		return errors.Errorf("delivery is not available on %v: public holiday %q", date, name)
+	}
+
+	if c.daysOff[day.Weekday()] {
+		return errors.Errorf("delivery is not available on %v: day off (%v)", date, strings.ToLower(day.Weekday().String()))
+	}
+
+	return nil
+}
+
+// NextOpen возвращает ближайший к t момент рабочего времени (не раньше t)
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	t = c.In(t)
+
+	const maxDays = 366
+
+	for d := 0; d < maxDays; d++ {
+		day := t.AddDate(0, 0, d)
+
+		opens, closes, ok := c.WorkingHours(day)
+		if !ok || !t.Before(closes) {
+			continue
+		}
+
+		if t.Before(opens) {
+			return opens
+		}
+
+		return t
+	}
+
+	return t
+}
+
+// Calendars - календари городов
+type Calendars struct {
+	cities map[string]*Calendar
+	def    *Calendar
+}
+
+func NewCalendars(def *Calendar, cities map[string]*Calendar) *Calendars {
+	return &Calendars{cities: cities, def: def}
+}
+
+// LoadCalendars создает календари городов, календарем по умолчанию становится календарь города def
+func LoadCalendars(cc map[string]CalendarConfiguration, def string) (*Calendars, error) {
+	cities := make(map[string]*Calendar, len(cc))
+
+	for city, c := range cc {
+		cal, err := NewCalendar(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't create calendar for city %q", city)
+		}
+
+		cities[city] = cal
+	}
+
+	d, ok := cities[def]
+	if !ok {
+		cal, err := NewCalendar(DefaultCalendarConfiguration)
+		if err != nil {
+			return nil, err
+		}
+
+		d = cal
+	}
+
+	return NewCalendars(d, cities), nil
+}
+
+// For возвращает календарь города или календарь по умолчанию
+func (cc *Calendars) For(city string) *Calendar {
+	if c, ok := cc.cities[city]; ok {
+		return c
+	}
+
+	return cc.def
+}
@@ -0,0 +1,62 @@
+package ftime
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const minutesInDay = 24 * 60
+
+// Clock - время суток с точностью до минуты, в JSON записывается строкой вида "09:30"
+type Clock struct {
+	Minutes int
+}
+
+func NewClock(hour int, min int) Clock {
+	return Clock{Minutes: hour*60 + min} // nolint: gomnd
+}
+
+// On возвращает момент времени в день date (в часовом поясе date)
+func (c Clock) On(date time.Time) time.Time {
+	y, m, d := date.Date()
+	return time.Date(y, m, d, 0, c.Minutes, 0, 0, date.Location())
+}
+
+// Of возвращает время суток момента t
+func Of(t time.Time) Clock {
+	return NewClock(t.Hour(), t.Minute())
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Minutes/60, c.Minutes%60) // nolint: gomnd
+}
+
+func (c Clock) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *Clock) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	var hour, min int
+
+	_, err := fmt.Sscanf(s, "%d:%d", &hour, &min)
+	if err != nil {
+		return errors.Wrapf(err, "can't parse clock %q", s)
+	}
+
+	*c = NewClock(hour, min)
+
+	// 24:00 допустимо как конец рабочего дня
+	if hour < 0 || min < 0 || min >= 60 || c.Minutes > minutesInDay {
+		return errors.Errorf("clock %q is out of range", s)
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package ftime
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration - time.Duration, который в JSON записывается строкой вида "1h30m"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+
+	return nil
+}
@@ -3,11 +3,13 @@ package ftime
 import (
 	"database/sql"
 	"database/sql/driver"
-	"fmt"
-	"reflect"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// FormatTime - время в формате RFC 3339. Смещение часового пояса,
+// с которым время было передано, сохраняется и возвращается без изменений
 type FormatTime struct {
 	time.Time
 }
@@ -19,34 +21,27 @@ func New(t time.Time) *FormatTime {
 }
 
 func (f FormatTime) MarshalJSON() ([]byte, error) {
-	t := f.Time.UTC()
-	s := t.Format(time.RFC3339)
-
-	t, err := time.Parse(Layout, s)
-	if err != nil {
-		return nil, err
-	}
-
-	s = fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02dZ", t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second())
-
-	return []byte(`"` + s + `"`), nil
+	return []byte(`"` + f.Time.Format(time.RFC3339Nano) + `"`), nil
 }
 
+// UnmarshalJSON принимает время в формате RFC 3339 со смещением часового пояса
+// и необязательными долями секунды (например, "2020-06-15T15:30:00.5+03:00")
 func (f *FormatTime) UnmarshalJSON(b []byte) error {
 	s := string(b)
+	if s == "null" {
+		return nil
+	}
 
-	t, err := time.Parse(`"`+Layout+`"`, s)
-	if err != nil {
-		return err
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return errors.Errorf("time %s must be a string in RFC 3339 format", s)
 	}
 
-	loc, err := time.LoadLocation("Local")
+	t, err := time.Parse(time.RFC3339Nano, s[1:len(s)-1])
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "time %s must be in RFC 3339 format", s)
 	}
 
-	f.Time = t.In(loc)
+	f.Time = t
 
 	return nil
 }
@@ -57,58 +52,13 @@ func (f *FormatTime) Scan(value interface{}) error {
 		return err
 	}
 
-	if reflect.TypeOf(value) != nil {
-		t := t.Time.UTC()
-		s := t.Format(time.RFC3339)
-
-		t, err := time.Parse(Layout, s)
-		if err != nil {
-			return err
-		}
-
-		loc, err := time.LoadLocation("Local")
-		if err != nil {
-			return err
-		}
-
-		f.Time = t.In(loc)
+	if t.Valid {
+		f.Time = t.Time
 	}
 
 	return nil
 }
 
-//func (f *FormatTime) Scan(value interface{}) error {
-//	var t sql.NullTime
-//	if err := t.Scan(value); err != nil {
-//		return err
-//	}
-//
-//	if reflect.TypeOf(value) == nil {
-//		return nil
-//	} else {
-//		t := t.Time.UTC()
-//		s := t.Format(time.RFC3339)
-//		t, err := time.Parse(Layout, s)
-//		if err != nil {
-//			return err
-//		}
-//
-//		loc, err := time.LoadLocation("Local")
-//		if err != nil {
-//			return err
-//		}
-//
-//		f.Time = t.In(loc)
-//	}
-//
-//	return nil
-//}
-
 func (f FormatTime) Value() (driver.Value, error) {
-	loc, err := time.LoadLocation("Local")
-	if err != nil {
-		return nil, err
-	}
-
-	return f.Time.In(loc), nil
+	return f.Time, nil
 }
@@ -0,0 +1,67 @@
+package geo
+
+import (
+	"math"
+	"time"
+)
+
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type Bounds struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+func (b Bounds) Contains(p Point) bool {
+	return p.Lat >= b.MinLat && p.Lat <= b.MaxLat && p.Lon >= b.MinLon && p.Lon <= b.MaxLon
+}
+
+// MoscowBounds покрывает Москву в пределах МКАД
+var MoscowBounds = Bounds{MinLat: 55.57, MinLon: 37.37, MaxLat: 55.91, MaxLon: 37.84}
+
+const earthRadius = 6371.0 // km
+
+// Distance возвращает расстояние между точками в километрах (по формуле гаверсинусов)
+func Distance(a, b Point) float64 {
+	lat1, lat2 := toRadians(a.Lat), toRadians(b.Lat)
+	dLat := lat2 - lat1
+	dLon := toRadians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180 // nolint: gomnd
+}
+
+type Geocoder interface {
+	Geocode(address string) (Point, error)
+}
+
+// TravelTimes возвращает матрицу времени в пути между всеми парами точек
+// при средней скорости speed км/ч
+func TravelTimes(points []Point, speed float64) [][]time.Duration {
+	m := make([][]time.Duration, len(points))
+
+	for i := range points {
+		m[i] = make([]time.Duration, len(points))
+
+		for j := range points {
+			if i == j || speed <= 0 {
+				continue
+			}
+
+			hours := Distance(points[i], points[j]) / speed
+			m[i][j] = time.Duration(hours * float64(time.Hour))
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,40 @@
+package geo
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var _ Geocoder = &HashGeocoder{}
+
+// HashGeocoder - заглушка геокодера: адрес детерминированно отображается
+// в точку внутри заданных границ. Одинаковые адреса всегда дают одну и ту же точку.
+type HashGeocoder struct {
+	bounds Bounds
+}
+
+func NewHashGeocoder(b Bounds) *HashGeocoder {
+	return &HashGeocoder{bounds: b}
+}
+
+func (g *HashGeocoder) Geocode(address string) (Point, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return Point{}, errors.New("can't geocode empty address")
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(address))
+	sum := h.Sum64()
+
+	lat := float64(sum>>32) / math.MaxUint32
+	lon := float64(sum&math.MaxUint32) / math.MaxUint32
+
+	return Point{
+		Lat: g.bounds.MinLat + lat*(g.bounds.MaxLat-g.bounds.MinLat),
+		Lon: g.bounds.MinLon + lon*(g.bounds.MaxLon-g.bounds.MinLon),
+	}, nil
+}
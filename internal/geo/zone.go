@@ -0,0 +1,41 @@
+package geo
+
+// DefaultZone - зона для точек, не попавших ни в одну из настроенных
+const DefaultZone = "default"
+
+type Zone struct {
+	Name   string `json:"name"`
+	City   string `json:"city"`
+	Bounds Bounds `json:"bounds"`
+}
+
+type Zones []Zone
+
+// Locate возвращает имя первой зоны, содержащей точку
+func (zz Zones) Locate(p Point) string {
+	for _, z := range zz {
+		if z.Bounds.Contains(p) {
+			return z.Name
+		}
+	}
+
+	return DefaultZone
+}
+
+// City возвращает город зоны, для неизвестной зоны - пустую строку
+func (zz Zones) City(zone string) string {
+	for _, z := range zz {
+		if z.Name == zone {
+			return z.City
+		}
+	}
+
+	return ""
+}
+
+type Configuration struct {
+	Bounds Bounds `json:"bounds"`
+	Zones  Zones  `json:"zones"`
+}
+
+var DefaultConfiguration = Configuration{Bounds: MoscowBounds}
@@ -0,0 +1,164 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/pkg/log/logger"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxKey - наибольшая длина ключа идемпотентности
+const MaxKey = 255
+
+type Configuration struct {
+	// TTL - сколько хранится ответ на запрос с ключом. После этого ключ можно использовать заново
+	TTL ftime.Duration `json:"ttl"`
+	// Lease - сколько ключ занят выполняющимся запросом. Запрос, не сохранивший ответ за это время,
+	// считается брошенным, и ключ можно занять заново. Lease должен быть больше таймаута запроса
+	Lease ftime.Duration `json:"lease"`
+	// PurgeInterval - как часто удаляются устаревшие ключи
+	PurgeInterval ftime.Duration `json:"purge_interval"`
+}
+
+var DefaultConfiguration = Configuration{
+	TTL:           ftime.Duration{Duration: 24 * time.Hour},
+	Lease:         ftime.Duration{Duration: 2 * time.Minute},
+	PurgeInterval: ftime.Duration{Duration: time.Hour},
+}
+
+// Validate проверяет, что сроки хранения и интервал удаления ключей положительные
+func (c Configuration) Validate() error {
+	if c.TTL.Duration <= 0 || c.Lease.Duration <= 0 || c.PurgeInterval.Duration <= 0 {
+		return errors.New("idempotency ttl, lease and purge interval must be positive")
+	}
+
+	return nil
+}
+
+// Response - сохраненный ответ на запрос, который повторяется для запросов с тем же ключом
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Record - запрос с ключом идемпотентности. Response пустой, пока запрос выполняется
+type Record struct {
+	Key         string
+	Fingerprint string
+	CreatedAt   time.Time
+	Response    *Response
+}
+
+var (
+	// ErrMismatch - ключ уже использован для запроса с другим телом
+	ErrMismatch = domain.Invalid("idempotency key is already used with another request")
+	// ErrInProgress - запрос с этим ключом еще выполняется
+	ErrInProgress = domain.Conflict("request with this idempotency key is in progress")
+	// ErrLeaseLost - запрос выполнялся дольше Lease, и ключ занял другой запрос
+	ErrLeaseLost = domain.Conflict("idempotency key is taken by another request")
+)
+
+type Storage interface {
+	// Start сохраняет запись, если ключа нет, его запись создана раньше expired или запись
+	// без ответа создана раньше abandoned, и возвращает nil. Иначе возвращает существующую запись
+	Start(ctx context.Context, r *Record, expired time.Time, abandoned time.Time) (*Record, error)
+	// Finish сохраняет ответ на запрос r, если ключ все еще занят им, иначе возвращает ErrLeaseLost.
+	// Запрос определяется ключом, отпечатком и временем создания записи
+	Finish(ctx context.Context, r *Record, resp *Response) error
+	// Delete удаляет ключ, занятый запросом r, чтобы запрос можно было повторить.
+	// Если ключ занят другим запросом, возвращает ErrLeaseLost
+	Delete(ctx context.Context, r *Record) error
+	// Purge удаляет записи, созданные раньше expired, и возвращает их число
+	Purge(ctx context.Context, expired time.Time) (int64, error)
+}
+
+type Keeper struct {
+	config  Configuration
+	storage Storage
+	now     func() time.Time
+}
+
+func New(c Configuration, s Storage) *Keeper {
+	return &Keeper{config: c, storage: s, now: time.Now}
+}
+
+// Scoped возвращает ключ идемпотентности клиента scope, чтобы одинаковые ключи
+// разных клиентов не пересекались
+func Scoped(scope string, key string) string {
+	return scope + " " + key
+}
+
+// Fingerprint возвращает отпечаток запроса, по которому повтор отличается от другого запроса с тем же ключом
+func Fingerprint(method string, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin занимает ключ для запроса с отпечатком fingerprint и возвращает запись без ответа,
+// которую нужно передать в Finish. Если запрос с этим ключом уже выполнен, возвращает его запись с ответом
+func (k *Keeper) Begin(ctx context.Context, key string, fingerprint string) (*Record, error) {
+	// запись находится по времени создания, а БД хранит его с точностью до микросекунд
+	now := k.now().Truncate(time.Microsecond)
+	lease := &Record{Key: key, Fingerprint: fingerprint, CreatedAt: now}
+
+	r, err := k.storage.Start(ctx, lease, k.expired(now), now.Add(-k.config.Lease.Duration))
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return lease, nil
+	}
+
+	if r.Fingerprint != fingerprint {
+		return nil, ErrMismatch
+	}
+
+	if r.Response == nil {
+		return nil, ErrInProgress
+	}
+
+	return r, nil
+}
+
+// Finish сохраняет ответ на запрос lease для повторов. Ответ с ошибкой сервера не сохраняется,
+// ключ освобождается, и запрос можно повторить. Если запрос выполнялся дольше Lease и ключ
+// занял другой запрос, его запись не меняется, и возвращается ErrLeaseLost
+func (k *Keeper) Finish(ctx context.Context, lease *Record, resp *Response) error {
+	if resp.Status >= http.StatusInternalServerError {
+		return k.storage.Delete(ctx, lease)
+	}
+
+	return k.storage.Finish(ctx, lease, resp)
+}
+
+// Run удаляет устаревшие ключи раз в PurgeInterval, пока не отменен ctx
+func (k *Keeper) Run(ctx context.Context, l logger.Logger) {
+	ticker := time.NewTicker(k.config.PurgeInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := k.storage.Purge(ctx, k.expired(k.now())); err != nil {
+				l.Errorf("can't purge idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+func (k *Keeper) expired(now time.Time) time.Time {
+	return now.Add(-k.config.TTL.Duration)
+}
@@ -0,0 +1,19 @@
+package kv
+
+import (
+	"context"
+	"time"
+)
+
+// Store - хранилище счетчиков и значений, общее для экземпляров сервиса.
+// Нулевой ttl означает, что ключ хранится без ограничения времени
+type Store interface {
+	// Incr увеличивает счетчик key на 1 и возвращает новое значение. Новый счетчик удаляется через ttl
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Get возвращает значения ключей в том же порядке, для отсутствующих ключей - nil
+	Get(ctx context.Context, keys ...string) ([][]byte, error)
+	// Set сохраняет значение key на ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Del удаляет ключи
+	Del(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/idempotency"
+	"sync"
+	"time"
+)
+
+var _ idempotency.Storage = &IdempotencyStorage{}
+
+// IdempotencyStorage хранит ключи идемпотентности в памяти процесса
+type IdempotencyStorage struct {
+	mu      sync.Mutex
+	records map[string]idempotency.Record
+}
+
+func NewIdempotencyStorage() *IdempotencyStorage {
+	return &IdempotencyStorage{records: make(map[string]idempotency.Record)}
+}
+
+func (s *IdempotencyStorage) Start(ctx context.Context, r *idempotency.Record, expired time.Time,
+	abandoned time.Time) (*idempotency.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.records[r.Key]
+	if ok && !found.CreatedAt.Before(expired) && (found.Response != nil || !found.CreatedAt.Before(abandoned)) {
+		return &found, nil
+	}
+
+	s.records[r.Key] = *r
+
+	return nil, nil
+}
+
+func (s *IdempotencyStorage) Finish(ctx context.Context, r *idempotency.Record, resp *idempotency.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.records[r.Key]
+	if !ok || !leased(&found, r) {
+		return idempotency.ErrLeaseLost
+	}
+
+	found.Response = resp
+	s.records[r.Key] = found
+
+	return nil
+}
+
+func (s *IdempotencyStorage) Delete(ctx context.Context, r *idempotency.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.records[r.Key]
+	if !ok || !leased(&found, r) {
+		return idempotency.ErrLeaseLost
+	}
+
+	delete(s.records, r.Key)
+
+	return nil
+}
+
+// leased сообщает, что ключ записи found все еще занят запросом r
+func leased(found *idempotency.Record, r *idempotency.Record) bool {
+	return found.Response == nil && found.Fingerprint == r.Fingerprint && found.CreatedAt.Equal(r.CreatedAt)
+}
+
+func (s *IdempotencyStorage) Purge(ctx context.Context, expired time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+
+	for key, r := range s.records {
+		if r.CreatedAt.Before(expired) {
+			delete(s.records, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
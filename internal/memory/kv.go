@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/kv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ kv.Store = &KVStore{}
+
+// sweepInterval - как часто KVStore удаляет ключи с истекшим сроком, которые никто не читает
+const sweepInterval = time.Minute
+
+// KVStore хранит счетчики и значения в памяти процесса, когда общего хранилища нет
+type KVStore struct {
+	mu        sync.Mutex
+	data      map[string]kvEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type kvEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewKVStore() *KVStore {
+	return &KVStore{data: make(map[string]kvEntry), now: time.Now}
+}
+
+func (s *KVStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+
+	e, ok := s.get(key)
+	if !ok && ttl > 0 {
+		e.expiresAt = s.now().Add(ttl)
+	}
+
+	n, _ := strconv.ParseInt(string(e.value), 10, 64)
+	n++
+
+	e.value = []byte(strconv.FormatInt(n, 10))
+	s.data[key] = e
+
+	return n, nil
+}
+
+func (s *KVStore) Get(ctx context.Context, keys ...string) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		if e, ok := s.get(key); ok {
+			values[i] = e.value
+		}
+	}
+
+	return values, nil
+}
+
+func (s *KVStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := kvEntry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expiresAt = s.now().Add(ttl)
+	}
+
+	s.data[key] = e
+
+	return nil
+}
+
+func (s *KVStore) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.data, key)
+	}
+
+	return nil
+}
+
+func (s *KVStore) get(key string) (kvEntry, bool) {
+	e, ok := s.data[key]
+	if ok && s.expired(e) {
+		delete(s.data, key)
+		return kvEntry{}, false
+	}
+
+	return e, ok
+}
+
+func (s *KVStore) expired(e kvEntry) bool {
+	return !e.expiresAt.IsZero() && !s.now().Before(e.expiresAt)
+}
+
+func (s *KVStore) sweep() {
+	if s.now().Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+
+	for key, e := range s.data {
+		if s.expired(e) {
+			delete(s.data, key)
+		}
+	}
+
+	s.lastSweep = s.now()
+}
@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/order"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ order.Storage = &OrderStorage{}
+
+// OrderStorage хранит заказы в памяти процесса. Заказы отдаются копиями,
+// поэтому изменения у вызывающего не попадают в хранилище
+type OrderStorage struct {
+	mu     sync.RWMutex
+	orders []*order.Order
+}
+
+func NewOrderStorage() *OrderStorage {
+	return &OrderStorage{}
+}
+
+// Create присваивает заказу следующий ID и первую версию и сохраняет его подтвержденным, как и БД
+func (s *OrderStorage) Create(ctx context.Context, o *order.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o.ID = int64(len(s.orders) + 1)
+	o.Version = 1
+
+	if o.Status == "" {
+		o.Status = order.Confirmed
+	}
+
+	s.orders = append(s.orders, clone(o))
+
+	return nil
+}
+
+// GetAll возвращает заказы по возрастанию ID
+func (s *OrderStorage) GetAll(ctx context.Context) ([]*order.Order, error) {
+	return s.filter(func(o *order.Order) bool {
+		return true
+	}), nil
+}
+
+// FindByID возвращает ошибку вида domain.ErrNotFound, если заказа нет
+func (s *OrderStorage) FindByID(ctx context.Context, id int64) (*order.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if id < 1 || id > int64(len(s.orders)) {
+		return nil, domain.NotFound("can't find order with id= %v", id)
+	}
+
+	return clone(s.orders[id-1]), nil
+}
+
+func (s *OrderStorage) CountByZone(ctx context.Context, zone string, from time.Time, to time.Time) (int, error) {
+	oo := s.filter(func(o *order.Order) bool {
+		return o.Zone == zone && o.Status.Open() && within(o, from, to)
+	})
+
+	return len(oo), nil
+}
+
+func (s *OrderStorage) FindByCourier(ctx context.Context, courierID int64, from time.Time,
+	to time.Time) ([]*order.Order, error) {
+	oo := s.filter(func(o *order.Order) bool {
+		return o.CourierID == courierID && within(o, from, to)
+	})
+
+	byTime(oo)
+
+	return oo, nil
+}
+
+func (s *OrderStorage) FindUnassigned(ctx context.Context, after order.Cursor, limit int) ([]*order.Order, error) {
+	oo := s.filter(func(o *order.Order) bool {
+		return o.Status == order.Confirmed && o.CourierID == 0 && o.Time != nil &&
+			(o.Time.After(after.Time) || o.Time.Equal(after.Time) && o.ID > after.ID)
+	})
+
+	byTime(oo)
+
+	if len(oo) > limit {
+		oo = oo[:limit]
+	}
+
+	return oo, nil
+}
+
+func (s *OrderStorage) filter(match func(o *order.Order) bool) []*order.Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	oo := make([]*order.Order, 0)
+
+	for _, o := range s.orders {
+		if match(o) {
+			oo = append(oo, clone(o))
+		}
+	}
+
+	return oo
+}
+
+// within проверяет, что время доставки заказа попадает в интервал [from, to)
+func within(o *order.Order, from time.Time, to time.Time) bool {
+	return o.Time != nil && !o.Time.Before(from) && o.Time.Before(to)
+}
+
+func byTime(oo []*order.Order) {
+	sort.SliceStable(oo, func(i, j int) bool {
+		return oo[i].Time.Before(oo[j].Time.Time)
+	})
+}
+
+func clone(o *order.Order) *order.Order {
+	c := *o
+
+	if o.Time != nil {
+		t := *o.Time
+		c.Time = &t
+	}
+
+	if o.TimeTo != nil {
+		t := *o.TimeTo
+		c.TimeTo = &t
+	}
+
+	if o.PickedUpAt != nil {
+		t := *o.PickedUpAt
+		c.PickedUpAt = &t
+	}
+
+	if o.ArrivedAt != nil {
+		t := *o.ArrivedAt
+		c.ArrivedAt = &t
+	}
+
+	if o.DeliveredAt != nil {
+		t := *o.DeliveredAt
+		c.DeliveredAt = &t
+	}
+
+	return &c
+}
@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/product"
+	"sync"
+)
+
+var _ product.Storage = &ProductStorage{}
+
+// ProductStorage хранит товары в памяти процесса, например для локального запуска без БД
+type ProductStorage struct {
+	mu       sync.RWMutex
+	products map[int64]product.Product
+	lastID   int64
+}
+
+func NewProductStorage() *ProductStorage {
+	return &ProductStorage{products: make(map[int64]product.Product)}
+}
+
+// Create сохраняет товар. Товару без ID присваивается следующий свободный ID
+func (s *ProductStorage) Create(p *product.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.ID == 0 {
+		p.ID = s.lastID + 1
+	}
+
+	if p.ID > s.lastID {
+		s.lastID = p.ID
+	}
+
+	s.products[p.ID] = *p
+
+	return nil
+}
+
+// FindByID возвращает ошибку вида domain.ErrNotFound, если товара нет
+func (s *ProductStorage) FindByID(ctx context.Context, id int64) (*product.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.products[id]
+	if !ok {
+		return nil, domain.NotFound("can't find product with id= %v", id)
+	}
+
+	return &p, nil
+}
@@ -1,9 +1,28 @@
 package order
 
 import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
 	"safedeal-backend-trainee/internal/ftime"
+	"time"
 )
 
+type Status string
+
+const (
+	// Confirmed - заказ создан и ждет назначения курьера
+	Confirmed Status = "confirmed"
+	// Assigned - заказ назначен курьеру
+	Assigned Status = "assigned"
+	// Delivered - курьер доставил заказ
+	Delivered Status = "delivered"
+)
+
+// Open - заказ еще ждет доставки и занимает курьера
+func (s Status) Open() bool {
+	return s == Confirmed || s == Assigned
+}
+
 type Order struct {
 	ID          int64             `json:"id"`
 	ProductID   int64             `json:"product_id"`
@@ -11,10 +30,40 @@ type Order struct {
 	From        string            `json:"from,omitempty"`
 	Destination string            `json:"destination,omitempty"`
 	Time        *ftime.FormatTime `json:"time,omitempty"`
+	TimeTo      *ftime.FormatTime `json:"time_to,omitempty"`
+	Buyer       string            `json:"buyer,omitempty"`
+	Price       int               `json:"price,omitempty"`
+	PromoCode   string            `json:"promo_code,omitempty"`
+	Zone        string            `json:"zone,omitempty"`
+	CourierID   int64             `json:"courier_id,omitempty"`
+	Status      Status            `json:"status,omitempty"`
+	PickedUpAt  *time.Time        `json:"picked_up_at,omitempty"`
+	ArrivedAt   *time.Time        `json:"arrived_at,omitempty"`
+	DeliveredAt *time.Time        `json:"delivered_at,omitempty"`
+	// Version увеличивается при каждом изменении заказа, клиенты получают ее в заголовке ETag
+	Version int64 `json:"-"`
+}
+
+// Cursor - позиция в очереди заказов без курьера, упорядоченной по времени доставки и id.
+// Нулевой Cursor указывает на начало очереди
+type Cursor struct {
+	Time time.Time
+	ID   int64
 }
 
+// ErrStale - заказ изменился после того, как клиент получил его версию
+var ErrStale = domain.Precondition("order has been changed, get it again and retry")
+
 type Storage interface {
-	Create(o *Order) error
-	GetAll() ([]*Order, error)
-	FindByID(id int64) (*Order, error)
+	Create(ctx context.Context, o *Order) error
+	GetAll(ctx context.Context) ([]*Order, error)
+	// FindByID возвращает ошибку вида domain.ErrNotFound, если заказа нет
+	FindByID(ctx context.Context, id int64) (*Order, error)
+	// CountByZone возвращает число открытых заказов в зоне со временем доставки в интервале [from, to)
+	CountByZone(ctx context.Context, zone string, from time.Time, to time.Time) (int, error)
+	// FindByCourier возвращает заказы курьера со временем доставки в интервале [from, to)
+	FindByCourier(ctx context.Context, courierID int64, from time.Time, to time.Time) ([]*Order, error)
+	// FindUnassigned возвращает не больше limit подтвержденных заказов без курьера, стоящих в очереди
+	// после after, ближайшие по времени первыми
+	FindUnassigned(ctx context.Context, after Cursor, limit int) ([]*Order, error)
 }
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/auth"
+	"safedeal-backend-trainee/internal/domain"
+
+	"github.com/pkg/errors"
+)
+
+var _ auth.Storage = &AuthStorage{}
+
+type AuthStorage struct {
+	statementStorage
+
+	findByKeyStmt *sql.Stmt
+}
+
+func NewAuthStorage(db *DB) (*AuthStorage, error) {
+	s := &AuthStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: findPrincipalByKeyQuery, Dst: &s.findByKeyStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+const findPrincipalByKeyQuery = "SELECT id, role, subject_id FROM api_keys WHERE key_hash=$1"
+
+func (s *AuthStorage) FindByKey(ctx context.Context, key string) (*auth.Principal, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var (
+		p         auth.Principal
+		subjectID sql.NullInt64
+	)
+
+	row := s.findByKeyStmt.QueryRowContext(ctx, auth.HashKey(key))
+	if err := row.Scan(&p.ID, &p.Role, &subjectID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.NotFound("can't find api key")
+		}
+
+		return &p, errors.Wrap(err, "can't scan api key")
+	}
+
+	p.SubjectID = subjectID.Int64
+
+	return &p, nil
+}
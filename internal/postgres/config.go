@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"safedeal-backend-trainee/internal/ftime"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -15,31 +17,37 @@ type Configuration struct {
 	User     string `json:"user"`
 	Password string `json:"password"`
 	DBName   string `json:"db_name"`
+	// QueryTimeout - сколько может выполняться один запрос к БД
+	QueryTimeout ftime.Duration `json:"query_timeout"`
 }
 
-func ParseConfig(filename string) (string, error) {
+const defaultQueryTimeout = 5 * time.Second
+
+func ParseConfig(filename string) (*Configuration, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to read input json file: "+filename)
+		return nil, errors.Wrap(err, "unable to read input json file: "+filename)
 	}
 
 	defer f.Close()
 
 	byteData, err := ioutil.ReadAll(f)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to read input json file as a byte array: "+filename)
+		return nil, errors.Wrap(err, "unable to read input json file as a byte array: "+filename)
 	}
 
-	var c Configuration
+	c := Configuration{QueryTimeout: ftime.Duration{Duration: defaultQueryTimeout}}
 
 	err = json.Unmarshal(byteData, &c)
 	if err != nil {
-		return "", errors.Wrap(err, "can't unmarshal json with configuration")
+		return nil, errors.Wrap(err, "can't unmarshal json with configuration")
 	}
 
-	URL := fmt.Sprintf("host=%s port=%s user=%s "+
+	return &c, nil
+}
+
+func (c *Configuration) URL() string {
+	return fmt.Sprintf("host=%s port=%s user=%s "+
 		"password=%s dbname=%s sslmode=disable",
 		c.Host, c.Port, c.User, c.Password, c.DBName)
-
-	return URL, nil
 }
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/geo"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ courier.Storage = &CourierStorage{}
+
+type CourierStorage struct {
+	statementStorage
+
+	findByIDStmt       *sql.Stmt
+	countAvailableStmt *sql.Stmt
+	updateLocationStmt *sql.Stmt
+	pickUpStmt         *sql.Stmt
+}
+
+func NewCourierStorage(db *DB) (*CourierStorage, error) {
+	s := &CourierStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: findCourierByIDQuery, Dst: &s.findByIDStmt},
+		{Query: countAvailableCouriersQuery, Dst: &s.countAvailableStmt},
+		{Query: updateCourierLocationQuery, Dst: &s.updateLocationStmt},
+		{Query: pickUpOrderQuery, Dst: &s.pickUpStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+func scanCourier(scanner sqlScanner, c *courier.Courier) error {
+	var (
+		lat, lon  sql.NullFloat64
+		locatedAt sql.NullTime
+	)
+
+	err := scanner.Scan(&c.ID, &c.Name, &c.Vehicle, &c.Zone, &c.Available, &lat, &lon, &locatedAt)
+	if err != nil {
+		return err
+	}
+
+	if lat.Valid && lon.Valid {
+		c.Location = &geo.Point{Lat: lat.Float64, Lon: lon.Float64}
+	}
+
+	if locatedAt.Valid {
+		c.LocatedAt = &locatedAt.Time
+	}
+
+	return nil
+}
+
+const courierFields = "name, vehicle, zone, available, lat, lon, located_at"
+const findCourierByIDQuery = "SELECT id, " + courierFields + " FROM couriers WHERE id=$1"
+
+func (s *CourierStorage) FindByID(ctx context.Context, id int64) (*courier.Courier, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var c courier.Courier
+
+	row := s.findByIDStmt.QueryRowContext(ctx, id)
+	if err := scanCourier(row, &c); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.NotFound("can't find courier with id= %v", id)
+		}
+
+		return &c, errors.Wrap(err, "can't scan courier")
+	}
+
+	return &c, nil
+}
+
+const countAvailableCouriersQuery = "SELECT COUNT(*) FROM couriers c WHERE c.available AND " + onActiveShift
+
+func (s *CourierStorage) CountAvailable(ctx context.Context, zone string) (int, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+
+	if err := s.countAvailableStmt.QueryRowContext(ctx, zone).Scan(&n); err != nil {
+		return 0, errors.Wrap(err, "can't count available couriers")
+	}
+
+	return n, nil
+}
+
+const updateCourierLocationQuery = "UPDATE couriers SET lat=$2, lon=$3, located_at=$4 WHERE id=$1"
+
+func (s *CourierStorage) UpdateLocation(ctx context.Context, id int64, p geo.Point, at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.updateLocationStmt.ExecContext(ctx, id, p.Lat, p.Lon, at); err != nil {
+		return errors.Wrap(err, "can't exec query")
+	}
+
+	return nil
+}
+
+// pickUpOrderQuery не меняет время забора при повторной отметке
+const pickUpOrderQuery = "UPDATE orders SET picked_up_at=COALESCE(picked_up_at, $3), version=version+1 " +
+	"WHERE id=$1 AND courier_id=$2 AND status='assigned' AND version=$4"
+
+func (s *CourierStorage) PickUp(ctx context.Context, orderID int64, courierID int64, version int64,
+	at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	err := execAffected(ctx, s.pickUpStmt, courier.ErrNotAssigned, orderID, courierID, at, version)
+	if err == courier.ErrNotAssigned {
+		return staleOr(ctx, s.db.Session, orderID, version, err)
+	}
+
+	return err
+}
@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/dispatch"
+
+	"github.com/pkg/errors"
+)
+
+var _ dispatch.Storage = &DispatchStorage{}
+
+type DispatchStorage struct {
+	statementStorage
+
+	couriersStmt *sql.Stmt
+	assignStmt   *sql.Stmt
+	unassignStmt *sql.Stmt
+	logStmt      *sql.Stmt
+	historyStmt  *sql.Stmt
+}
+
+func NewDispatchStorage(db *DB) (*DispatchStorage, error) {
+	s := &DispatchStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: courierLoadsQuery, Dst: &s.couriersStmt},
+		{Query: assignOrderQuery, Dst: &s.assignStmt},
+		{Query: unassignOrderQuery, Dst: &s.unassignStmt},
+		{Query: logAssignmentQuery, Dst: &s.logStmt},
+		{Query: assignmentHistoryQuery, Dst: &s.historyStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+const courierLoadsQuery = "SELECT c.id, c.name, c.vehicle, c.zone, c.available, c.lat, c.lon, c.located_at, " +
+	"COUNT(o.id), COALESCE(SUM(p.weight), 0) FROM couriers c " +
+	"LEFT JOIN orders o ON o.courier_id = c.id AND o.status = 'assigned' " +
+	"LEFT JOIN products p ON p.id = o.product_id " +
+	"WHERE c.available AND " + onActiveShift + " GROUP BY c.id"
+
+func (s *DispatchStorage) Couriers(ctx context.Context, zone string) ([]*dispatch.Load, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.couriersStmt.QueryContext(ctx, zone)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get courier loads")
+	}
+
+	defer rows.Close()
+
+	loads := make([]*dispatch.Load, 0)
+
+	for rows.Next() {
+		l := &dispatch.Load{Courier: &courier.Courier{}}
+
+		err = scanCourier(scanFunc(func(dest ...interface{}) error {
+			return rows.Scan(append(dest, &l.Orders, &l.Weight)...)
+		}), l.Courier)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't scan row with courier load")
+		}
+
+		loads = append(loads, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return loads, nil
+}
+
+// scanFunc позволяет дочитать дополнительные столбцы после полей сущности
+type scanFunc func(dest ...interface{}) error
+
+func (f scanFunc) Scan(dest ...interface{}) error {
+	return f(dest...)
+}
+
+const assignOrderQuery = "UPDATE orders SET courier_id=$2, status='assigned', version=version+1 " +
+	"WHERE id=$1 AND courier_id IS NULL AND status='confirmed'"
+const logAssignmentQuery = "INSERT INTO order_assignments(order_id, courier_id, action, score, actor, reason, ip) " +
+	"VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at"
+
+func (s *DispatchStorage) Assign(ctx context.Context, a *dispatch.Assignment) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.StmtContext(ctx, s.assignStmt).ExecContext(ctx, a.OrderID, a.CourierID)
+		if err != nil {
+			return errors.Wrap(err, "can't exec query")
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "can't get affected rows")
+		}
+
+		if n == 0 {
+			return dispatch.ErrTaken
+		}
+
+		return s.log(ctx, tx, a)
+	})
+}
+
+// unassignOrderQuery возвращает курьера, который был назначен до отмены. Отметка о заборе снимается:
+// новый курьер забирает заказ сам
+const unassignOrderQuery = "UPDATE orders o SET courier_id=NULL, status='confirmed', picked_up_at=NULL, " +
+	"version=o.version+1 " +
+	"FROM (SELECT id, courier_id, version FROM orders WHERE id=$1 FOR UPDATE) old " +
+	"WHERE o.id = old.id AND old.courier_id IS NOT NULL AND old.version=$2 RETURNING old.courier_id"
+
+func (s *DispatchStorage) Unassign(ctx context.Context, a *dispatch.Assignment, version int64) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.StmtContext(ctx, s.unassignStmt).QueryRowContext(ctx, a.OrderID, version).Scan(&a.CourierID)
+		if err == sql.ErrNoRows {
+			return staleOr(ctx, tx, a.OrderID, version, dispatch.ErrNotAssigned)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "can't exec query")
+		}
+
+		return s.log(ctx, tx, a)
+	})
+}
+
+func (s *DispatchStorage) log(ctx context.Context, tx *sql.Tx, a *dispatch.Assignment) error {
+	row := tx.StmtContext(ctx, s.logStmt).QueryRowContext(ctx, a.OrderID, a.CourierID, a.Action, a.Score,
+		a.Actor, a.Reason, a.IP)
+	if err := row.Scan(&a.ID, &a.CreatedAt); err != nil {
+		return errors.Wrap(err, "can't log assignment")
+	}
+
+	return nil
+}
+
+func (s *DispatchStorage) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Session.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+
+	return nil
+}
+
+const assignmentHistoryQuery = "SELECT id, order_id, courier_id, action, score, actor, reason, ip, created_at " +
+	"FROM order_assignments WHERE order_id=$1 ORDER BY id"
+
+func (s *DispatchStorage) History(ctx context.Context, orderID int64) ([]*dispatch.Assignment, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.historyStmt.QueryContext(ctx, orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get assignment history")
+	}
+
+	defer rows.Close()
+
+	history := make([]*dispatch.Assignment, 0)
+
+	for rows.Next() {
+		var a dispatch.Assignment
+
+		err = rows.Scan(&a.ID, &a.OrderID, &a.CourierID, &a.Action, &a.Score, &a.Actor, &a.Reason, &a.IP,
+			&a.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't scan row with assignment")
+		}
+
+		history = append(history, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return history, nil
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/earnings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ earnings.Storage = &EarningsStorage{}
+
+type EarningsStorage struct {
+	statementStorage
+
+	arriveStmt  *sql.Stmt
+	deliverStmt *sql.Stmt
+	saveStmt    *sql.Stmt
+	findStmt    *sql.Stmt
+}
+
+func NewEarningsStorage(db *DB) (*EarningsStorage, error) {
+	s := &EarningsStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: arriveOrderQuery, Dst: &s.arriveStmt},
+		{Query: deliverOrderQuery, Dst: &s.deliverStmt},
+		{Query: saveEarningQuery, Dst: &s.saveStmt},
+		{Query: findEarningsQuery, Dst: &s.findStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+// arriveOrderQuery не меняет время первого приезда при повторной отметке
+const arriveOrderQuery = "UPDATE orders SET arrived_at=COALESCE(arrived_at, $3), version=version+1 " +
+	"WHERE id=$1 AND courier_id=$2 AND status='assigned' AND version=$4"
+
+func (s *EarningsStorage) Arrive(ctx context.Context, orderID int64, courierID int64, version int64,
+	at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	err := execAffected(ctx, s.arriveStmt, earnings.ErrNotDeliverable, orderID, courierID, at, version)
+	if err == earnings.ErrNotDeliverable {
+		return staleOr(ctx, s.db.Session, orderID, version, err)
+	}
+
+	return err
+}
+
+const deliverOrderQuery = "UPDATE orders SET status='delivered', delivered_at=$3, version=version+1 " +
+	"WHERE id=$1 AND courier_id=$2 AND status='assigned' AND version=$4"
+const earningFields = "order_id, courier_id, delivered_at, distance, waiting_seconds, " +
+	"base, distance_fee, waiting_bonus, total"
+const saveEarningQuery = "INSERT INTO courier_earnings(" + earningFields + ") " +
+	"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+
+func (s *EarningsStorage) Deliver(ctx context.Context, e *earnings.Earning, version int64) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.Session.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	err = execAffected(ctx, tx.StmtContext(ctx, s.deliverStmt), earnings.ErrNotDeliverable, e.OrderID,
+		e.CourierID, e.DeliveredAt, version)
+	if err == earnings.ErrNotDeliverable {
+		err = staleOr(ctx, tx, e.OrderID, version, err)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.StmtContext(ctx, s.saveStmt).ExecContext(ctx, e.OrderID, e.CourierID, e.DeliveredAt, e.Distance,
+		int64(e.Waiting.Seconds()), e.Base, e.DistanceFee, e.WaitingBonus, e.Total)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "can't save earning")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+
+	return nil
+}
+
+const findEarningsQuery = "SELECT " + earningFields + " FROM courier_earnings " +
+	"WHERE courier_id=$1 AND delivered_at >= $2 AND delivered_at < $3 ORDER BY delivered_at"
+
+func (s *EarningsStorage) Find(ctx context.Context, courierID int64, from time.Time,
+	to time.Time) ([]*earnings.Earning, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.findStmt.QueryContext(ctx, courierID, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get earnings")
+	}
+
+	defer rows.Close()
+
+	ee := make([]*earnings.Earning, 0)
+
+	for rows.Next() {
+		var (
+			e       earnings.Earning
+			waiting int64
+		)
+
+		err = rows.Scan(&e.OrderID, &e.CourierID, &e.DeliveredAt, &e.Distance, &waiting,
+			&e.Base, &e.DistanceFee, &e.WaitingBonus, &e.Total)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't scan row with earning")
+		}
+
+		e.Waiting.Duration = time.Duration(waiting) * time.Second
+		ee = append(ee, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return ee, nil
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"safedeal-backend-trainee/internal/idempotency"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ idempotency.Storage = &IdempotencyStorage{}
+
+type IdempotencyStorage struct {
+	statementStorage
+
+	startStmt  *sql.Stmt
+	findStmt   *sql.Stmt
+	finishStmt *sql.Stmt
+	deleteStmt *sql.Stmt
+	purgeStmt  *sql.Stmt
+}
+
+func NewIdempotencyStorage(db *DB) (*IdempotencyStorage, error) {
+	s := &IdempotencyStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: startIdempotencyQuery, Dst: &s.startStmt},
+		{Query: findIdempotencyQuery, Dst: &s.findStmt},
+		{Query: finishIdempotencyQuery, Dst: &s.finishStmt},
+		{Query: deleteIdempotencyQuery, Dst: &s.deleteStmt},
+		{Query: purgeIdempotencyQuery, Dst: &s.purgeStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+// errKeyTaken - ключ занят неустаревшей записью
+var errKeyTaken = errors.New("idempotency key is taken")
+
+const (
+	// startIdempotencyQuery занимает свободный ключ или заменяет устаревшую запись и запись
+	// брошенного запроса без ответа, созданную раньше $5. Остальные записи остаются без изменений
+	startIdempotencyQuery = "INSERT INTO idempotency_keys(key, fingerprint, created_at) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (key) DO UPDATE SET fingerprint=EXCLUDED.fingerprint, created_at=EXCLUDED.created_at, " +
+		"status=NULL, header=NULL, body=NULL WHERE idempotency_keys.created_at < $4 " +
+		"OR idempotency_keys.status IS NULL AND idempotency_keys.created_at < $5"
+	findIdempotencyQuery = "SELECT key, fingerprint, created_at, status, header, body " +
+		"FROM idempotency_keys WHERE key=$1"
+)
+
+func (s *IdempotencyStorage) Start(ctx context.Context, r *idempotency.Record, expired time.Time,
+	abandoned time.Time) (*idempotency.Record, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	err := execAffected(ctx, s.startStmt, errKeyTaken, r.Key, r.Fingerprint, r.CreatedAt, expired, abandoned)
+	if err != errKeyTaken {
+		return nil, err
+	}
+
+	var (
+		found  idempotency.Record
+		status sql.NullInt64
+		header []byte
+		body   []byte
+	)
+
+	err = s.findStmt.QueryRowContext(ctx, r.Key).Scan(&found.Key, &found.Fingerprint, &found.CreatedAt,
+		&status, &header, &body)
+	if err != nil {
+		// запись удалили после неудачного запроса, пока ключ проверялся
+		if err == sql.ErrNoRows {
+			return nil, idempotency.ErrInProgress
+		}
+
+		return nil, errors.Wrap(err, "can't scan idempotency key")
+	}
+
+	if status.Valid {
+		found.Response = &idempotency.Response{Status: int(status.Int64), Body: body}
+
+		if err = json.Unmarshal(header, &found.Response.Header); err != nil {
+			return nil, errors.Wrap(err, "can't unmarshal response header")
+		}
+	}
+
+	return &found, nil
+}
+
+// finishIdempotencyQuery и deleteIdempotencyQuery меняют запись, только если ключ все еще занят
+// тем же запросом: после истечения Lease его мог занять другой запрос
+const (
+	finishIdempotencyQuery = "UPDATE idempotency_keys SET status=$4, header=$5, body=$6 " +
+		"WHERE key=$1 AND fingerprint=$2 AND created_at=$3 AND status IS NULL"
+	deleteIdempotencyQuery = "DELETE FROM idempotency_keys " +
+		"WHERE key=$1 AND fingerprint=$2 AND created_at=$3 AND status IS NULL"
+)
+
+func (s *IdempotencyStorage) Finish(ctx context.Context, r *idempotency.Record, resp *idempotency.Response) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal response header")
+	}
+
+	err = execAffected(ctx, s.finishStmt, idempotency.ErrLeaseLost, r.Key, r.Fingerprint, r.CreatedAt,
+		resp.Status, header, resp.Body)
+
+	return errors.Wrap(err, "can't save response")
+}
+
+func (s *IdempotencyStorage) Delete(ctx context.Context, r *idempotency.Record) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	err := execAffected(ctx, s.deleteStmt, idempotency.ErrLeaseLost, r.Key, r.Fingerprint, r.CreatedAt)
+
+	return errors.Wrap(err, "can't delete idempotency key")
+}
+
+const purgeIdempotencyQuery = "DELETE FROM idempotency_keys WHERE created_at < $1"
+
+func (s *IdempotencyStorage) Purge(ctx context.Context, expired time.Time) (int64, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.purgeStmt.ExecContext(ctx, expired)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't purge idempotency keys")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get affected rows")
+	}
+
+	return n, nil
+}
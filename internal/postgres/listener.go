@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/product"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// productChangesChannel - канал, в который триггер products_changed отправляет ID измененного товара
+const productChangesChannel = "products_changed"
+
+const (
+	minReconnectInterval = 10 * time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// ProductListener получает уведомления об изменении товаров в отдельном соединении с БД
+type ProductListener struct {
+	db       *DB
+	listener *pq.Listener
+}
+
+func NewProductListener(db *DB) (*ProductListener, error) {
+	l := &ProductListener{db: db}
+
+	l.listener = pq.NewListener(db.url, minReconnectInterval, maxReconnectInterval, l.logEvent)
+
+	if err := l.listener.Listen(productChangesChannel); err != nil {
+		_ = l.listener.Close()
+		return nil, errors.Wrapf(err, "can't listen channel %q", productChangesChannel)
+	}
+
+	return l, nil
+}
+
+func (l *ProductListener) logEvent(event pq.ListenerEventType, err error) {
+	if err != nil {
+		l.db.Logger.Warnf("Product changes listener event %d: %v", event, err)
+	}
+}
+
+// Run сбрасывает в c измененные товары, пока не отменен ctx. После переподключения
+// уведомления могли потеряться, поэтому сбрасываются все товары
+func (l *ProductListener) Run(ctx context.Context, c product.Invalidator) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.listener.Notify:
+			if err := l.invalidate(ctx, c, n); err != nil {
+				l.db.Logger.Errorf("Can't invalidate cached products: %v", err)
+			}
+		}
+	}
+}
+
+func (l *ProductListener) invalidate(ctx context.Context, c product.Invalidator, n *pq.Notification) error {
+	if n == nil {
+		return c.Purge(ctx)
+	}
+
+	id, err := strconv.ParseInt(n.Extra, 10, 64)
+	if err != nil {
+		l.db.Logger.Errorf("Can't parse changed product id %q: %v", n.Extra, err)
+		return c.Purge(ctx)
+	}
+
+	return c.Invalidate(ctx, id)
+}
+
+func (l *ProductListener) Close() error {
+	if err := l.listener.Close(); err != nil {
+		return errors.Wrap(err, "can't close product changes listener")
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID - ключ advisory lock, который держит экземпляр, применяющий миграции
+const migrationLockID = 7103553
+
+// Migration - пара SQL скриптов migrations/<version>_<name>.up.sql и .down.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus - миграция и время ее применения, AppliedAt не задан у непримененных
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// LoadMigrations читает миграции из fsys и сортирует их по номеру. У каждой миграции
+// должны быть оба скрипта, номера не должны повторяться
+func LoadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read migrations dir")
+	}
+
+	index := make(map[int]*Migration)
+
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, errors.Errorf("unexpected file %q in migrations", e.Name())
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't parse version of migration %q", e.Name())
+		}
+
+		mg, ok := index[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: m[2]}
+			index[version] = mg
+		}
+
+		if mg.Name != m[2] {
+			return nil, errors.Errorf("migrations %q and %q have the same version", mg.Name, m[2])
+		}
+
+		script, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read migration %q", e.Name())
+		}
+
+		if m[3] == "up" {
+			mg.Up = string(script)
+		} else {
+			mg.Down = string(script)
+		}
+	}
+
+	mm := make([]*Migration, 0, len(index))
+
+	for _, mg := range index {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, errors.Errorf("migration %04d_%s must have up and down scripts", mg.Version, mg.Name)
+		}
+
+		mm = append(mm, mg)
+	}
+
+	sort.Slice(mm, func(i, j int) bool {
+		return mm[i].Version < mm[j].Version
+	})
+
+	return mm, nil
+}
+
+type Migrator struct {
+	db         *DB
+	migrations []*Migration
+}
+
+// NewMigrator создает мигратор со встроенными в бинарный файл миграциями
+func NewMigrator(db *DB) (*Migrator, error) {
+	mm, err := LoadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: mm}, nil
+}
+
+const createMigrationsTableQuery = "CREATE TABLE IF NOT EXISTS schema_migrations (" +
+	"version INTEGER PRIMARY KEY, " +
+	"name VARCHAR (200) NOT NULL, " +
+	"applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now())"
+
+// session выполняет f на отдельном соединении
+func (m *Migrator) session(f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Session.Conn(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "can't get connection")
+	}
+
+	defer conn.Close()
+
+	return f(conn)
+}
+
+// locked выполняет f на отдельном соединении под advisory lock, чтобы несколько
+// экземпляров сервиса не применяли миграции одновременно
+func (m *Migrator) locked(f func(conn *sql.Conn) error) error {
+	return m.session(func(conn *sql.Conn) error {
+		ctx := context.Background()
+
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return errors.Wrap(err, "can't take migration lock")
+		}
+
+		defer func() {
+			if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+				m.db.Logger.Errorf("can't release migration lock: %v", err)
+			}
+		}()
+
+		if _, err := conn.ExecContext(ctx, createMigrationsTableQuery); err != nil {
+			return errors.Wrap(err, "can't create schema_migrations table")
+		}
+
+		return f(conn)
+	})
+}
+
+func applied(conn *sql.Conn) (map[int]*MigrationStatus, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get applied migrations")
+	}
+
+	defer rows.Close()
+
+	statuses := make(map[int]*MigrationStatus)
+
+	for rows.Next() {
+		var (
+			s  MigrationStatus
+			at time.Time
+		)
+
+		if err = rows.Scan(&s.Version, &s.Name, &at); err != nil {
+			return nil, errors.Wrap(err, "can't scan row with migration")
+		}
+
+		s.AppliedAt = &at
+		statuses[s.Version] = &s
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return statuses, nil
+}
+
+// Up применяет все непримененные миграции по возрастанию номера, каждую в своей транзакции,
+// и возвращает примененные
+func (m *Migrator) Up() ([]*Migration, error) {
+	done := make([]*Migration, 0)
+
+	err := m.locked(func(conn *sql.Conn) error {
+		statuses, err := applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := statuses[mg.Version]; ok {
+				continue
+			}
+
+			err = migrate(conn, mg.Up, "INSERT INTO schema_migrations(version, name) VALUES ($1, $2)",
+				mg.Version, mg.Name)
+			if err != nil {
+				return errors.Wrapf(err, "can't apply migration %04d_%s", mg.Version, mg.Name)
+			}
+
+			done = append(done, mg)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down откатывает последнюю примененную миграцию. Если примененных миграций нет, возвращается nil
+func (m *Migrator) Down() (*Migration, error) {
+	var last *Migration
+
+	err := m.locked(func(conn *sql.Conn) error {
+		statuses, err := applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := statuses[m.migrations[i].Version]; ok {
+				last = m.migrations[i]
+				break
+			}
+		}
+
+		if last == nil {
+			return nil
+		}
+
+		err = migrate(conn, last.Down, "DELETE FROM schema_migrations WHERE version=$1", last.Version)
+		if err != nil {
+			return errors.Wrapf(err, "can't revert migration %04d_%s", last.Version, last.Name)
+		}
+
+		return nil
+	})
+
+	return last, err
+}
+
+// Status возвращает все известные миграции, а также примененные в БД миграции,
+// которых нет в бинарном файле. Status только читает schema_migrations и не ждет
+// advisory lock, поэтому работает и во время применения миграций другим экземпляром
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	var ss []*MigrationStatus
+
+	err := m.session(func(conn *sql.Conn) error {
+		var exists bool
+
+		err := conn.QueryRowContext(context.Background(),
+			"SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+		if err != nil {
+			return errors.Wrap(err, "can't check schema_migrations table")
+		}
+
+		statuses := make(map[int]*MigrationStatus)
+
+		if exists {
+			if statuses, err = applied(conn); err != nil {
+				return err
+			}
+		}
+
+		ss = make([]*MigrationStatus, 0, len(m.migrations))
+
+		for _, mg := range m.migrations {
+			s, ok := statuses[mg.Version]
+			if !ok {
+				s = &MigrationStatus{Version: mg.Version, Name: mg.Name}
+			}
+
+			delete(statuses, mg.Version)
+			ss = append(ss, s)
+		}
+
+		for _, s := range statuses {
+			ss = append(ss, s)
+		}
+
+		sort.Slice(ss, func(i, j int) bool {
+			return ss[i].Version < ss[j].Version
+		})
+
+		return nil
+	})
+
+	return ss, err
+}
+
+// migrate выполняет скрипт миграции и запись в schema_migrations в одной транзакции
+func migrate(conn *sql.Conn, script string, record string, args ...interface{}) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "can't exec script")
+	}
+
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "can't record migration")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+
+	return nil
+}
+
+func (s *MigrationStatus) String() string {
+	if s.AppliedAt == nil {
+		return fmt.Sprintf("%04d_%s\tpending", s.Version, s.Name)
+	}
+
+	return fmt.Sprintf("%04d_%s\tapplied at %s", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func script(s string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(s)}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0010_add_zone.up.sql":        script("ALTER TABLE orders ADD COLUMN zone TEXT"),
+		"migrations/0010_add_zone.down.sql":      script("ALTER TABLE orders DROP COLUMN zone"),
+		"migrations/0002_create_orders.up.sql":   script("CREATE TABLE orders ()"),
+		"migrations/0002_create_orders.down.sql": script("DROP TABLE orders"),
+		"migrations/1_init.up.sql":               script("CREATE TABLE products ()"),
+		"migrations/1_init.down.sql":             script("DROP TABLE products"),
+	}
+
+	mm, err := LoadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("LoadMigrations returned error %v", err)
+	}
+
+	expected := []Migration{
+		{Version: 1, Name: "init", Up: "CREATE TABLE products ()", Down: "DROP TABLE products"},
+		{Version: 2, Name: "create_orders", Up: "CREATE TABLE orders ()", Down: "DROP TABLE orders"},
+		{Version: 10, Name: "add_zone", Up: "ALTER TABLE orders ADD COLUMN zone TEXT",
+			Down: "ALTER TABLE orders DROP COLUMN zone"},
+	}
+
+	if len(mm) != len(expected) {
+		t.Fatalf("LoadMigrations returned %v migrations, want %v", len(mm), len(expected))
+	}
+
+	for i, mg := range mm {
+		if *mg != expected[i] {
+			t.Errorf("LoadMigrations returned migration %+v at %v, want %+v", *mg, i, expected[i])
+		}
+	}
+}
+
+func TestLoadMigrationsIncorrect(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		err  string
+	}{
+		{
+			name: "unexpected name",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.sql": script("CREATE TABLE products ()"),
+			},
+			err: `unexpected file "0001_init.sql" in migrations`,
+		},
+		{
+			name: "name without version",
+			fsys: fstest.MapFS{
+				"migrations/init.up.sql":   script("CREATE TABLE products ()"),
+				"migrations/init.down.sql": script("DROP TABLE products"),
+			},
+			err: `unexpected file "init.down.sql" in migrations`,
+		},
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.up.sql":     script("CREATE TABLE products ()"),
+				"migrations/0001_init.down.sql":   script("DROP TABLE products"),
+				"migrations/0001_orders.up.sql":   script("CREATE TABLE orders ()"),
+				"migrations/0001_orders.down.sql": script("DROP TABLE orders"),
+			},
+			err: `migrations "init" and "orders" have the same version`,
+		},
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.up.sql": script("CREATE TABLE products ()"),
+			},
+			err: "migration 0001_init must have up and down scripts",
+		},
+		{
+			name: "missing up",
+			fsys: fstest.MapFS{
+				"migrations/0001_init.down.sql": script("DROP TABLE products"),
+			},
+			err: "migration 0001_init must have up and down scripts",
+		},
+		{
+			name: "missing dir",
+			fsys: fstest.MapFS{},
+			err:  "can't read migrations dir",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadMigrations(tt.fsys, "migrations")
+			if err == nil {
+				t.Fatalf("LoadMigrations returned no error, want %q", tt.err)
+			}
+
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("LoadMigrations returned error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	mm, err := LoadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatalf("LoadMigrations returned error %v for embedded migrations", err)
+	}
+
+	for i, mg := range mm {
+		if mg.Version != i+1 {
+			t.Errorf("embedded migration %04d_%s has version %v, want %v", mg.Version, mg.Name, mg.Version, i+1)
+		}
+	}
+}
@@ -1,4 +1,7 @@
-CREATE TABLE products (
+-- Схема из tables.sql, которая раньше применялась вручную. IF NOT EXISTS позволяет принять
+-- под миграции созданную по нему БД, все последующие изменения схемы вносят следующие миграции
+
+CREATE TABLE IF NOT EXISTS products (
 	id SERIAL PRIMARY KEY,
 	name VARCHAR (150) NOT NULL,
 	width DOUBLE PRECISION NOT NULL,
@@ -6,13 +9,13 @@ CREATE TABLE products (
 	height DOUBLE PRECISION NOT NULL,
 	weight DOUBLE PRECISION NOT NULL,
 	place VARCHAR (200) NOT NULL
-)
+);
 
-CREATE TABLE orders (
+CREATE TABLE IF NOT EXISTS orders (
 	id SERIAL PRIMARY KEY,
 	product_id INTEGER REFERENCES products (id) NOT NULL,
 	name VARCHAR (150) NOT NULL,
 	from_place VARCHAR (200) NOT NULL,
 	destination VARCHAR (200) NOT NULL,
 	time TIMESTAMP WITH TIME ZONE NOT NULL
-)
\ No newline at end of file
+);
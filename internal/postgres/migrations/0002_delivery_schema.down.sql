@@ -0,0 +1,25 @@
+DROP TABLE slot_reservations;
+DROP TABLE api_keys;
+DROP TABLE surge_overrides;
+DROP TABLE shift_breaks;
+DROP TABLE shifts;
+DROP TABLE ratings;
+DROP TABLE courier_earnings;
+DROP TABLE order_assignments;
+DROP TABLE promo_redemptions;
+DROP TABLE promo_codes;
+DROP INDEX orders_unassigned_idx;
+DROP INDEX orders_courier_time_idx;
+DROP INDEX orders_zone_time_idx;
+ALTER TABLE orders
+	DROP COLUMN delivered_at,
+	DROP COLUMN arrived_at,
+	DROP COLUMN status,
+	DROP COLUMN courier_id,
+	DROP COLUMN time_to,
+	DROP COLUMN zone,
+	DROP COLUMN promo_code,
+	DROP COLUMN price,
+	DROP COLUMN buyer;
+DROP TABLE couriers;
+ALTER TABLE products DROP COLUMN price;
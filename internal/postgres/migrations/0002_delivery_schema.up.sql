@@ -0,0 +1,139 @@
+-- Цены, промокоды, зоны, курьеры и остальные таблицы, добавленные в схему после tables.sql.
+-- Столбцы products и orders добавляются с IF NOT EXISTS, как и сами таблицы в 0001
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE couriers (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR (150) NOT NULL,
+	vehicle VARCHAR (20) NOT NULL CHECK (vehicle IN ('foot', 'bike', 'car')),
+	zone VARCHAR (50) NOT NULL,
+	available BOOLEAN NOT NULL DEFAULT false,
+	lat DOUBLE PRECISION,
+	lon DOUBLE PRECISION,
+	located_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE orders
+	ADD COLUMN IF NOT EXISTS buyer VARCHAR (200) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS price INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS promo_code VARCHAR (50) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS zone VARCHAR (50) NOT NULL DEFAULT 'default',
+	ADD COLUMN IF NOT EXISTS time_to TIMESTAMP WITH TIME ZONE,
+	ADD COLUMN IF NOT EXISTS courier_id INTEGER REFERENCES couriers (id),
+	ADD COLUMN IF NOT EXISTS status VARCHAR (20) NOT NULL DEFAULT 'confirmed'
+		CHECK (status IN ('confirmed', 'assigned', 'delivered')),
+	ADD COLUMN IF NOT EXISTS arrived_at TIMESTAMP WITH TIME ZONE,
+	ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE promo_codes (
+	id SERIAL PRIMARY KEY,
+	code VARCHAR (50) UNIQUE NOT NULL,
+	type VARCHAR (20) NOT NULL CHECK (type IN ('fixed', 'percent', 'free_delivery')),
+	value INTEGER NOT NULL DEFAULT 0,
+	valid_from TIMESTAMP WITH TIME ZONE NOT NULL,
+	valid_to TIMESTAMP WITH TIME ZONE,
+	max_uses INTEGER NOT NULL DEFAULT 0,
+	max_uses_per_buyer INTEGER NOT NULL DEFAULT 0,
+	min_order_value INTEGER NOT NULL DEFAULT 0,
+	product_id INTEGER REFERENCES products (id),
+	zone VARCHAR (50)
+);
+
+CREATE TABLE promo_redemptions (
+	id SERIAL PRIMARY KEY,
+	code_id INTEGER REFERENCES promo_codes (id) NOT NULL,
+	order_id INTEGER REFERENCES orders (id),
+	buyer VARCHAR (200) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX orders_zone_time_idx ON orders (zone, time);
+
+CREATE INDEX orders_courier_time_idx ON orders (courier_id, time);
+
+CREATE INDEX orders_unassigned_idx ON orders (time) WHERE status = 'confirmed' AND courier_id IS NULL;
+
+CREATE TABLE order_assignments (
+	id SERIAL PRIMARY KEY,
+	order_id INTEGER REFERENCES orders (id) NOT NULL,
+	courier_id INTEGER REFERENCES couriers (id) NOT NULL,
+	action VARCHAR (20) NOT NULL CHECK (action IN ('assign', 'unassign')),
+	score DOUBLE PRECISION NOT NULL DEFAULT 0,
+	actor VARCHAR (50) NOT NULL,
+	reason VARCHAR (500) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX order_assignments_order_idx ON order_assignments (order_id);
+
+CREATE TABLE courier_earnings (
+	order_id INTEGER PRIMARY KEY REFERENCES orders (id),
+	courier_id INTEGER REFERENCES couriers (id) NOT NULL,
+	delivered_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	distance DOUBLE PRECISION NOT NULL,
+	waiting_seconds INTEGER NOT NULL,
+	base INTEGER NOT NULL,
+	distance_fee INTEGER NOT NULL,
+	waiting_bonus INTEGER NOT NULL,
+	total INTEGER NOT NULL
+);
+
+CREATE INDEX courier_earnings_courier_idx ON courier_earnings (courier_id, delivered_at);
+
+CREATE TABLE ratings (
+	order_id INTEGER PRIMARY KEY REFERENCES orders (id),
+	courier_id INTEGER REFERENCES couriers (id) NOT NULL,
+	pickup VARCHAR (200) NOT NULL,
+	score SMALLINT NOT NULL CHECK (score BETWEEN 1 AND 5),
+	tags VARCHAR (50)[] NOT NULL DEFAULT '{}',
+	comment VARCHAR (1000) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX ratings_created_idx ON ratings (created_at);
+
+CREATE TABLE shifts (
+	id SERIAL PRIMARY KEY,
+	courier_id INTEGER REFERENCES couriers (id) NOT NULL,
+	zones VARCHAR (50)[] NOT NULL,
+	starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	ends_at TIMESTAMP WITH TIME ZONE NOT NULL CHECK (ends_at > starts_at),
+	clock_in TIMESTAMP WITH TIME ZONE,
+	clock_out TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX shifts_courier_idx ON shifts (courier_id, ends_at);
+
+CREATE INDEX shifts_time_idx ON shifts (starts_at, ends_at);
+
+CREATE TABLE shift_breaks (
+	id SERIAL PRIMARY KEY,
+	shift_id INTEGER REFERENCES shifts (id) NOT NULL,
+	started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	ended_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX shift_breaks_open_idx ON shift_breaks (shift_id) WHERE ended_at IS NULL;
+
+CREATE TABLE surge_overrides (
+	zone VARCHAR (50) PRIMARY KEY,
+	mode VARCHAR (20) NOT NULL CHECK (mode IN ('override', 'freeze')),
+	multiplier DOUBLE PRECISION NOT NULL,
+	expires_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE api_keys (
+	id SERIAL PRIMARY KEY,
+	key_hash CHAR (64) UNIQUE NOT NULL,
+	role VARCHAR (20) NOT NULL CHECK (role IN ('admin', 'seller', 'courier')),
+	subject_id INTEGER
+);
+
+CREATE TABLE slot_reservations (
+	zone VARCHAR (50) NOT NULL,
+	slot_start TIMESTAMP WITH TIME ZONE NOT NULL,
+	reserved INTEGER NOT NULL CHECK (reserved >= 0),
+	PRIMARY KEY (zone, slot_start)
+);
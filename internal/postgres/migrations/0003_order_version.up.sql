@@ -0,0 +1,4 @@
+-- Версия заказа для оптимистичной блокировки: каждый UPDATE заказа увеличивает ее,
+-- а изменения от клиентов применяются, только если версия совпадает с заголовком If-Match
+
+ALTER TABLE orders ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,13 @@
+-- Ключи идемпотентности создания заказов: отпечаток запроса и ответ, который
+-- повторяется для запросов с тем же ключом. Ответ пустой, пока запрос выполняется
+
+CREATE TABLE idempotency_keys (
+	key VARCHAR (255) PRIMARY KEY,
+	fingerprint CHAR(64) NOT NULL,
+	status INTEGER,
+	header JSONB,
+	body BYTEA,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,12 @@
+-- Уведомления об изменении и удалении товаров для сброса кэша товаров в экземплярах сервиса.
+-- В канал products_changed передается ID товара
+
+CREATE OR REPLACE FUNCTION notify_product_changed() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('products_changed', OLD.id::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER products_changed AFTER UPDATE OR DELETE ON products
+	FOR EACH ROW EXECUTE PROCEDURE notify_product_changed();
@@ -0,0 +1 @@
+ALTER TABLE order_assignments DROP COLUMN ip;
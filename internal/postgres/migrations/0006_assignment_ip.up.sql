@@ -0,0 +1,4 @@
+-- IP-адрес клиента, который изменил назначение через API. У назначений диспетчера и записей,
+-- сделанных до миграции, адрес пустой
+
+ALTER TABLE order_assignments ADD COLUMN ip VARCHAR (45) NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Покупатели получают API-ключи с ролью buyer, subject_id - идентификатор покупателя.
+-- Лимиты промокодов на покупателя и оценки доставки привязаны к этому ключу
+
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_role_check;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_role_check CHECK (role IN ('admin', 'seller', 'courier', 'buyer'));
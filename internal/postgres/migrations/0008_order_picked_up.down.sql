@@ -0,0 +1 @@
+ALTER TABLE orders DROP COLUMN picked_up_at;
@@ -0,0 +1,3 @@
+-- Время, когда курьер забрал заказ. Для забранных заказов маршрут курьера не содержит точку забора
+
+ALTER TABLE orders ADD COLUMN picked_up_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+DELETE FROM idempotency_keys WHERE length(key) > 255;
+ALTER TABLE idempotency_keys ALTER COLUMN key TYPE VARCHAR (255);
@@ -0,0 +1,4 @@
+-- Ключ идемпотентности хранится с префиксом клиента (API-ключа или IP), которому он принадлежит.
+-- Старые ключи без префикса больше не совпадают с новыми запросами и удаляются по TTL
+
+ALTER TABLE idempotency_keys ALTER COLUMN key TYPE VARCHAR (320);
@@ -1,8 +1,11 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"safedeal-backend-trainee/internal/domain"
 	"safedeal-backend-trainee/internal/order"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -12,9 +15,12 @@ var _ order.Storage = &OrderStorage{}
 type OrderStorage struct {
 	statementStorage
 
-	createStmt   *sql.Stmt
-	getAllStmt   *sql.Stmt
-	findByIDStmt *sql.Stmt
+	createStmt         *sql.Stmt
+	getAllStmt         *sql.Stmt
+	findByIDStmt       *sql.Stmt
+	countByZoneStmt    *sql.Stmt
+	findByCourierStmt  *sql.Stmt
+	findUnassignedStmt *sql.Stmt
 }
 
 func NewOrderStorage(db *DB) (*OrderStorage, error) {
@@ -24,6 +30,9 @@ func NewOrderStorage(db *DB) (*OrderStorage, error) {
 		{Query: createOrderQuery, Dst: &s.createStmt},
 		{Query: getAllOrdersQuery, Dst: &s.getAllStmt},
 		{Query: findOrderByIDQuery, Dst: &s.findByIDStmt},
+		{Query: countOrdersByZoneQuery, Dst: &s.countByZoneStmt},
+		{Query: findOrdersByCourierQuery, Dst: &s.findByCourierStmt},
+		{Query: findUnassignedOrdersQuery, Dst: &s.findUnassignedStmt},
 	}
 
 	if err := s.initStatements(stmts); err != nil {
@@ -34,30 +43,72 @@ func NewOrderStorage(db *DB) (*OrderStorage, error) {
 }
 
 func scanOrder(scanner sqlScanner, o *order.Order) error {
-	return scanner.Scan(&o.ID, &o.ProductID, &o.Name, &o.From, &o.Destination, &o.Time)
+	var (
+		courierID                          sql.NullInt64
+		pickedUpAt, arrivedAt, deliveredAt sql.NullTime
+	)
+
+	err := scanner.Scan(&o.ID, &o.ProductID, &o.Name, &o.From, &o.Destination, &o.Time,
+		&o.Buyer, &o.Price, &o.PromoCode, &o.Zone, &o.TimeTo, &courierID, &o.Status, &pickedUpAt, &arrivedAt,
+		&deliveredAt, &o.Version)
+	if err != nil {
+		return err
+	}
+
+	o.CourierID = courierID.Int64
+
+	if pickedUpAt.Valid {
+		o.PickedUpAt = &pickedUpAt.Time
+	}
+
+	if arrivedAt.Valid {
+		o.ArrivedAt = &arrivedAt.Time
+	}
+
+	if deliveredAt.Valid {
+		o.DeliveredAt = &deliveredAt.Time
+	}
+
+	return nil
 }
 
-const orderFields = "product_id, name, from_place, destination, time"
-const createOrderQuery = "INSERT INTO orders(" + orderFields + ") VALUES ($1, $2, $3, $4, $5) RETURNING id"
+const orderFields = "product_id, name, from_place, destination, time, buyer, price, promo_code, zone, time_to"
+const selectOrderFields = orderFields + ", courier_id, status, picked_up_at, arrived_at, delivered_at, version"
+const createOrderQuery = "INSERT INTO orders(" + orderFields + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) " +
+	"RETURNING id, version, status"
+
+func (s *OrderStorage) Create(ctx context.Context, o *order.Order) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
 
-func (s *OrderStorage) Create(o *order.Order) error {
-	if err := s.createStmt.QueryRow(o.ProductID, o.Name, o.From, o.Destination, o.Time).Scan(&o.ID); err != nil {
+	row := s.bind(ctx, s.createStmt).QueryRowContext(ctx, o.ProductID, o.Name, o.From, o.Destination,
+		o.Time, o.Buyer, o.Price, o.PromoCode, o.Zone, o.TimeTo)
+	if err := row.Scan(&o.ID, &o.Version, &o.Status); err != nil {
 		return errors.Wrap(err, "can't exec query")
 	}
 
 	return nil
 }
 
-const getAllOrdersQuery = "SELECT id, " + orderFields + " FROM orders"
+const getAllOrdersQuery = "SELECT id, " + selectOrderFields + " FROM orders ORDER BY id"
+
+func (s *OrderStorage) GetAll(ctx context.Context) ([]*order.Order, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
 
-func (s *OrderStorage) GetAll() ([]*order.Order, error) {
-	rows, err := s.getAllStmt.Query()
+	rows, err := s.bind(ctx, s.getAllStmt).QueryContext(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't exec query to get all orders")
 	}
 
+	return scanOrders(rows)
+}
+
+func scanOrders(rows *sql.Rows) ([]*order.Order, error) {
 	defer rows.Close()
 
+	var err error
+
 	orders := make([]*order.Order, 0)
 
 	for rows.Next() {
@@ -78,15 +129,18 @@ func (s *OrderStorage) GetAll() ([]*order.Order, error) {
 	return orders, nil
 }
 
-const findOrderByIDQuery = "SELECT id, " + orderFields + " FROM orders WHERE id=$1"
+const findOrderByIDQuery = "SELECT id, " + selectOrderFields + " FROM orders WHERE id=$1"
+
+func (s *OrderStorage) FindByID(ctx context.Context, id int64) (*order.Order, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
 
-func (s *OrderStorage) FindByID(id int64) (*order.Order, error) {
 	var o order.Order
 
-	row := s.findByIDStmt.QueryRow(id)
+	row := s.bind(ctx, s.findByIDStmt).QueryRowContext(ctx, id)
 	if err := scanOrder(row, &o); err != nil {
 		if err == sql.ErrNoRows {
-			return &o, nil
+			return nil, domain.NotFound("can't find order with id= %v", id)
 		}
 
 		return &o, errors.Wrap(err, "can't scan order")
@@ -94,3 +148,78 @@ func (s *OrderStorage) FindByID(id int64) (*order.Order, error) {
 
 	return &o, nil
 }
+
+const countOrdersByZoneQuery = "SELECT COUNT(*) FROM orders " +
+	"WHERE zone=$1 AND time >= $2 AND time < $3 AND status IN ('confirmed', 'assigned')"
+
+func (s *OrderStorage) CountByZone(ctx context.Context, zone string, from time.Time, to time.Time) (int, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+
+	if err := s.bind(ctx, s.countByZoneStmt).QueryRowContext(ctx, zone, from, to).Scan(&n); err != nil {
+		return 0, errors.Wrap(err, "can't count orders")
+	}
+
+	return n, nil
+}
+
+const findOrdersByCourierQuery = "SELECT id, " + selectOrderFields + " FROM orders " +
+	"WHERE courier_id=$1 AND time >= $2 AND time < $3 ORDER BY time"
+
+func (s *OrderStorage) FindByCourier(ctx context.Context, courierID int64, from time.Time,
+	to time.Time) ([]*order.Order, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.bind(ctx, s.findByCourierStmt).QueryContext(ctx, courierID, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get courier's orders")
+	}
+
+	return scanOrders(rows)
+}
+
+const findUnassignedOrdersQuery = "SELECT id, " + selectOrderFields + " FROM orders " +
+	"WHERE status='confirmed' AND courier_id IS NULL AND (time, id) > ($1, $2) ORDER BY time, id LIMIT $3"
+
+func (s *OrderStorage) FindUnassigned(ctx context.Context, after order.Cursor, limit int) ([]*order.Order, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.bind(ctx, s.findUnassignedStmt).QueryContext(ctx, after.Time, after.ID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get unassigned orders")
+	}
+
+	return scanOrders(rows)
+}
+
+// querier - соединение или транзакция
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const orderVersionQuery = "SELECT version FROM orders WHERE id=$1"
+
+// staleOr выясняет, почему условный UPDATE не изменил заказ: если версия заказа уже не version,
+// возвращается order.ErrStale, иначе errNone
+func staleOr(ctx context.Context, q querier, orderID int64, version int64, errNone error) error {
+	var current int64
+
+	err := q.QueryRowContext(ctx, orderVersionQuery, orderID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return errNone
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "can't get order version")
+	}
+
+	if current != version {
+		return order.ErrStale
+	}
+
+	return errNone
+}
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"safedeal-backend-trainee/pkg/log/logger"
 	"time"
@@ -12,25 +13,41 @@ import (
 type DB struct {
 	Session *sql.DB
 	Logger  logger.Logger
+	// QueryTimeout ограничивает время каждого запроса хранилищ, 0 - без ограничения
+	QueryTimeout time.Duration
+	// url нужен для отдельных соединений, например, для LISTEN
+	url string
 }
 
 func New(logger logger.Logger, filename string) (*DB, error) {
-	URL, err := ParseConfig(filename)
+	c, err := ParseConfig(filename)
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't parse configuration for database")
 	}
 
-	db, err := sql.Open("postgres", URL)
+	db, err := sql.Open("postgres", c.URL())
 	if err != nil {
 		return nil, errors.Wrap(err, "can't open connection to postgres")
 	}
 
 	return &DB{
-		Session: db,
-		Logger:  logger,
+		Session:      db,
+		Logger:       logger,
+		QueryTimeout: c.QueryTimeout.Duration,
+		url:          c.URL(),
 	}, nil
 }
 
+// withTimeout добавляет к контексту запроса срок QueryTimeout. Запрос прерывается,
+// когда истекает срок или отменяется родительский контекст, например, клиент закрыл соединение
+func (d *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d.QueryTimeout)
+}
+
 func (d *DB) CheckConnection() error {
 	var err error
 
@@ -1,7 +1,9 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"safedeal-backend-trainee/internal/domain"
 	"safedeal-backend-trainee/internal/product"
 
 	"github.com/pkg/errors"
@@ -30,19 +32,22 @@ func NewProductStorage(db *DB) (*ProductStorage, error) {
 }
 
 func scanProduct(scanner sqlScanner, p *product.Product) error {
-	return scanner.Scan(&p.ID, &p.Name, &p.Width, &p.Length, &p.Height, &p.Weight, &p.Place)
+	return scanner.Scan(&p.ID, &p.Name, &p.Width, &p.Length, &p.Height, &p.Weight, &p.Place, &p.Price)
 }
 
-const productFields = "name, width, length, height, weight, place"
+const productFields = "name, width, length, height, weight, place, price"
 const findProductByIDQuery = "SELECT id, " + productFields + " FROM products WHERE id=$1"
 
-func (s *ProductStorage) FindByID(id int64) (*product.Product, error) {
+func (s *ProductStorage) FindByID(ctx context.Context, id int64) (*product.Product, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
 	var p product.Product
 
-	row := s.findByIDStmt.QueryRow(id)
+	row := s.bind(ctx, s.findByIDStmt).QueryRowContext(ctx, id)
 	if err := scanProduct(row, &p); err != nil {
 		if err == sql.ErrNoRows {
-			return &p, nil
+			return nil, domain.NotFound("can't find product with id= %v", id)
 		}
 
 		return &p, errors.Wrap(err, "can't scan product")
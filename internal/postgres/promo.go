@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/promo"
+
+	"github.com/pkg/errors"
+)
+
+var _ promo.Storage = &PromoStorage{}
+
+type PromoStorage struct {
+	statementStorage
+
+	findByCodeStmt *sql.Stmt
+	usageStmt      *sql.Stmt
+	lockStmt       *sql.Stmt
+	reserveStmt    *sql.Stmt
+}
+
+func NewPromoStorage(db *DB) (*PromoStorage, error) {
+	s := &PromoStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: findPromoByCodeQuery, Dst: &s.findByCodeStmt},
+		{Query: promoUsageQuery, Dst: &s.usageStmt},
+		{Query: lockPromoQuery, Dst: &s.lockStmt},
+		{Query: reservePromoQuery, Dst: &s.reserveStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+func scanPromo(scanner sqlScanner, c *promo.Code) error {
+	var (
+		validTo   sql.NullTime
+		productID sql.NullInt64
+		zone      sql.NullString
+	)
+
+	err := scanner.Scan(&c.ID, &c.Code, &c.Type, &c.Value, &c.ValidFrom, &validTo,
+		&c.MaxUses, &c.MaxUsesPerBuyer, &c.MinOrderValue, &productID, &zone)
+	if err != nil {
+		return err
+	}
+
+	c.ValidTo = validTo.Time
+	c.ProductID = productID.Int64
+	c.Zone = zone.String
+
+	return nil
+}
+
+const promoFields = "code, type, value, valid_from, valid_to, max_uses, max_uses_per_buyer, " +
+	"min_order_value, product_id, zone"
+const findPromoByCodeQuery = "SELECT id, " + promoFields + " FROM promo_codes WHERE code=$1"
+
+func (s *PromoStorage) FindByCode(ctx context.Context, code string) (*promo.Code, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var c promo.Code
+
+	row := s.findByCodeStmt.QueryRowContext(ctx, code)
+	if err := scanPromo(row, &c); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.NotFound("can't find promo code %q", code)
+		}
+
+		return &c, errors.Wrap(err, "can't scan promo code")
+	}
+
+	return &c, nil
+}
+
+const promoUsageQuery = "SELECT COUNT(*), COUNT(*) FILTER (WHERE buyer=$2) FROM promo_redemptions WHERE code_id=$1"
+
+func (s *PromoStorage) Usage(ctx context.Context, codeID int64, buyer string) (*promo.Usage, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return usage(ctx, s.usageStmt, codeID, buyer)
+}
+
+func usage(ctx context.Context, st *sql.Stmt, codeID int64, buyer string) (*promo.Usage, error) {
+	var u promo.Usage
+
+	if err := st.QueryRowContext(ctx, codeID, buyer).Scan(&u.Total, &u.ByBuyer); err != nil {
+		return nil, errors.Wrap(err, "can't scan promo code usage")
+	}
+
+	return &u, nil
+}
+
+const lockPromoQuery = "SELECT id FROM promo_codes WHERE id=$1 FOR UPDATE"
+const reservePromoQuery = "INSERT INTO promo_redemptions(code_id, order_id, buyer) VALUES ($1, $2, $3) " +
+	"RETURNING id, created_at"
+
+// Reserve выполняется в транзакции UnitOfWork из ctx, без нее - в собственной
+func (s *PromoStorage) Reserve(ctx context.Context, c *promo.Code, buyer string, orderID int64) (*promo.Redemption, error) {
+	var r *promo.Redemption
+
+	err := NewUnitOfWork(s.db).Do(ctx, func(ctx context.Context) error {
+		var err error
+
+		r, err = s.reserve(ctx, c, buyer, orderID)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reserve блокирует строку промокода, поэтому параллельные погашения
+// одного кода проверяют лимиты по очереди
+func (s *PromoStorage) reserve(ctx context.Context, c *promo.Code, buyer string, orderID int64) (*promo.Redemption, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	if err := s.bind(ctx, s.lockStmt).QueryRowContext(ctx, c.ID).Scan(&id); err != nil {
+		return nil, errors.Wrap(err, "can't lock promo code")
+	}
+
+	u, err := usage(ctx, s.bind(ctx, s.usageStmt), c.ID, buyer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.CheckUsage(u); err != nil {
+		return nil, err
+	}
+
+	r := &promo.Redemption{CodeID: c.ID, OrderID: orderID, Buyer: buyer}
+
+	err = s.bind(ctx, s.reserveStmt).QueryRowContext(ctx, c.ID, orderID, buyer).Scan(&r.ID, &r.CreatedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query")
+	}
+
+	return r, nil
+}
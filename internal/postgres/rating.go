@@ -0,0 +1,194 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/rating"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var _ rating.Storage = &RatingStorage{}
+
+type RatingStorage struct {
+	statementStorage
+
+	createStmt      *sql.Stmt
+	couriersStmt    *sql.Stmt
+	courierTagsStmt *sql.Stmt
+	pickupsStmt     *sql.Stmt
+	pickupTagsStmt  *sql.Stmt
+}
+
+func NewRatingStorage(db *DB) (*RatingStorage, error) {
+	s := &RatingStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: createRatingQuery, Dst: &s.createStmt},
+		{Query: courierRatingsQuery, Dst: &s.couriersStmt},
+		{Query: courierRatingTagsQuery, Dst: &s.courierTagsStmt},
+		{Query: pickupRatingsQuery, Dst: &s.pickupsStmt},
+		{Query: pickupRatingTagsQuery, Dst: &s.pickupTagsStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+// createRatingQuery ничего не вставляет, если у заказа уже есть отзыв
+const createRatingQuery = "INSERT INTO ratings(order_id, courier_id, pickup, score, tags, comment, created_at) " +
+	"VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (order_id) DO NOTHING"
+
+func (s *RatingStorage) Create(ctx context.Context, r *rating.Rating) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return execAffected(ctx, s.createStmt, rating.ErrRated, r.OrderID, r.CourierID, r.Pickup, r.Score,
+		pq.Array(r.Tags), r.Comment, r.CreatedAt)
+}
+
+const (
+	courierRatingsQuery = "SELECT courier_id, COUNT(*), AVG(score) FROM ratings " +
+		"WHERE created_at >= $1 GROUP BY courier_id ORDER BY courier_id"
+	courierRatingTagsQuery = "SELECT courier_id, tag, COUNT(*) FROM ratings, unnest(tags) tag " +
+		"WHERE created_at >= $1 GROUP BY courier_id, tag"
+)
+
+func (s *RatingStorage) Couriers(ctx context.Context, from time.Time) ([]*rating.Aggregate, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	aa, err := queryAggregates(ctx, s.couriersStmt, from, func(a *rating.Aggregate) interface{} {
+		return &a.CourierID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[int64]*rating.Aggregate, len(aa))
+	for _, a := range aa {
+		index[a.CourierID] = a
+	}
+
+	err = queryTags(ctx, s.courierTagsStmt, from, func(rows *sql.Rows) (*rating.Aggregate, string, int, error) {
+		var (
+			courierID int64
+			tag       string
+			n         int
+		)
+
+		err := rows.Scan(&courierID, &tag, &n)
+
+		return index[courierID], tag, n, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aa, nil
+}
+
+const (
+	pickupRatingsQuery = "SELECT pickup, COUNT(*), AVG(score) FROM ratings " +
+		"WHERE created_at >= $1 GROUP BY pickup ORDER BY pickup"
+	pickupRatingTagsQuery = "SELECT pickup, tag, COUNT(*) FROM ratings, unnest(tags) tag " +
+		"WHERE created_at >= $1 GROUP BY pickup, tag"
+)
+
+func (s *RatingStorage) Pickups(ctx context.Context, from time.Time) ([]*rating.Aggregate, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	aa, err := queryAggregates(ctx, s.pickupsStmt, from, func(a *rating.Aggregate) interface{} {
+		return &a.Pickup
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*rating.Aggregate, len(aa))
+	for _, a := range aa {
+		index[a.Pickup] = a
+	}
+
+	err = queryTags(ctx, s.pickupTagsStmt, from, func(rows *sql.Rows) (*rating.Aggregate, string, int, error) {
+		var (
+			pickup string
+			tag    string
+			n      int
+		)
+
+		err := rows.Scan(&pickup, &tag, &n)
+
+		return index[pickup], tag, n, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aa, nil
+}
+
+// queryAggregates читает число и среднее отзывов, key возвращает поле, в которое читается ключ группы
+func queryAggregates(ctx context.Context, st *sql.Stmt, from time.Time,
+	key func(a *rating.Aggregate) interface{}) ([]*rating.Aggregate, error) {
+	rows, err := st.QueryContext(ctx, from)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get ratings")
+	}
+
+	defer rows.Close()
+
+	aa := make([]*rating.Aggregate, 0)
+
+	for rows.Next() {
+		a := &rating.Aggregate{Tags: make(map[string]int)}
+
+		if err = rows.Scan(key(a), &a.Count, &a.Average); err != nil {
+			return nil, errors.Wrap(err, "can't scan row with rating")
+		}
+
+		a.Average = rating.Round(a.Average)
+		aa = append(aa, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return aa, nil
+}
+
+// queryTags дописывает к оценкам число отзывов с каждым тегом
+func queryTags(ctx context.Context, st *sql.Stmt, from time.Time,
+	scan func(rows *sql.Rows) (*rating.Aggregate, string, int, error)) error {
+	rows, err := st.QueryContext(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "can't exec query to get rating tags")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		a, tag, n, err := scan(rows)
+		if err != nil {
+			return errors.Wrap(err, "can't scan row with rating tag")
+		}
+
+		// отзыв мог появиться между запросами
+		if a != nil {
+			a.Tags[tag] = n
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "rows contain error")
+	}
+
+	return nil
+}
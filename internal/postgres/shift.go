@@ -0,0 +1,316 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/shift"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var _ shift.Storage = &ShiftStorage{}
+
+type ShiftStorage struct {
+	statementStorage
+
+	createStmt     *sql.Stmt
+	findByIDStmt   *sql.Stmt
+	currentStmt    *sql.Stmt
+	upcomingStmt   *sql.Stmt
+	plannedStmt    *sql.Stmt
+	breaksStmt     *sql.Stmt
+	clockInStmt    *sql.Stmt
+	clockOutStmt   *sql.Stmt
+	closeBreakStmt *sql.Stmt
+	startBreakStmt *sql.Stmt
+}
+
+func NewShiftStorage(db *DB) (*ShiftStorage, error) {
+	s := &ShiftStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: createShiftQuery, Dst: &s.createStmt},
+		{Query: findShiftByIDQuery, Dst: &s.findByIDStmt},
+		{Query: currentShiftQuery, Dst: &s.currentStmt},
+		{Query: upcomingShiftsQuery, Dst: &s.upcomingStmt},
+		{Query: plannedShiftsQuery, Dst: &s.plannedStmt},
+		{Query: shiftBreaksQuery, Dst: &s.breaksStmt},
+		{Query: clockInQuery, Dst: &s.clockInStmt},
+		{Query: clockOutQuery, Dst: &s.clockOutStmt},
+		{Query: closeBreakQuery, Dst: &s.closeBreakStmt},
+		{Query: startBreakQuery, Dst: &s.startBreakStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+func scanShift(scanner sqlScanner, s *shift.Shift) error {
+	var clockIn, clockOut sql.NullTime
+
+	err := scanner.Scan(&s.ID, &s.CourierID, pq.Array(&s.Zones), &s.Start, &s.End, &clockIn, &clockOut)
+	if err != nil {
+		return err
+	}
+
+	if clockIn.Valid {
+		s.ClockIn = &clockIn.Time
+	}
+
+	if clockOut.Valid {
+		s.ClockOut = &clockOut.Time
+	}
+
+	return nil
+}
+
+const shiftFields = "courier_id, zones, starts_at, ends_at, clock_in, clock_out"
+const createShiftQuery = "INSERT INTO shifts(courier_id, zones, starts_at, ends_at) VALUES ($1, $2, $3, $4) RETURNING id"
+
+func (s *ShiftStorage) Create(ctx context.Context, sh *shift.Shift) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	row := s.createStmt.QueryRowContext(ctx, sh.CourierID, pq.Array(sh.Zones), sh.Start, sh.End)
+	if err := row.Scan(&sh.ID); err != nil {
+		return errors.Wrap(err, "can't exec query")
+	}
+
+	return nil
+}
+
+const findShiftByIDQuery = "SELECT id, " + shiftFields + " FROM shifts WHERE id=$1"
+
+func (s *ShiftStorage) FindByID(ctx context.Context, id int64) (*shift.Shift, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return s.findOne(ctx, s.findByIDStmt, domain.NotFound("can't find shift with id= %v", id), id)
+}
+
+// findOne возвращает errNone, если смена не найдена
+func (s *ShiftStorage) findOne(ctx context.Context, st *sql.Stmt, errNone error,
+	args ...interface{}) (*shift.Shift, error) {
+	var sh shift.Shift
+
+	if err := scanShift(st.QueryRowContext(ctx, args...), &sh); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errNone
+		}
+
+		return nil, errors.Wrap(err, "can't scan shift")
+	}
+
+	if err := s.fillBreaks(ctx, &sh); err != nil {
+		return &sh, err
+	}
+
+	return &sh, nil
+}
+
+// currentShiftQuery в первую очередь возвращает смену, на которой курьер уже отметился
+const currentShiftQuery = "SELECT id, " + shiftFields + " FROM shifts " +
+	"WHERE courier_id=$1 AND clock_out IS NULL AND " +
+	"(clock_in IS NOT NULL OR (starts_at <= $3 AND ends_at > $2)) " +
+	"ORDER BY clock_in IS NULL, starts_at LIMIT 1"
+
+func (s *ShiftStorage) Current(ctx context.Context, courierID int64, at time.Time,
+	early time.Duration) (*shift.Shift, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return s.findOne(ctx, s.currentStmt, shift.ErrNoShift, courierID, at, at.Add(early))
+}
+
+const upcomingShiftsQuery = "SELECT id, " + shiftFields + " FROM shifts " +
+	"WHERE courier_id=$1 AND ends_at > $2 ORDER BY starts_at"
+
+func (s *ShiftStorage) Upcoming(ctx context.Context, courierID int64, from time.Time) ([]*shift.Shift, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.upcomingStmt.QueryContext(ctx, courierID, from)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get upcoming shifts")
+	}
+
+	shifts, err := scanShifts(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sh := range shifts {
+		if err := s.fillBreaks(ctx, sh); err != nil {
+			return nil, err
+		}
+	}
+
+	return shifts, nil
+}
+
+const plannedShiftsQuery = "SELECT id, " + shiftFields + " FROM shifts " +
+	"WHERE starts_at < $2 AND ends_at > $1 ORDER BY starts_at"
+
+func (s *ShiftStorage) Planned(ctx context.Context, from time.Time, to time.Time) ([]*shift.Shift, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.plannedStmt.QueryContext(ctx, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get planned shifts")
+	}
+
+	return scanShifts(rows)
+}
+
+func scanShifts(rows *sql.Rows) ([]*shift.Shift, error) {
+	defer rows.Close()
+
+	var err error
+
+	shifts := make([]*shift.Shift, 0)
+
+	for rows.Next() {
+		var sh shift.Shift
+
+		if err = scanShift(rows, &sh); err != nil {
+			return nil, errors.Wrap(err, "can't scan row with shift")
+		}
+
+		shifts = append(shifts, &sh)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return shifts, nil
+}
+
+const shiftBreaksQuery = "SELECT started_at, ended_at FROM shift_breaks WHERE shift_id=$1 ORDER BY started_at"
+
+func (s *ShiftStorage) fillBreaks(ctx context.Context, sh *shift.Shift) error {
+	rows, err := s.breaksStmt.QueryContext(ctx, sh.ID)
+	if err != nil {
+		return errors.Wrap(err, "can't exec query to get shift breaks")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			b   shift.Break
+			end sql.NullTime
+		)
+
+		if err = rows.Scan(&b.Start, &end); err != nil {
+			return errors.Wrap(err, "can't scan row with shift break")
+		}
+
+		if end.Valid {
+			b.End = &end.Time
+		}
+
+		sh.Breaks = append(sh.Breaks, &b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "rows contain error")
+	}
+
+	return nil
+}
+
+// onActiveShift - условие для курьера c: он отметился на смене в зоне $1 и не на перерыве.
+// Если курьер забыл отметить уход, смена перестает считаться активной через shiftGrace после
+// запланированного окончания
+const onActiveShift = "EXISTS (SELECT 1 FROM shifts s WHERE s.courier_id = c.id AND $1 = ANY(s.zones) " +
+	"AND s.clock_in IS NOT NULL AND s.clock_out IS NULL AND now() < s.ends_at + " + shiftGrace + " " +
+	"AND NOT EXISTS (SELECT 1 FROM shift_breaks b WHERE b.shift_id = s.id AND b.ended_at IS NULL))"
+
+// shiftGrace - сколько после запланированного окончания смены курьер может работать без отметки об уходе
+const shiftGrace = "interval '1 hour'"
+
+const clockInQuery = "UPDATE shifts SET clock_in=$2 WHERE id=$1 AND clock_in IS NULL"
+
+func (s *ShiftStorage) ClockIn(ctx context.Context, shiftID int64, at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return execAffected(ctx, s.clockInStmt, shift.ErrClockedIn, shiftID, at)
+}
+
+const clockOutQuery = "UPDATE shifts SET clock_out=$2 WHERE id=$1 AND clock_in IS NOT NULL AND clock_out IS NULL"
+const closeBreakQuery = "UPDATE shift_breaks SET ended_at=$2 WHERE shift_id=$1 AND ended_at IS NULL"
+
+// ClockOut заодно завершает незакрытый перерыв
+func (s *ShiftStorage) ClockOut(ctx context.Context, shiftID int64, at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.Session.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if err = execAffected(ctx, tx.StmtContext(ctx, s.clockOutStmt), shift.ErrNotClockedIn, shiftID, at); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.StmtContext(ctx, s.closeBreakStmt).ExecContext(ctx, shiftID, at); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "can't close shift break")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+
+	return nil
+}
+
+// startBreakQuery не открывает второй перерыв благодаря уникальному индексу
+// на незакрытые перерывы смены
+const startBreakQuery = "INSERT INTO shift_breaks(shift_id, started_at) " +
+	"SELECT id, $2 FROM shifts WHERE id=$1 AND clock_in IS NOT NULL AND clock_out IS NULL " +
+	"ON CONFLICT (shift_id) WHERE ended_at IS NULL DO NOTHING"
+
+func (s *ShiftStorage) StartBreak(ctx context.Context, shiftID int64, at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return execAffected(ctx, s.startBreakStmt, shift.ErrOnBreak, shiftID, at)
+}
+
+func (s *ShiftStorage) EndBreak(ctx context.Context, shiftID int64, at time.Time) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	return execAffected(ctx, s.closeBreakStmt, shift.ErrNotOnBreak, shiftID, at)
+}
+
+// execAffected выполняет запрос и возвращает errNone, если он не изменил ни одной строки
+func execAffected(ctx context.Context, st *sql.Stmt, errNone error, args ...interface{}) error {
+	res, err := st.ExecContext(ctx, args...)
+	if err != nil {
+		return errors.Wrap(err, "can't exec query")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "can't get affected rows")
+	}
+
+	if n == 0 {
+		return errNone
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/slot"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ slot.Storage = &SlotStorage{}
+
+type SlotStorage struct {
+	statementStorage
+
+	reservationsStmt *sql.Stmt
+	reserveStmt      *sql.Stmt
+}
+
+func NewSlotStorage(db *DB) (*SlotStorage, error) {
+	s := &SlotStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: slotReservationsQuery, Dst: &s.reservationsStmt},
+		{Query: reserveSlotQuery, Dst: &s.reserveStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+const slotReservationsQuery = "SELECT slot_start, reserved FROM slot_reservations " +
+	"WHERE zone=$1 AND slot_start >= $2 AND slot_start < $3"
+
+func (s *SlotStorage) Reservations(ctx context.Context, zone string, from time.Time,
+	to time.Time) ([]*slot.Reservation, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.reservationsStmt.QueryContext(ctx, zone, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't exec query to get slot reservations")
+	}
+
+	defer rows.Close()
+
+	rr := make([]*slot.Reservation, 0)
+
+	for rows.Next() {
+		var r slot.Reservation
+
+		err = rows.Scan(&r.Start, &r.Reserved)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't scan row with slot reservation")
+		}
+
+		rr = append(rr, &r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "rows contain error")
+	}
+
+	return rr, nil
+}
+
+// reserveSlotQuery не возвращает строк, если интервал уже заполнен,
+// поэтому проверка и увеличение счетчика выполняются одной операцией
+const reserveSlotQuery = "INSERT INTO slot_reservations(zone, slot_start, reserved) VALUES ($1, $2, 1) " +
+	"ON CONFLICT (zone, slot_start) DO UPDATE SET reserved = slot_reservations.reserved + 1 " +
+	"WHERE slot_reservations.reserved < $3 RETURNING reserved"
+
+func (s *SlotStorage) Reserve(ctx context.Context, zone string, start time.Time, capacity int) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	if capacity <= 0 {
+		return slot.ErrFull
+	}
+
+	var reserved int
+
+	if err := s.bind(ctx, s.reserveStmt).QueryRowContext(ctx, zone, start, capacity).Scan(&reserved); err != nil {
+		if err == sql.ErrNoRows {
+			return slot.ErrFull
+		}
+
+		return errors.Wrap(err, "can't exec query")
+	}
+
+	return nil
+}
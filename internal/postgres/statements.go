@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/pkg/errors"
@@ -43,3 +44,12 @@ func (s *statementStorage) initStatements(statements []stmt) error {
 
 	return nil
 }
+
+// bind привязывает подготовленный запрос к транзакции UnitOfWork, если ctx выполняется в ней
+func (s *statementStorage) bind(ctx context.Context, st *sql.Stmt) *sql.Stmt {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx.StmtContext(ctx, st)
+	}
+
+	return st
+}
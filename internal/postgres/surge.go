@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/surge"
+
+	"github.com/pkg/errors"
+)
+
+var _ surge.Storage = &SurgeStorage{}
+
+type SurgeStorage struct {
+	statementStorage
+
+	findStmt   *sql.Stmt
+	saveStmt   *sql.Stmt
+	deleteStmt *sql.Stmt
+}
+
+func NewSurgeStorage(db *DB) (*SurgeStorage, error) {
+	s := &SurgeStorage{statementStorage: newStatementsStorage(db)}
+
+	stmts := []stmt{
+		{Query: findSurgeOverrideQuery, Dst: &s.findStmt},
+		{Query: saveSurgeOverrideQuery, Dst: &s.saveStmt},
+		{Query: deleteSurgeOverrideQuery, Dst: &s.deleteStmt},
+	}
+
+	if err := s.initStatements(stmts); err != nil {
+		return nil, errors.Wrap(err, "can't init statements")
+	}
+
+	return s, nil
+}
+
+const surgeOverrideFields = "zone, mode, multiplier, expires_at, created_at"
+const findSurgeOverrideQuery = "SELECT " + surgeOverrideFields + " FROM surge_overrides WHERE zone=$1"
+
+func (s *SurgeStorage) FindOverride(ctx context.Context, zone string) (*surge.Override, error) {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	var (
+		o         surge.Override
+		expiresAt sql.NullTime
+	)
+
+	row := s.findStmt.QueryRowContext(ctx, zone)
+	if err := row.Scan(&o.Zone, &o.Mode, &o.Multiplier, &expiresAt, &o.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.NotFound("can't find surge override for zone %q", zone)
+		}
+
+		return nil, errors.Wrap(err, "can't scan surge override")
+	}
+
+	if expiresAt.Valid {
+		o.ExpiresAt = &expiresAt.Time
+	}
+
+	return &o, nil
+}
+
+const saveSurgeOverrideQuery = "INSERT INTO surge_overrides(zone, mode, multiplier, expires_at) " +
+	"VALUES ($1, $2, $3, $4) ON CONFLICT (zone) DO UPDATE " +
+	"SET mode=EXCLUDED.mode, multiplier=EXCLUDED.multiplier, expires_at=EXCLUDED.expires_at, created_at=now() " +
+	"RETURNING created_at"
+
+func (s *SurgeStorage) SaveOverride(ctx context.Context, o *surge.Override) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	row := s.saveStmt.QueryRowContext(ctx, o.Zone, o.Mode, o.Multiplier, o.ExpiresAt)
+	if err := row.Scan(&o.CreatedAt); err != nil {
+		return errors.Wrap(err, "can't exec query")
+	}
+
+	return nil
+}
+
+const deleteSurgeOverrideQuery = "DELETE FROM surge_overrides WHERE zone=$1"
+
+func (s *SurgeStorage) DeleteOverride(ctx context.Context, zone string) error {
+	ctx, cancel := s.db.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.deleteStmt.ExecContext(ctx, zone); err != nil {
+		return errors.Wrap(err, "can't exec query")
+	}
+
+	return nil
+}
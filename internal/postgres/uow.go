@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"safedeal-backend-trainee/internal/domain"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var _ domain.UnitOfWork = &UnitOfWork{}
+
+// maxTxAttempts - сколько раз выполняется транзакция, которая не прошла из-за конкурентных изменений
+const maxTxAttempts = 3
+
+// retryCodes - коды ошибок PostgreSQL, после которых транзакцию можно повторить:
+// serialization_failure и deadlock_detected
+var retryCodes = map[pq.ErrorCode]bool{
+	"40001": true,
+	"40P01": true,
+}
+
+type txKey struct{}
+
+// UnitOfWork выполняет операции ProductStorage и OrderStorage в одной транзакции с уровнем
+// изоляции SERIALIZABLE. Хранилища находят транзакцию в контексте, переданном в f
+type UnitOfWork struct {
+	db *DB
+}
+
+func NewUnitOfWork(db *DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+// Do выполняет f в транзакции и повторяет ее, если она не прошла из-за конкурентных изменений
+func (u *UnitOfWork) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return f(ctx)
+	}
+
+	var err error
+
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		if err = u.do(ctx, f); !retryable(err) {
+			return err
+		}
+
+		u.db.Logger.Warnf("Attempt %d: transaction is not serializable, retry: %v", attempt, err)
+	}
+
+	return err
+}
+
+func (u *UnitOfWork) do(ctx context.Context, f func(ctx context.Context) error) error {
+	tx, err := u.db.Session.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if err = f(domain.WithUnitOfWork(context.WithValue(ctx, txKey{}, tx))); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+
+	return nil
+}
+
+func retryable(err error) bool {
+	var e *pq.Error
+
+	return errors.As(err, &e) && retryCodes[e.Code]
+}
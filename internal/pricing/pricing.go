@@ -0,0 +1,67 @@
+package pricing
+
+import (
+	"math"
+)
+
+type Configuration struct {
+	Base  int     `json:"base"`
+	PerKg float64 `json:"per_kg"`
+	Step  int     `json:"step"`
+}
+
+var DefaultConfiguration = Configuration{
+	Base:  300,
+	PerKg: 20,
+	Step:  10,
+}
+
+type Breakdown struct {
+	Base     int     `json:"base"`
+	Surge    float64 `json:"surge"`
+	SurgeFee int     `json:"surge_fee"`
+	Discount int     `json:"discount"`
+	Total    int     `json:"total"`
+}
+
+// ApplySurge добавляет к цене надбавку за повышенный спрос (m - повышающий коэффициент)
+func (b *Breakdown) ApplySurge(m float64) {
+	b.Surge = m
+	b.SurgeFee = int(math.Round(float64(b.Base) * (m - 1)))
+	b.Total = b.Base + b.SurgeFee - b.Discount
+}
+
+// ApplyDiscount уменьшает итоговую цену, но не ниже нуля
+func (b *Breakdown) ApplyDiscount(d int) {
+	if d > b.Total {
+		d = b.Total
+	}
+
+	b.Discount += d
+	b.Total -= d
+}
+
+type Calculator struct {
+	config Configuration
+}
+
+func New(c Configuration) *Calculator {
+	return &Calculator{config: c}
+}
+
+// Calculate возвращает стоимость доставки товара весом weight (цена округляется вверх до шага Step).
+// Расстояние в цене не учитывается: координаты адресов дает заглушка геокодера
+func (c *Calculator) Calculate(weight float32) *Breakdown {
+	price := float64(c.config.Base) + c.config.PerKg*float64(weight)
+
+	base := int(math.Ceil(price))
+	if step := c.config.Step; step > 1 {
+		base = (base + step - 1) / step * step
+	}
+
+	return &Breakdown{
+		Base:  base,
+		Surge: 1,
+		Total: base,
+	}
+}
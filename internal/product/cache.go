@@ -0,0 +1,218 @@
+package product
+
+import (
+	"container/list"
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type CacheConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// Size - сколько товаров хранится в кэше, при переполнении вытесняются давно запрошенные
+	Size int `json:"size"`
+	// TTL - сколько товар хранится в кэше, если о его изменении не пришло уведомление
+	TTL ftime.Duration `json:"ttl"`
+	// Shared - хранить товары еще и в общем для экземпляров сервиса хранилище kv
+	Shared bool `json:"shared"`
+}
+
+var DefaultCacheConfiguration = CacheConfiguration{
+	Enabled: true,
+	Size:    1000,
+	TTL:     ftime.Duration{Duration: 5 * time.Minute},
+}
+
+// Invalidator сбрасывает закэшированные товары, когда они меняются
+type Invalidator interface {
+	// Invalidate сбрасывает товар с ID id
+	Invalidate(ctx context.Context, id int64) error
+	// Purge сбрасывает все товары, например, если уведомления об изменениях могли потеряться
+	Purge(ctx context.Context) error
+}
+
+// CacheStats - счетчики обращений к кэшу с момента запуска. Shared - счетчики общего кэша,
+// к которому обращается кэш в памяти процесса при промахе
+type CacheStats struct {
+	Hits      int64       `json:"hits"`
+	Misses    int64       `json:"misses"`
+	Evictions int64       `json:"evictions"`
+	Errors    int64       `json:"errors,omitempty"`
+	Size      int         `json:"size"`
+	Shared    *CacheStats `json:"shared,omitempty"`
+}
+
+var (
+	_ Storage     = &Cache{}
+	_ Invalidator = &Cache{}
+)
+
+// Cache читает товары из хранилища и хранит последние запрошенные в памяти процесса.
+// В транзакции UnitOfWork товар читается из хранилища, чтобы транзакция проверяла актуальные данные.
+// Хранилищем может быть SharedCache, тогда Cache сбрасывает товары и в нем
+type Cache struct {
+	storage Storage
+	config  CacheConfiguration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[int64]*list.Element
+	lru     *list.List
+	// generation увеличивается при каждом сбросе, чтобы товар, прочитанный из хранилища
+	// до сброса, не попал в кэш после него
+	generation uint64
+
+	hits, misses, evictions int64
+}
+
+type cacheEntry struct {
+	product   Product
+	expiresAt time.Time
+}
+
+func NewCache(s Storage, c CacheConfiguration) *Cache {
+	return &Cache{
+		storage: s,
+		config:  c,
+		now:     time.Now,
+		entries: make(map[int64]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// FindByID возвращает копию товара, поэтому изменения у вызывающего не попадают в кэш
+func (c *Cache) FindByID(ctx context.Context, id int64) (*Product, error) {
+	if domain.InUnitOfWork(ctx) {
+		return c.storage.FindByID(ctx, id)
+	}
+
+	if p, ok := c.get(id); ok {
+		atomic.AddInt64(&c.hits, 1)
+		return p, nil
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+
+	generation := c.currentGeneration()
+
+	p, err := c.storage.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(p, generation)
+
+	return p, nil
+}
+
+func (c *Cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+func (c *Cache) get(id int64) (*Product, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	p := e.product
+
+	return &p, true
+}
+
+// put кэширует товар, прочитанный в поколении generation. Если с тех пор кэш сбрасывали,
+// товар мог устареть и не кэшируется
+func (c *Cache) put(p *Product, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	e := &cacheEntry{product: *p, expiresAt: c.now().Add(c.config.TTL.Duration)}
+
+	if el, ok := c.entries[p.ID]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+
+		return
+	}
+
+	c.entries[p.ID] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.config.Size {
+		c.remove(c.lru.Back())
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).product.ID)
+}
+
+func (c *Cache) Invalidate(ctx context.Context, id int64) error {
+	c.mu.Lock()
+	c.generation++
+	if el, ok := c.entries[id]; ok {
+		c.remove(el)
+	}
+	c.mu.Unlock()
+
+	if inv, ok := c.storage.(Invalidator); ok {
+		return inv.Invalidate(ctx, id)
+	}
+
+	return nil
+}
+
+func (c *Cache) Purge(ctx context.Context) error {
+	c.mu.Lock()
+	c.generation++
+	c.entries = make(map[int64]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+
+	if inv, ok := c.storage.(Invalidator); ok {
+		return inv.Purge(ctx)
+	}
+
+	return nil
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	stats := CacheStats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Evictions: atomic.LoadInt64(&c.evictions),
+		Size:      size,
+	}
+
+	if shared, ok := c.storage.(*SharedCache); ok {
+		s := shared.Stats()
+		stats.Shared = &s
+	}
+
+	return stats
+}
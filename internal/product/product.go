@@ -1,5 +1,7 @@
 package product
 
+import "context"
+
 type Product struct {
 	ID     int64   `json:"id,omitempty"`
 	Name   string  `json:"name"`
@@ -8,8 +10,10 @@ type Product struct {
 	Height float32 `json:"height"`
 	Weight float32 `json:"weight"`
 	Place  string  `json:"place"`
+	Price  int     `json:"price,omitempty"`
 }
 
 type Storage interface {
-	FindByID(id int64) (*Product, error)
+	// FindByID возвращает ошибку вида domain.ErrNotFound, если товара нет
+	FindByID(ctx context.Context, id int64) (*Product, error)
 }
@@ -0,0 +1,114 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/kv"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// generationKey - поколение общего кэша. Purge увеличивает его, и записи прошлых поколений
+// перестают читаться без перебора ключей
+const generationKey = "product:generation"
+
+var (
+	_ Storage     = &SharedCache{}
+	_ Invalidator = &SharedCache{}
+)
+
+// SharedCache хранит товары в kv.Store, общем для экземпляров сервиса. Если хранилище kv недоступно,
+// товар читается из хранилища товаров, а ошибка учитывается в статистике
+type SharedCache struct {
+	storage Storage
+	store   kv.Store
+	ttl     time.Duration
+
+	hits, misses, errors int64
+}
+
+// sharedEntry - товар, прочитанный при поколении кэша Generation и версии товара Version.
+// Invalidate увеличивает версию, поэтому запись, сохраненная читателем, который начал
+// чтение до сброса, не совпадает с новой версией и не читается
+type sharedEntry struct {
+	Generation int64   `json:"generation"`
+	Version    int64   `json:"version"`
+	Product    Product `json:"product"`
+}
+
+func NewSharedCache(s Storage, store kv.Store, ttl time.Duration) *SharedCache {
+	return &SharedCache{storage: s, store: store, ttl: ttl}
+}
+
+func (c *SharedCache) FindByID(ctx context.Context, id int64) (*Product, error) {
+	if domain.InUnitOfWork(ctx) {
+		return c.storage.FindByID(ctx, id)
+	}
+
+	values, err := c.store.Get(ctx, generationKey, versionKey(id), sharedKey(id))
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		return c.storage.FindByID(ctx, id)
+	}
+
+	generation, _ := strconv.ParseInt(string(values[0]), 10, 64)
+	version, _ := strconv.ParseInt(string(values[1]), 10, 64)
+
+	var e sharedEntry
+	if values[2] != nil && json.Unmarshal(values[2], &e) == nil &&
+		e.Generation == generation && e.Version == version {
+		atomic.AddInt64(&c.hits, 1)
+		return &e.Product, nil
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+
+	p, err := c.storage.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(sharedEntry{Generation: generation, Version: version, Product: *p})
+	if err == nil {
+		err = c.store.Set(ctx, sharedKey(id), b, c.ttl)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+	}
+
+	return p, nil
+}
+
+// Invalidate увеличивает версию товара и удаляет его запись. Версия хранится без ttl:
+// если бы она истекла раньше записи, устаревшая запись снова совпала бы с версией
+func (c *SharedCache) Invalidate(ctx context.Context, id int64) error {
+	if _, err := c.store.Incr(ctx, versionKey(id), 0); err != nil {
+		return err
+	}
+
+	return c.store.Del(ctx, sharedKey(id))
+}
+
+func (c *SharedCache) Purge(ctx context.Context) error {
+	_, err := c.store.Incr(ctx, generationKey, 0)
+	return err
+}
+
+func (c *SharedCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+		Errors: atomic.LoadInt64(&c.errors),
+	}
+}
+
+func sharedKey(id int64) string {
+	return "product:" + strconv.FormatInt(id, 10)
+}
+
+func versionKey(id int64) string {
+	return sharedKey(id) + ":version"
+}
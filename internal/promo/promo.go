@@ -0,0 +1,128 @@
+package promo
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"time"
+)
+
+type Type string
+
+const (
+	Fixed        Type = "fixed"
+	Percent      Type = "percent"
+	FreeDelivery Type = "free_delivery"
+)
+
+type Code struct {
+	ID              int64
+	Code            string
+	Type            Type
+	Value           int // рубли для Fixed, проценты для Percent
+	ValidFrom       time.Time
+	ValidTo         time.Time
+	MaxUses         int // 0 - без ограничений
+	MaxUsesPerBuyer int // 0 - без ограничений
+	MinOrderValue   int
+	ProductID       int64  // 0 - любой товар
+	Zone            string // "" - любая зона
+}
+
+type Redemption struct {
+	ID        int64
+	CodeID    int64
+	OrderID   int64
+	Buyer     string
+	CreatedAt time.Time
+}
+
+type Usage struct {
+	Total   int
+	ByBuyer int
+}
+
+type Storage interface {
+	// FindByCode возвращает ошибку вида domain.ErrNotFound, если промокода нет
+	FindByCode(ctx context.Context, code string) (*Code, error)
+	Usage(ctx context.Context, codeID int64, buyer string) (*Usage, error)
+	// Reserve атомарно проверяет лимиты использования и создает погашение для заказа.
+	// Если ctx выполняется в UnitOfWork, погашение откатывается вместе с заказом
+	Reserve(ctx context.Context, c *Code, buyer string, orderID int64) (*Redemption, error)
+}
+
+var (
+	ErrNotActive          = domain.Invalid("promo code is not active")
+	ErrBuyerRequired      = domain.Invalid("promo code requires buyer")
+	ErrMinOrderValue      = domain.Invalid("order value is less than promo code minimum")
+	ErrProductRestriction = domain.Invalid("promo code is not applicable to this product")
+	ErrZoneRestriction    = domain.Invalid("promo code is not applicable to this delivery zone")
+	ErrUsageLimit         = domain.Invalid("promo code usage limit is reached")
+	ErrBuyerUsageLimit    = domain.Invalid("promo code usage limit for buyer is reached")
+)
+
+// Request описывает заказ, к которому применяется промокод
+type Request struct {
+	ProductID  int64
+	Zone       string
+	OrderValue int
+	Buyer      string
+	At         time.Time
+}
+
+// Check проверяет, что промокод применим к заказу (без учета лимитов использования)
+func (c *Code) Check(r *Request) error {
+	if r.At.Before(c.ValidFrom) || (!c.ValidTo.IsZero() && !r.At.Before(c.ValidTo)) {
+		return ErrNotActive
+	}
+
+	if c.MaxUsesPerBuyer > 0 && r.Buyer == "" {
+		return ErrBuyerRequired
+	}
+
+	if r.OrderValue < c.MinOrderValue {
+		return ErrMinOrderValue
+	}
+
+	if c.ProductID != 0 && c.ProductID != r.ProductID {
+		return ErrProductRestriction
+	}
+
+	if c.Zone != "" && c.Zone != r.Zone {
+		return ErrZoneRestriction
+	}
+
+	return nil
+}
+
+// CheckUsage проверяет лимиты использования промокода
+func (c *Code) CheckUsage(u *Usage) error {
+	if c.MaxUses > 0 && u.Total >= c.MaxUses {
+		return ErrUsageLimit
+	}
+
+	if c.MaxUsesPerBuyer > 0 && u.ByBuyer >= c.MaxUsesPerBuyer {
+		return ErrBuyerUsageLimit
+	}
+
+	return nil
+}
+
+// Discount возвращает размер скидки для стоимости доставки price
+func (c *Code) Discount(price int) int {
+	var d int
+
+	switch c.Type {
+	case Fixed:
+		d = c.Value
+	case Percent:
+		d = price * c.Value / 100 // nolint: gomnd
+	case FreeDelivery:
+		d = price
+	}
+
+	if d > price {
+		return price
+	}
+
+	return d
+}
@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/kv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Anonymous - роль в правилах для запросов без API-ключа
+const Anonymous = "anonymous"
+
+// Rule - ограничение числа запросов одного клиента. Клиент - владелец API-ключа,
+// а для запросов без ключа - IP-адрес
+type Rule struct {
+	// Name отделяет счетчики правила от счетчиков других правил
+	Name string `json:"name"`
+	// Method и Path ограничивают правило маршрутом. {параметр} в пути совпадает с любым сегментом,
+	// * в конце пути - с любым продолжением. Пустые Method и Path подходят для всех запросов
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Roles - роли API-ключей, для которых действует правило, пустой список - все роли и Anonymous
+	Roles  []string       `json:"roles"`
+	Limit  int            `json:"limit"`
+	Window ftime.Duration `json:"window"`
+	// Burst - сколько запросов сверх Limit можно сделать в окне за счет запросов,
+	// не сделанных в прошлом окне
+	Burst int `json:"burst"`
+}
+
+type Configuration struct {
+	// Rules проверяются по порядку, к запросу применяется первое подходящее правило.
+	// Запросы, для которых правила нет, не ограничиваются
+	Rules []Rule `json:"rules"`
+	// FailedAuth ограничивает неудачные попытки аутентификации с одного IP-адреса, чтобы API-ключи
+	// нельзя было подбирать. Method, Path и Roles не используются, нулевой Limit отключает ограничение
+	FailedAuth Rule `json:"failed_auth"`
+}
+
+var DefaultConfiguration = Configuration{
+	Rules:      []Rule{{Name: "default", Limit: 10, Window: ftime.Duration{Duration: time.Minute}}},
+	FailedAuth: Rule{Name: "failed-auth", Limit: 10, Window: ftime.Duration{Duration: time.Minute}},
+}
+
+// Validate проверяет, что у правил есть лимиты и разные имена
+func (c Configuration) Validate() error {
+	names := make(map[string]bool, len(c.Rules)+1)
+
+	rules := c.Rules
+	if c.FailedAuth.Limit != 0 {
+		rules = append(rules[:len(rules):len(rules)], c.FailedAuth)
+	}
+
+	for _, r := range rules {
+		if r.Name == "" || names[r.Name] {
+			return errors.Errorf("rate limit rule name %q is empty or duplicated", r.Name)
+		}
+
+		names[r.Name] = true
+
+		if r.Limit <= 0 || r.Window.Duration <= 0 || r.Burst < 0 {
+			return errors.Errorf("rate limit rule %q must have positive limit and window", r.Name)
+		}
+	}
+
+	return nil
+}
+
+// Decision - результат проверки запроса. Limit - сколько запросов можно сделать в текущем окне
+// с учетом Burst, Reset - начало следующего окна
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+type Limiter struct {
+	config   Configuration
+	counters kv.Store
+	now      func() time.Time
+}
+
+// New создает ограничитель со счетчиками в counters. Если counters общие для экземпляров
+// сервиса, то и ограничение общее
+func New(c Configuration, counters kv.Store) *Limiter {
+	return &Limiter{config: c, counters: counters, now: time.Now}
+}
+
+// Match возвращает первое правило для запроса method path от владельца роли role или nil
+func (l *Limiter) Match(method string, path string, role string) *Rule {
+	for i := range l.config.Rules {
+		r := &l.config.Rules[i]
+
+		if r.matches(method, path, role) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// FailedAuth возвращает правило для неудачных попыток аутентификации или nil, если оно отключено
+func (l *Limiter) FailedAuth() *Rule {
+	if l.config.FailedAuth.Limit == 0 {
+		return nil
+	}
+
+	return &l.config.FailedAuth
+}
+
+// Allow учитывает запрос клиента client по правилу r. Запрос, отклоненный ограничением, не учитывается
+func (l *Limiter) Allow(ctx context.Context, r *Rule, client string) (*Decision, error) {
+	d, err := l.Check(ctx, r, client)
+	if err != nil || !d.Allowed {
+		return d, err
+	}
+
+	n, err := l.count(ctx, r, client)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Allowed = int(n) <= d.Limit
+	d.Remaining = max(0, d.Limit-int(n))
+
+	return d, nil
+}
+
+// Check проверяет, не исчерпал ли клиент client лимит правила r, но не учитывает запрос.
+// Вместе с Count так ограничиваются только неудачные запросы, например попытки аутентификации
+func (l *Limiter) Check(ctx context.Context, r *Rule, client string) (*Decision, error) {
+	window := r.Window.Duration
+	current := l.now().Truncate(window)
+
+	values, err := l.counters.Get(ctx, counterKey(r, client, current), counterKey(r, client, current.Add(-window)))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get rate limit counters")
+	}
+
+	used, previous := counter(values[0]), counter(values[1])
+
+	d := &Decision{Limit: r.Limit + min(r.Burst, max(0, r.Limit-previous)), Reset: current.Add(window)}
+	d.Allowed = used < d.Limit
+	d.Remaining = max(0, d.Limit-used)
+
+	return d, nil
+}
+
+// Count учитывает запрос клиента client по правилу r без проверки лимита
+func (l *Limiter) Count(ctx context.Context, r *Rule, client string) error {
+	_, err := l.count(ctx, r, client)
+	return err
+}
+
+func (l *Limiter) count(ctx context.Context, r *Rule, client string) (int64, error) {
+	window := r.Window.Duration
+
+	// счетчик нужен и в следующем окне, чтобы посчитать Burst
+	n, err := l.counters.Incr(ctx, counterKey(r, client, l.now().Truncate(window)), 2*window)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't increment rate limit counter")
+	}
+
+	return n, nil
+}
+
+func (r *Rule) matches(method string, path string, role string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+
+	if r.Path != "" && !matchPath(r.Path, path) {
+		return false
+	}
+
+	if len(r.Roles) == 0 {
+		return true
+	}
+
+	for _, rr := range r.Roles {
+		if rr == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPath(pattern string, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	xs := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, p := range ps {
+		if p == "*" && i == len(ps)-1 {
+			return true
+		}
+
+		if i >= len(xs) {
+			return false
+		}
+
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			continue
+		}
+
+		if p != xs[i] {
+			return false
+		}
+	}
+
+	return len(ps) == len(xs)
+}
+
+// counterKey - ключ счетчика запросов клиента по правилу в окне, которое начинается в момент window
+func counterKey(r *Rule, client string, window time.Time) string {
+	return fmt.Sprintf("ratelimit:%s:%s:%d", r.Name, client, window.Unix())
+}
+
+func counter(v []byte) int {
+	n, _ := strconv.Atoi(string(v))
+	return n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,153 @@
+package rating
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"time"
+)
+
+const (
+	MinScore = 1
+	MaxScore = 5
+	// MaxComment - наибольшая длина комментария в символах
+	MaxComment = 1000
+)
+
+type Configuration struct {
+	// Window - за какой период учитываются отзывы в скользящей оценке
+	Window ftime.Duration `json:"window"`
+	// MinRatings - сколько отзывов нужно, чтобы оценка курьера влияла на назначение заказов.
+	// Пока отзывов меньше, курьер считается средним
+	MinRatings int `json:"min_ratings"`
+	// Tags - теги, которые покупатель может выбрать в отзыве
+	Tags []string `json:"tags"`
+}
+
+var DefaultConfiguration = Configuration{
+	Window:     ftime.Duration{Duration: 30 * 24 * time.Hour},
+	MinRatings: 3,
+	Tags:       []string{"on_time", "late", "polite", "rude", "damaged", "careful"},
+}
+
+// Rating - отзыв покупателя о доставленном заказе. Pickup - место забора заказа
+type Rating struct {
+	OrderID   int64     `json:"order_id"`
+	CourierID int64     `json:"courier_id"`
+	Pickup    string    `json:"pickup"`
+	Score     int       `json:"score"`
+	Tags      []string  `json:"tags"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Aggregate - скользящая оценка курьера или места забора
+type Aggregate struct {
+	CourierID int64          `json:"courier_id,omitempty"`
+	Pickup    string         `json:"pickup,omitempty"`
+	Count     int            `json:"count"`
+	Average   float64        `json:"average"`
+	Tags      map[string]int `json:"tags"`
+}
+
+// ErrRated - у заказа уже есть отзыв
+var ErrRated = domain.Conflict("order is already rated")
+
+type Storage interface {
+	// Create сохраняет отзыв, второй отзыв на тот же заказ возвращает ErrRated
+	Create(ctx context.Context, r *Rating) error
+	// Couriers и Pickups возвращают оценки по отзывам, оставленным не раньше from
+	Couriers(ctx context.Context, from time.Time) ([]*Aggregate, error)
+	Pickups(ctx context.Context, from time.Time) ([]*Aggregate, error)
+}
+
+type Board struct {
+	config  Configuration
+	storage Storage
+}
+
+func New(c Configuration, s Storage) *Board {
+	return &Board{config: c, storage: s}
+}
+
+func (b *Board) Window() time.Duration {
+	return b.config.Window.Duration
+}
+
+// Validate проверяет оценку, теги и длину комментария отзыва
+func (b *Board) Validate(r *Rating) error {
+	if r.Score < MinScore || r.Score > MaxScore {
+		return fmt.Errorf("score must be from %d to %d", MinScore, MaxScore)
+	}
+
+	if len([]rune(r.Comment)) > MaxComment {
+		return fmt.Errorf("comment must be at most %d characters", MaxComment)
+	}
+
+	seen := make(map[string]bool, len(r.Tags))
+
+	for _, t := range r.Tags {
+		if !b.known(t) {
+			return fmt.Errorf("unknown tag %q", t)
+		}
+
+		if seen[t] {
+			return fmt.Errorf("tag %q is repeated", t)
+		}
+
+		seen[t] = true
+	}
+
+	return nil
+}
+
+func (b *Board) known(tag string) bool {
+	for _, t := range b.config.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add сохраняет отзыв, проверенный Validate
+func (b *Board) Add(ctx context.Context, r *Rating) error {
+	return b.storage.Create(ctx, r)
+}
+
+func (b *Board) Couriers(ctx context.Context, now time.Time) ([]*Aggregate, error) {
+	return b.storage.Couriers(ctx, now.Add(-b.config.Window.Duration))
+}
+
+func (b *Board) Pickups(ctx context.Context, now time.Time) ([]*Aggregate, error) {
+	return b.storage.Pickups(ctx, now.Add(-b.config.Window.Duration))
+}
+
+// Scores возвращает оценки курьеров от 0 до 1 для диспетчера. Курьеры, у которых
+// меньше MinRatings отзывов, в результат не попадают
+func (b *Board) Scores(ctx context.Context, now time.Time) (map[int64]float64, error) {
+	aa, err := b.Couriers(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[int64]float64, len(aa))
+
+	for _, a := range aa {
+		if a.Count < b.config.MinRatings {
+			continue
+		}
+
+		scores[a.CourierID] = (a.Average - MinScore) / (MaxScore - MinScore)
+	}
+
+	return scores, nil
+}
+
+// Round округляет среднюю оценку до сотых
+func Round(avg float64) float64 {
+	return math.Round(avg*100) / 100 // nolint: gomnd
+}
@@ -0,0 +1,238 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/kv"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Configuration struct {
+	// Addr - адрес сервера с протоколом Redis. Если адрес пустой, в процессе запускается
+	// встроенный сервер, и счетчики и кэш не общие для экземпляров сервиса
+	Addr string `json:"addr"`
+	// PoolSize - сколько соединений с сервером держит клиент
+	PoolSize int `json:"pool_size"`
+	// Timeout ограничивает время одной команды
+	Timeout ftime.Duration `json:"timeout"`
+}
+
+var DefaultConfiguration = Configuration{
+	PoolSize: 10,
+	Timeout:  ftime.Duration{Duration: time.Second},
+}
+
+var _ kv.Store = &Client{}
+
+// Client выполняет команды Redis по протоколу RESP. Соединения открываются при первом
+// обращении и возвращаются в пул, если команда выполнена без сетевых ошибок
+type Client struct {
+	addr    string
+	timeout time.Duration
+	pool    chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func NewClient(addr string, c Configuration) *Client {
+	return &Client{addr: addr, timeout: c.Timeout.Duration, pool: make(chan *conn, c.PoolSize)}
+}
+
+// Do выполняет команду и возвращает ответ в виде, описанном у readReply
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, err := c.pipeline(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+// pipeline отправляет команды одним пакетом и возвращает ответы на них.
+// Ответ сервера с ошибкой возвращается ошибкой
+func (c *Client) pipeline(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err = cn.SetDeadline(deadline); err != nil {
+		_ = cn.Close()
+		return nil, errors.Wrap(err, "can't set deadline")
+	}
+
+	for _, args := range cmds {
+		writeCommand(cn.w, args)
+	}
+
+	if err = cn.w.Flush(); err != nil {
+		_ = cn.Close()
+		return nil, errors.Wrap(err, "can't send commands")
+	}
+
+	replies := make([]interface{}, len(cmds))
+
+	for i := range replies {
+		if replies[i], err = readReply(cn.r); err != nil {
+			_ = cn.Close()
+			return nil, errors.Wrap(err, "can't read reply")
+		}
+	}
+
+	c.put(cn)
+
+	for i, r := range replies {
+		if e, ok := r.(Error); ok {
+			return nil, errors.Wrapf(e, "command %s failed", cmds[i][0])
+		}
+	}
+
+	return replies, nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: c.timeout}
+
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't connect to %s", c.addr)
+	}
+
+	return &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.pool <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.pool:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// multi выполняет команды атомарно в транзакции MULTI/EXEC и возвращает ответы на них
+func (c *Client) multi(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	tx := make([][]string, 0, len(cmds)+2)
+	tx = append(tx, []string{"MULTI"})
+	tx = append(tx, cmds...)
+	tx = append(tx, []string{"EXEC"})
+
+	replies, err := c.pipeline(ctx, tx...)
+	if err != nil {
+		return nil, err
+	}
+
+	results, ok := replies[len(replies)-1].([]interface{})
+	if !ok || len(results) != len(cmds) {
+		return nil, errProtocol
+	}
+
+	for i, r := range results {
+		if e, ok := r.(Error); ok {
+			return nil, errors.Wrapf(e, "command %s failed", cmds[i][0])
+		}
+	}
+
+	return results, nil
+}
+
+// Incr создает счетчик со сроком жизни командой SET NX и увеличивает его в одной транзакции,
+// поэтому счетчик не может истечь между SET и INCR и остаться без срока жизни,
+// а INCR существующего счетчика не продлевает его
+func (c *Client) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var (
+		reply interface{}
+		err   error
+	)
+
+	if ttl > 0 {
+		var results []interface{}
+
+		results, err = c.multi(ctx, []string{"SET", key, "0", "PX", millis(ttl), "NX"}, []string{"INCR", key})
+		if err == nil {
+			reply = results[1]
+		}
+	} else {
+		reply, err = c.Do(ctx, "INCR", key)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errProtocol
+	}
+
+	return n, nil
+}
+
+func (c *Client) Get(ctx context.Context, keys ...string) ([][]byte, error) {
+	reply, err := c.Do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(keys) {
+		return nil, errProtocol
+	}
+
+	out := make([][]byte, len(values))
+	for i, v := range values {
+		out[i], _ = v.([]byte)
+	}
+
+	return out, nil
+}
+
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", millis(ttl))
+	}
+
+	_, err := c.Do(ctx, args...)
+
+	return err
+}
+
+func (c *Client) Del(ctx context.Context, keys ...string) error {
+	_, err := c.Do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
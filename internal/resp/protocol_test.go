@@ -0,0 +1,93 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		expected interface{}
+	}{
+		{name: "simple string", in: "+OK\r\n", expected: "OK"},
+		{name: "error", in: "-ERR unknown command\r\n", expected: Error("ERR unknown command")},
+		{name: "integer", in: ":-42\r\n", expected: int64(-42)},
+		{name: "bulk string", in: "$5\r\nhe\r\no\r\n", expected: []byte("he\r\no")},
+		{name: "empty bulk string", in: "$0\r\n\r\n", expected: []byte{}},
+		{name: "nil bulk string", in: "$-1\r\n", expected: nil},
+		{name: "nil array", in: "*-1\r\n", expected: nil},
+		{
+			name:     "nested array",
+			in:       "*3\r\n:1\r\n$-1\r\n*1\r\n+QUEUED\r\n",
+			expected: []interface{}{int64(1), nil, []interface{}{"QUEUED"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := readReply(bufio.NewReader(strings.NewReader(tt.in)))
+			if err != nil {
+				t.Fatalf("readReply returned error %v", err)
+			}
+
+			if !reflect.DeepEqual(reply, tt.expected) {
+				t.Errorf("readReply returned %#v, want %#v", reply, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadReplyIncorrect(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "empty line", in: "\r\n"},
+		{name: "unknown type", in: "!1\r\n"},
+		{name: "line without CR", in: "+OK\n"},
+		{name: "incorrect integer", in: ":one\r\n"},
+		{name: "incorrect bulk length", in: "$-2\r\n"},
+		{name: "incorrect array length", in: "*x\r\n"},
+		{name: "truncated bulk string", in: "$5\r\nhel"},
+		{name: "truncated array", in: "*2\r\n:1\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reply, err := readReply(bufio.NewReader(strings.NewReader(tt.in))); err == nil {
+				t.Errorf("readReply returned %#v, want error", reply)
+			}
+		})
+	}
+}
+
+func TestWriteCommand(t *testing.T) {
+	var b bytes.Buffer
+
+	w := bufio.NewWriter(&b)
+	writeCommand(w, []string{"SET", "key", "a b\r\n"})
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush returned error %v", err)
+	}
+
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\na b\r\n\r\n"
+	if b.String() != expected {
+		t.Fatalf("writeCommand wrote %q, want %q", b.String(), expected)
+	}
+
+	req, err := readReply(bufio.NewReader(&b))
+	if err != nil {
+		t.Fatalf("readReply returned error %v", err)
+	}
+
+	args, ok := commandArgs(req)
+	if !ok || len(args) != 3 || string(args[2]) != "a b\r\n" {
+		t.Errorf("commandArgs returned %q, %v for written command", args, ok)
+	}
+}
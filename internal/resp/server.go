@@ -0,0 +1,303 @@
+package resp
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Server - встроенный сервер с протоколом Redis для локального запуска и тестов. Данные хранятся
+// в памяти процесса. Поддерживаются только команды, которые использует Client:
+// PING, GET, MGET, SET с PX, EX и NX, INCR, PEXPIRE, DEL и транзакции MULTI, EXEC и DISCARD
+type Server struct {
+	listener net.Listener
+	now      func() time.Time
+
+	mu   sync.Mutex
+	data map[string]entry
+}
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// tx - команды соединения, поставленные в очередь после MULTI. EXEC выполняет их
+// под одной блокировкой, и команды других соединений не выполняются между ними
+type tx struct {
+	queued [][][]byte
+}
+
+// StartServer начинает принимать соединения на addr, например, 127.0.0.1:0 для свободного порта
+func StartServer(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't listen %s", addr)
+	}
+
+	s := &Server{listener: l, now: time.Now, data: make(map[string]entry)}
+
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Close() error {
+	if err := s.listener.Close(); err != nil {
+		return errors.Wrap(err, "can't close resp server")
+	}
+
+	return nil
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer c.Close()
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+
+	var t *tx
+
+	for {
+		req, err := readReply(r)
+		if err != nil {
+			return
+		}
+
+		args, ok := commandArgs(req)
+		if !ok {
+			writeError(w, "ERR Protocol error: expected array of bulk strings")
+		} else {
+			t = s.command(w, t, args)
+		}
+
+		// клиент отправляет команды пакетом, поэтому ответы отправляются, когда прочитан весь пакет
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func commandArgs(req interface{}) ([][]byte, bool) {
+	values, ok := req.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, false
+	}
+
+	args := make([][]byte, len(values))
+
+	for i, v := range values {
+		if args[i], ok = v.([]byte); !ok {
+			return nil, false
+		}
+	}
+
+	return args, true
+}
+
+// command выполняет команду соединения с транзакцией t и возвращает транзакцию соединения после нее
+func (s *Server) command(w *bufio.Writer, t *tx, args [][]byte) *tx {
+	name := strings.ToUpper(string(args[0]))
+
+	switch {
+	case name == "MULTI" && len(args) == 1:
+		if t != nil {
+			writeError(w, "ERR MULTI calls can not be nested")
+			return t
+		}
+
+		writeSimple(w, "OK")
+
+		return &tx{}
+	case name == "EXEC" && len(args) == 1:
+		if t == nil {
+			writeError(w, "ERR EXEC without MULTI")
+			return nil
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		w.WriteString("*" + strconv.Itoa(len(t.queued)) + "\r\n")
+
+		for _, q := range t.queued {
+			s.exec(w, q)
+		}
+
+		return nil
+	case name == "DISCARD" && len(args) == 1:
+		if t == nil {
+			writeError(w, "ERR DISCARD without MULTI")
+			return nil
+		}
+
+		writeSimple(w, "OK")
+
+		return nil
+	case t != nil:
+		t.queued = append(t.queued, args)
+		writeSimple(w, "QUEUED")
+
+		return t
+	default:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.exec(w, args)
+
+		return nil
+	}
+}
+
+// exec выполняет команду, вызывающий держит s.mu
+func (s *Server) exec(w *bufio.Writer, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	switch {
+	case name == "PING":
+		writeSimple(w, "PONG")
+	case name == "GET" && len(args) == 1:
+		writeBulk(w, s.get(string(args[0])))
+	case name == "MGET" && len(args) > 0:
+		w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+
+		for _, key := range args {
+			writeBulk(w, s.get(string(key)))
+		}
+	case name == "SET" && len(args) >= 2:
+		s.set(w, args)
+	case name == "INCR" && len(args) == 1:
+		s.incr(w, string(args[0]))
+	case name == "PEXPIRE" && len(args) == 2:
+		s.pexpire(w, string(args[0]), string(args[1]))
+	case name == "DEL" && len(args) > 0:
+		var n int64
+
+		for _, key := range args {
+			if s.get(string(key)) != nil {
+				delete(s.data, string(key))
+				n++
+			}
+		}
+
+		writeInt(w, n)
+	default:
+		writeError(w, "ERR unknown command or wrong number of arguments for '"+name+"'")
+	}
+}
+
+// get возвращает значение ключа и удаляет его, если срок хранения истек
+func (s *Server) get(key string) []byte {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+
+	if !e.expiresAt.IsZero() && !s.now().Before(e.expiresAt) {
+		delete(s.data, key)
+		return nil
+	}
+
+	return e.value
+}
+
+func (s *Server) set(w *bufio.Writer, args [][]byte) {
+	key := string(args[0])
+	e := entry{value: append([]byte(nil), args[1]...)}
+	nx := false
+
+	for opts := args[2:]; len(opts) > 0; opts = opts[1:] {
+		switch opt := strings.ToUpper(string(opts[0])); {
+		case opt == "NX":
+			nx = true
+		case (opt == "PX" || opt == "EX") && len(opts) > 1:
+			n, err := strconv.ParseInt(string(opts[1]), 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+
+			unit := time.Millisecond
+			if opt == "EX" {
+				unit = time.Second
+			}
+
+			e.expiresAt = s.now().Add(time.Duration(n) * unit)
+			opts = opts[1:]
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+
+	if nx && s.get(key) != nil {
+		writeBulk(w, nil)
+		return
+	}
+
+	s.data[key] = e
+
+	writeSimple(w, "OK")
+}
+
+func (s *Server) incr(w *bufio.Writer, key string) {
+	var n int64
+
+	if v := s.get(key); v != nil {
+		var err error
+
+		if n, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	n++
+
+	e := s.data[key]
+	e.value = []byte(strconv.FormatInt(n, 10))
+	s.data[key] = e
+
+	writeInt(w, n)
+}
+
+func (s *Server) pexpire(w *bufio.Writer, key string, ms string) {
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		writeError(w, "ERR value is not an integer or out of range")
+		return
+	}
+
+	if s.get(key) == nil {
+		writeInt(w, 0)
+		return
+	}
+
+	e := s.data[key]
+	e.expiresAt = s.now().Add(time.Duration(n) * time.Millisecond)
+	s.data[key] = e
+
+	writeInt(w, 1)
+}
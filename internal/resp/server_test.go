@@ -0,0 +1,294 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newServer(t *testing.T) (*Server, *clock) {
+	t.Helper()
+
+	s, err := StartServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("StartServer returned error %v", err)
+	}
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	c := &clock{now: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s.now = c.Now
+
+	return s, c
+}
+
+func newClient(t *testing.T, s *Server) *Client {
+	t.Helper()
+
+	c := NewClient(s.Addr(), DefaultConfiguration)
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+// send отправляет команды в отдельном соединении и возвращает ответы на них, в том числе ошибки
+func send(t *testing.T, s *Server, cmds ...[]string) []interface{} {
+	t.Helper()
+
+	c, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatalf("Dial returned error %v", err)
+	}
+	defer c.Close()
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+
+	for _, args := range cmds {
+		writeCommand(w, args)
+	}
+
+	if err = w.Flush(); err != nil {
+		t.Fatalf("Flush returned error %v", err)
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		if replies[i], err = readReply(r); err != nil {
+			t.Fatalf("readReply returned error %v for command %v", err, cmds[i])
+		}
+	}
+
+	return replies
+}
+
+func TestServerCommands(t *testing.T) {
+	s, _ := newServer(t)
+
+	replies := send(t, s,
+		[]string{"PING"},
+		[]string{"SET", "a", "1"},
+		[]string{"SET", "a", "2", "NX"},
+		[]string{"INCR", "a"},
+		[]string{"INCR", "b"},
+		[]string{"MGET", "a", "b", "c"},
+		[]string{"DEL", "a", "c"},
+		[]string{"GET", "a"},
+		[]string{"SET", "c", "x"},
+		[]string{"INCR", "c"},
+		[]string{"UNKNOWN"},
+	)
+
+	expected := []interface{}{
+		"PONG",
+		"OK",
+		nil,
+		int64(2),
+		int64(1),
+		[]interface{}{[]byte("2"), []byte("1"), nil},
+		int64(1),
+		nil,
+		"OK",
+		Error("ERR value is not an integer or out of range"),
+		Error("ERR unknown command or wrong number of arguments for 'UNKNOWN'"),
+	}
+
+	if !reflect.DeepEqual(replies, expected) {
+		t.Errorf("server replied %#v, want %#v", replies, expected)
+	}
+}
+
+func TestServerExpire(t *testing.T) {
+	s, c := newServer(t)
+
+	replies := send(t, s,
+		[]string{"SET", "a", "1", "PX", "1000"},
+		[]string{"SET", "b", "1", "EX", "2"},
+		[]string{"SET", "c", "1"},
+		[]string{"PEXPIRE", "c", "1500"},
+		[]string{"SET", "d", "1", "PX", "0"},
+	)
+
+	expected := []interface{}{"OK", "OK", "OK", int64(1), Error("ERR invalid expire time in 'set' command")}
+	if !reflect.DeepEqual(replies, expected) {
+		t.Fatalf("server replied %#v, want %#v", replies, expected)
+	}
+
+	c.Add(time.Second)
+
+	replies = send(t, s, []string{"MGET", "a", "b", "c"}, []string{"SET", "a", "2", "NX"})
+	expected = []interface{}{[]interface{}{nil, []byte("1"), []byte("1")}, "OK"}
+
+	if !reflect.DeepEqual(replies, expected) {
+		t.Fatalf("server replied %#v after 1s, want %#v", replies, expected)
+	}
+
+	c.Add(time.Second)
+
+	replies = send(t, s, []string{"MGET", "a", "b", "c"}, []string{"PEXPIRE", "c", "1000"})
+	expected = []interface{}{[]interface{}{[]byte("2"), nil, nil}, int64(0)}
+
+	if !reflect.DeepEqual(replies, expected) {
+		t.Errorf("server replied %#v after 2s, want %#v", replies, expected)
+	}
+}
+
+func TestServerMulti(t *testing.T) {
+	s, _ := newServer(t)
+
+	tests := []struct {
+		name     string
+		cmds     [][]string
+		expected []interface{}
+	}{
+		{
+			name: "exec",
+			cmds: [][]string{
+				{"MULTI"}, {"SET", "a", "x", "NX"}, {"INCR", "a"}, {"INCR", "b"}, {"EXEC"}, {"GET", "b"},
+			},
+			expected: []interface{}{
+				"OK", "QUEUED", "QUEUED", "QUEUED",
+				[]interface{}{"OK", Error("ERR value is not an integer or out of range"), int64(1)},
+				[]byte("1"),
+			},
+		},
+		{
+			name:     "discard",
+			cmds:     [][]string{{"MULTI"}, {"INCR", "b"}, {"DISCARD"}, {"GET", "b"}},
+			expected: []interface{}{"OK", "QUEUED", "OK", []byte("1")},
+		},
+		{
+			name:     "empty",
+			cmds:     [][]string{{"MULTI"}, {"EXEC"}},
+			expected: []interface{}{"OK", []interface{}{}},
+		},
+		{
+			name: "nested",
+			cmds: [][]string{{"MULTI"}, {"MULTI"}, {"INCR", "b"}, {"EXEC"}},
+			expected: []interface{}{
+				"OK", Error("ERR MULTI calls can not be nested"), "QUEUED", []interface{}{int64(2)},
+			},
+		},
+		{
+			name: "without multi",
+			cmds: [][]string{{"EXEC"}, {"DISCARD"}},
+			expected: []interface{}{
+				Error("ERR EXEC without MULTI"), Error("ERR DISCARD without MULTI"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies := send(t, s, tt.cmds...)
+
+			if !reflect.DeepEqual(replies, tt.expected) {
+				t.Errorf("server replied %#v, want %#v", replies, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClientIncr(t *testing.T) {
+	s, c := newServer(t)
+	cl := newClient(t, s)
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		n, err := cl.Incr(ctx, "counter", time.Second)
+		if err != nil {
+			t.Fatalf("Incr returned error %v", err)
+		}
+
+		if n != i {
+			t.Fatalf("Incr returned %v, want %v", n, i)
+		}
+
+		c.Add(300 * time.Millisecond)
+	}
+
+	// INCR существующего счетчика не продлевает его, поэтому через секунду после создания он истекает
+	c.Add(100 * time.Millisecond)
+
+	n, err := cl.Incr(ctx, "counter", time.Second)
+	if err != nil {
+		t.Fatalf("Incr returned error %v", err)
+	}
+
+	if n != 1 {
+		t.Errorf("Incr returned %v for expired counter, want 1", n)
+	}
+
+	if err = cl.Set(ctx, "counter", []byte("x"), 0); err != nil {
+		t.Fatalf("Set returned error %v", err)
+	}
+
+	if _, err = cl.Incr(ctx, "counter", time.Second); err == nil {
+		t.Error("Incr returned no error for non-integer value")
+	}
+
+	if _, err = cl.Incr(ctx, "counter", 0); err == nil {
+		t.Error("Incr without ttl returned no error for non-integer value")
+	}
+}
+
+func TestClientIncrConcurrent(t *testing.T) {
+	s, _ := newServer(t)
+	cl := newClient(t, s)
+
+	const n = 50
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[int64]bool)
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			v, err := cl.Incr(context.Background(), "counter", time.Minute)
+			if err != nil {
+				t.Errorf("Incr returned error %v", err)
+				return
+			}
+
+			mu.Lock()
+			seen[v] = true
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	for i := int64(1); i <= n; i++ {
+		if !seen[i] {
+			t.Errorf("Incr didn't return %v", i)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"math"
+	"time"
+)
+
+// noLocationConfidence - уверенность в ETA, если курьер ни разу не сообщал местоположение
+const noLocationConfidence = 0.25
+
+// Estimate - ожидаемое время прибытия курьера к точке доставки заказа
+// и уверенность в нем от 0 до 1
+type Estimate struct {
+	At         time.Time
+	Confidence float64
+}
+
+// Estimate возвращает ETA заказа по маршруту, построенному в момент now. Уверенность
+// падает с возрастом последнего сообщения о местоположении locatedAt и с числом точек
+// маршрута до доставки. Если заказа нет в маршруте, ok равен false
+func (p *Planner) Estimate(r *Route, orderID int64, locatedAt *time.Time, now time.Time) (*Estimate, bool) {
+	for i, v := range r.Visits {
+		if v.OrderID != orderID || v.Kind != Dropoff {
+			continue
+		}
+
+		confidence := noLocationConfidence
+
+		if locatedAt != nil {
+			confidence = 1
+
+			if hl := p.config.PingHalfLife.Duration; hl > 0 && now.After(*locatedAt) {
+				confidence = math.Pow(0.5, float64(now.Sub(*locatedAt))/float64(hl)) // nolint: gomnd
+			}
+		}
+
+		confidence *= math.Pow(1-p.config.StopUncertainty, float64(i))
+
+		return &Estimate{
+			At:         v.Arrival,
+			Confidence: math.Round(confidence*100) / 100, // nolint: gomnd
+		}, true
+	}
+
+	return nil, false
+}
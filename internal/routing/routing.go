@@ -0,0 +1,269 @@
+package routing
+
+import (
+	"safedeal-backend-trainee/internal/courier"
+	"safedeal-backend-trainee/internal/ftime"
+	"safedeal-backend-trainee/internal/geo"
+	"time"
+)
+
+type Configuration struct {
+	// Speeds - средняя скорость курьера в км/ч для каждого типа транспорта
+	Speeds map[courier.Vehicle]float64 `json:"speeds"`
+	// StopTime - время, которое курьер проводит в каждой точке маршрута
+	StopTime ftime.Duration `json:"stop_time"`
+	// PingHalfLife - через какое время после последнего сообщения о местоположении
+	// уверенность в ETA падает вдвое
+	PingHalfLife ftime.Duration `json:"ping_half_life"`
+	// StopUncertainty - на какую долю падает уверенность в ETA с каждой точкой маршрута до заказа
+	StopUncertainty float64 `json:"stop_uncertainty"`
+}
+
+var DefaultConfiguration = Configuration{
+	Speeds: map[courier.Vehicle]float64{
+		courier.Foot: 5,
+		courier.Bike: 15,
+		courier.Car:  25,
+	},
+	StopTime:        ftime.Duration{Duration: 5 * time.Minute},
+	PingHalfLife:    ftime.Duration{Duration: 10 * time.Minute},
+	StopUncertainty: 0.05,
+}
+
+type Kind string
+
+const (
+	Pickup  Kind = "pickup"
+	Dropoff Kind = "dropoff"
+)
+
+// Stop - точка маршрута. Для точки без временного окна From и To не заданы
+type Stop struct {
+	OrderID int64
+	Kind    Kind
+	Address string
+	Point   geo.Point
+	From    time.Time
+	To      time.Time
+}
+
+type Visit struct {
+	*Stop
+	Arrival time.Time
+	Wait    time.Duration
+	Late    time.Duration
+}
+
+type Route struct {
+	Visits   []*Visit
+	Duration time.Duration
+	Late     time.Duration
+}
+
+// lateWeight - во сколько раз опоздание хуже лишнего времени в пути
+const lateWeight = 10
+
+func (r *Route) cost() time.Duration {
+	return r.Duration + lateWeight*r.Late
+}
+
+type Planner struct {
+	config Configuration
+}
+
+func New(c Configuration) *Planner {
+	return &Planner{config: c}
+}
+
+// Speed возвращает среднюю скорость для транспорта (если она не задана - скорость пешехода)
+func (p *Planner) Speed(v courier.Vehicle) float64 {
+	if s, ok := p.config.Speeds[v]; ok && s > 0 {
+		return s
+	}
+
+	return DefaultConfiguration.Speeds[courier.Foot]
+}
+
+func (p *Planner) StopTime() time.Duration {
+	return p.config.StopTime.Duration
+}
+
+// Plan строит маршрут из точки start с началом в момент at: сначала жадно
+// (ближайшая допустимая точка с учетом ожидания и опозданий), затем маршрут
+// улучшается перестановками 2-opt. Забор заказа всегда предшествует его доставке
+func (p *Planner) Plan(start geo.Point, at time.Time, v courier.Vehicle, stops []*Stop) *Route {
+	points := make([]geo.Point, 0, len(stops)+1)
+	points = append(points, start)
+
+	for _, s := range stops {
+		points = append(points, s.Point)
+	}
+
+	pl := &plan{
+		stops:  stops,
+		matrix: geo.TravelTimes(points, p.Speed(v)),
+		at:     at,
+		stop:   p.StopTime(),
+	}
+
+	return pl.route(pl.improve(pl.nearestNeighbour()))
+}
+
+type plan struct {
+	stops  []*Stop
+	matrix [][]time.Duration
+	at     time.Time
+	stop   time.Duration
+}
+
+// travel возвращает время в пути между точками маршрута (-1 - начальная точка)
+func (pl *plan) travel(from int, to int) time.Duration {
+	return pl.matrix[from+1][to+1]
+}
+
+// arrive возвращает время начала обслуживания точки i при выезде в момент t,
+// ожидание до открытия окна и опоздание
+func (pl *plan) arrive(from int, i int, t time.Time) (time.Time, time.Duration, time.Duration) {
+	s := pl.stops[i]
+	arrival := t.Add(pl.travel(from, i))
+
+	var wait, late time.Duration
+
+	if !s.From.IsZero() && arrival.Before(s.From) {
+		wait = s.From.Sub(arrival)
+		arrival = s.From
+	}
+
+	if !s.To.IsZero() && arrival.After(s.To) {
+		late = arrival.Sub(s.To)
+	}
+
+	return arrival, wait, late
+}
+
+func (pl *plan) nearestNeighbour() []int {
+	order := make([]int, 0, len(pl.stops))
+	visited := make([]bool, len(pl.stops))
+	cur, t := -1, pl.at
+
+	for len(order) < len(pl.stops) {
+		best, bestScore := -1, time.Duration(0)
+
+		for i := range pl.stops {
+			if visited[i] || !pl.ready(i, visited) {
+				continue
+			}
+
+			arrival, _, late := pl.arrive(cur, i, t)
+			score := arrival.Sub(t) + lateWeight*late
+
+			if best == -1 || score < bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		if best == -1 {
+			break
+		}
+
+		arrival, _, _ := pl.arrive(cur, best, t)
+		visited[best] = true
+		order = append(order, best)
+		cur, t = best, arrival.Add(pl.stop)
+	}
+
+	return order
+}
+
+// ready проверяет, что заказ уже забран, если точка - доставка
+func (pl *plan) ready(i int, visited []bool) bool {
+	if pl.stops[i].Kind != Dropoff {
+		return true
+	}
+
+	for j, s := range pl.stops {
+		if s.Kind == Pickup && s.OrderID == pl.stops[i].OrderID && !visited[j] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (pl *plan) valid(order []int) bool {
+	visited := make([]bool, len(pl.stops))
+
+	for _, i := range order {
+		if !pl.ready(i, visited) {
+			return false
+		}
+
+		visited[i] = true
+	}
+
+	return true
+}
+
+// improve применяет 2-opt: разворачивает участки маршрута, пока это уменьшает его стоимость
+func (pl *plan) improve(order []int) []int {
+	const maxPasses = 50
+
+	best := pl.route(order).cost()
+
+	for pass := 0; pass < maxPasses; pass++ {
+		improved := false
+
+		for i := 0; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				candidate := reverse(order, i, j)
+				if !pl.valid(candidate) {
+					continue
+				}
+
+				if c := pl.route(candidate).cost(); c < best {
+					order, best, improved = candidate, c, true
+				}
+			}
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	return order
+}
+
+func reverse(order []int, i int, j int) []int {
+	res := make([]int, len(order))
+	copy(res, order)
+
+	for ; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+
+	return res
+}
+
+func (pl *plan) route(order []int) *Route {
+	r := &Route{Visits: make([]*Visit, 0, len(order))}
+	cur, t := -1, pl.at
+
+	for _, i := range order {
+		arrival, wait, late := pl.arrive(cur, i, t)
+
+		r.Visits = append(r.Visits, &Visit{
+			Stop:    pl.stops[i],
+			Arrival: arrival,
+			Wait:    wait,
+			Late:    late,
+		})
+		r.Late += late
+
+		cur, t = i, arrival.Add(pl.stop)
+	}
+
+	r.Duration = t.Sub(pl.at)
+
+	return r
+}
@@ -0,0 +1,150 @@
+package shift
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"time"
+)
+
+type Configuration struct {
+	// EarlyClockIn - за сколько до начала смены курьер может на нее отметиться
+	EarlyClockIn ftime.Duration `json:"early_clock_in"`
+	// CoverageStep - длина интервала в отчете о покрытии зон
+	CoverageStep ftime.Duration `json:"coverage_step"`
+	// CoverageDays - на сколько дней вперед строится отчет о покрытии
+	CoverageDays int `json:"coverage_days"`
+}
+
+var DefaultConfiguration = Configuration{
+	EarlyClockIn: ftime.Duration{Duration: 30 * time.Minute},
+	CoverageStep: ftime.Duration{Duration: time.Hour},
+	CoverageDays: 7,
+}
+
+type Status string
+
+const (
+	Planned  Status = "planned"
+	Active   Status = "active"
+	OnBreak  Status = "on_break"
+	Finished Status = "finished"
+)
+
+type Break struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Shift - запланированная смена курьера. Во время смены курьер работает в зонах Zones
+// и может получать заказы, пока отметился на смене и не ушел на перерыв
+type Shift struct {
+	ID        int64      `json:"id"`
+	CourierID int64      `json:"courier_id"`
+	Zones     []string   `json:"zones"`
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	ClockIn   *time.Time `json:"clock_in,omitempty"`
+	ClockOut  *time.Time `json:"clock_out,omitempty"`
+	Breaks    []*Break   `json:"breaks,omitempty"`
+}
+
+func (s *Shift) Status() Status {
+	switch {
+	case s.ClockOut != nil:
+		return Finished
+	case s.ClockIn == nil:
+		return Planned
+	}
+
+	for _, b := range s.Breaks {
+		if b.End == nil {
+			return OnBreak
+		}
+	}
+
+	return Active
+}
+
+func (s *Shift) Covers(zone string) bool {
+	for _, z := range s.Zones {
+		if z == zone {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	ErrNoShift      = domain.Conflict("no shift to clock in")
+	ErrClockedIn    = domain.Conflict("already clocked in")
+	ErrNotClockedIn = domain.Conflict("not clocked in")
+	ErrOnBreak      = domain.Conflict("already on break")
+	ErrNotOnBreak   = domain.Conflict("not on break")
+)
+
+type Storage interface {
+	Create(ctx context.Context, s *Shift) error
+	// FindByID возвращает ошибку вида domain.ErrNotFound, если смены нет
+	FindByID(ctx context.Context, id int64) (*Shift, error)
+	// Current возвращает смену, на которой курьер отметился, или запланированную смену,
+	// на которую можно отметиться в момент at. Если смены нет, возвращается ErrNoShift
+	Current(ctx context.Context, courierID int64, at time.Time, early time.Duration) (*Shift, error)
+	// Upcoming возвращает смены курьера, которые заканчиваются после from
+	Upcoming(ctx context.Context, courierID int64, from time.Time) ([]*Shift, error)
+	// Planned возвращает смены, пересекающиеся с интервалом [from, to)
+	Planned(ctx context.Context, from time.Time, to time.Time) ([]*Shift, error)
+	// ClockIn, ClockOut, StartBreak и EndBreak меняют смену атомарно и возвращают
+	// ошибку ErrClockedIn, ErrNotClockedIn, ErrOnBreak или ErrNotOnBreak,
+	// если смена не в подходящем состоянии
+	ClockIn(ctx context.Context, shiftID int64, at time.Time) error
+	ClockOut(ctx context.Context, shiftID int64, at time.Time) error
+	StartBreak(ctx context.Context, shiftID int64, at time.Time) error
+	EndBreak(ctx context.Context, shiftID int64, at time.Time) error
+}
+
+// Interval - число курьеров, чьи смены в зоне пересекаются с интервалом
+type Interval struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Couriers int       `json:"couriers"`
+}
+
+// Coverage нарезает рабочие часы календаря cal на интервалы длиной step на days дней,
+// начиная с дня now, и считает в каждом интервале курьеров с запланированной сменой в зоне.
+// Курьер с несколькими сменами в интервале считается один раз
+func Coverage(cal *ftime.Calendar, now time.Time, days int, step time.Duration, zone string, shifts []*Shift) []*Interval {
+	now = cal.In(now)
+	intervals := make([]*Interval, 0)
+
+	if step <= 0 {
+		return intervals
+	}
+
+	for d := 0; d < days; d++ {
+		opens, closes, ok := cal.WorkingHours(now.AddDate(0, 0, d))
+		if !ok {
+			continue
+		}
+
+		for from := opens; from.Before(closes); from = from.Add(step) {
+			to := from.Add(step)
+			if to.After(closes) {
+				to = closes
+			}
+
+			couriers := make(map[int64]bool)
+
+			for _, s := range shifts {
+				if s.Covers(zone) && s.Start.Before(to) && s.End.After(from) {
+					couriers[s.CourierID] = true
+				}
+			}
+
+			intervals = append(intervals, &Interval{From: from, To: to, Couriers: len(couriers)})
+		}
+	}
+
+	return intervals
+}
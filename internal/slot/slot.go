@@ -0,0 +1,147 @@
+package slot
+
+import (
+	"context"
+	"safedeal-backend-trainee/internal/domain"
+	"safedeal-backend-trainee/internal/ftime"
+	"time"
+)
+
+type Configuration struct {
+	// Length - длина интервала доставки, интервалы нарезаются в рабочих часах календаря
+	Length ftime.Duration `json:"length"`
+	// Capacity - максимальное число заказов в интервале для одной зоны
+	Capacity int `json:"capacity"`
+	// DaysAhead - на сколько дней вперед можно выбрать интервал
+	DaysAhead int `json:"days_ahead"`
+}
+
+var DefaultConfiguration = Configuration{
+	Length:    ftime.Duration{Duration: 2 * time.Hour},
+	Capacity:  10,
+	DaysAhead: 7,
+}
+
+type Slot struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Capacity  int       `json:"capacity"`
+	Available int       `json:"available"`
+}
+
+func (s *Slot) Contains(t time.Time) bool {
+	return !t.Before(s.From) && t.Before(s.To)
+}
+
+// Reservation - число заказов, занявших интервал, начинающийся в Start
+type Reservation struct {
+	Start    time.Time
+	Reserved int
+}
+
+type Storage interface {
+	// Reservations возвращает занятость интервалов зоны, начинающихся в [from, to)
+	Reservations(ctx context.Context, zone string, from time.Time, to time.Time) ([]*Reservation, error)
+	// Reserve атомарно занимает место в интервале или возвращает ErrFull.
+	// Если ctx выполняется в UnitOfWork, место освобождается при откате транзакции
+	Reserve(ctx context.Context, zone string, start time.Time, capacity int) error
+}
+
+var (
+	ErrFull    = domain.Conflict("delivery slot is full")
+	ErrUnknown = domain.Invalid("delivery slot does not exist")
+	ErrPast    = domain.Invalid("delivery slot has already started")
+)
+
+type Schedule struct {
+	config Configuration
+}
+
+func New(c Configuration) *Schedule {
+	return &Schedule{config: c}
+}
+
+func (s *Schedule) Capacity() int {
+	return s.config.Capacity
+}
+
+// Slots возвращает все интервалы по календарю cal, которые начинаются после now
+func (s *Schedule) Slots(cal *ftime.Calendar, now time.Time) []*Slot {
+	now = cal.In(now)
+	slots := make([]*Slot, 0)
+
+	for d := 0; d < s.config.DaysAhead; d++ {
+		day := now.AddDate(0, 0, d)
+
+		for _, sl := range s.daySlots(cal, day) {
+			if sl.From.After(now) {
+				slots = append(slots, sl)
+			}
+		}
+	}
+
+	return slots
+}
+
+// daySlots нарезает рабочие часы дня на интервалы, в выходные и праздники интервалов нет
+func (s *Schedule) daySlots(cal *ftime.Calendar, day time.Time) []*Slot {
+	slots := make([]*Slot, 0)
+	length := s.config.Length.Duration
+
+	opens, closes, ok := cal.WorkingHours(day)
+	if !ok || length <= 0 {
+		return slots
+	}
+
+	for from := opens; !from.Add(length).After(closes); from = from.Add(length) {
+		slots = append(slots, &Slot{
+			From:      from,
+			To:        from.Add(length),
+			Capacity:  s.config.Capacity,
+			Available: s.config.Capacity,
+		})
+	}
+
+	return slots
+}
+
+// Find возвращает интервал по календарю cal, начинающийся в start
+func (s *Schedule) Find(cal *ftime.Calendar, start time.Time, now time.Time) (*Slot, error) {
+	if err := cal.Check(start); err != nil {
+		return nil, err
+	}
+
+	for _, sl := range s.daySlots(cal, start) {
+		if !sl.From.Equal(start) {
+			continue
+		}
+
+		if !sl.From.After(now) {
+			return nil, ErrPast
+		}
+
+		if sl.From.After(now.AddDate(0, 0, s.config.DaysAhead)) {
+			return nil, ErrUnknown
+		}
+
+		return sl, nil
+	}
+
+	return nil, ErrUnknown
+}
+
+// Fill уменьшает число свободных мест в интервалах на число занятых
+func Fill(slots []*Slot, rr []*Reservation) {
+	for _, r := range rr {
+		for _, sl := range slots {
+			if !sl.From.Equal(r.Start) {
+				continue
+			}
+
+			sl.Available = sl.Capacity - r.Reserved
+			if sl.Available < 0 {
+				sl.Available = 0
+			}
+		}
+	}
+}
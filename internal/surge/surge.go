@@ -0,0 +1,100 @@
+package surge
+
+import (
+	"context"
+	"math"
+	"safedeal-backend-trainee/internal/ftime"
+	"time"
+)
+
+type Configuration struct {
+	// Cap - максимальный повышающий коэффициент
+	Cap float64 `json:"cap"`
+	// Threshold - число открытых заказов на одного курьера, до которого цена не повышается
+	Threshold float64 `json:"threshold"`
+	// Sensitivity - прирост коэффициента на каждый заказ на курьера сверх Threshold
+	Sensitivity float64 `json:"sensitivity"`
+	// Bucket - длина временного интервала, в котором считается спрос
+	Bucket ftime.Duration `json:"bucket"`
+}
+
+var DefaultConfiguration = Configuration{
+	Cap:         2,
+	Threshold:   1,
+	Sensitivity: 0.25,
+	Bucket:      ftime.Duration{Duration: time.Hour},
+}
+
+const NoSurge = 1.0
+
+type Mode string
+
+const (
+	// ModeOverride - коэффициент задан администратором
+	ModeOverride Mode = "override"
+	// ModeFreeze - коэффициент зафиксирован на значении, рассчитанном в момент заморозки
+	ModeFreeze Mode = "freeze"
+)
+
+type Override struct {
+	Zone       string     `json:"zone"`
+	Mode       Mode       `json:"mode"`
+	Multiplier float64    `json:"multiplier"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (o *Override) Active(at time.Time) bool {
+	return o.ExpiresAt == nil || at.Before(*o.ExpiresAt)
+}
+
+type Storage interface {
+	// FindOverride возвращает ошибку вида domain.ErrNotFound, если для зоны нет переопределения
+	FindOverride(ctx context.Context, zone string) (*Override, error)
+	SaveOverride(ctx context.Context, o *Override) error
+	DeleteOverride(ctx context.Context, zone string) error
+}
+
+type Calculator struct {
+	config Configuration
+}
+
+func New(c Configuration) *Calculator {
+	return &Calculator{config: c}
+}
+
+// Bucket возвращает границы временного интервала, в который попадает at
+func (c *Calculator) Bucket(at time.Time) (time.Time, time.Time) {
+	from := at.Truncate(c.config.Bucket.Duration)
+	return from, from.Add(c.config.Bucket.Duration)
+}
+
+// Multiplier рассчитывает коэффициент по числу открытых заказов и свободных курьеров
+// (коэффициент округляется до сотых)
+func (c *Calculator) Multiplier(orders int, couriers int) float64 {
+	if orders == 0 {
+		return NoSurge
+	}
+
+	if couriers == 0 {
+		return c.cap()
+	}
+
+	ratio := float64(orders) / float64(couriers)
+	if ratio <= c.config.Threshold {
+		return NoSurge
+	}
+
+	m := NoSurge + (ratio-c.config.Threshold)*c.config.Sensitivity
+
+	return math.Round(math.Min(m, c.cap())*100) / 100 // nolint: gomnd
+}
+
+// Limit ограничивает коэффициент, заданный администратором
+func (c *Calculator) Limit(m float64) float64 {
+	return math.Max(NoSurge, math.Min(m, c.cap()))
+}
+
+func (c *Calculator) cap() float64 {
+	return math.Max(NoSurge, c.config.Cap)
+}
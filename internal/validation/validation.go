@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Правила, которые нарушают поля запроса. Клиент может выбирать обработку по ним, поэтому они не меняются
+const (
+	RuleRequired  = "required"
+	RuleMaxLength = "max_length"
+)
+
+// Violation - нарушение правила rule в поле field. Field - имя поля в JSON запроса
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors - все нарушения в запросе, чтобы клиент исправил их за один раз
+type Errors []Violation
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Message
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Validator проверяет поля запроса и собирает нарушения. Для каждого поля сообщается только
+// первое нарушение, следующие проверки поля пропускаются
+type Validator struct {
+	errs Errors
+}
+
+// Check добавляет нарушение rule в поле field, если ok ложно
+func (v *Validator) Check(ok bool, field string, rule string, message string) {
+	if ok || v.invalid(field) {
+		return
+	}
+
+	v.errs = append(v.errs, Violation{Field: field, Rule: rule, Message: message})
+}
+
+// Required проверяет, что строка не пустая и состоит не только из пробелов
+func (v *Validator) Required(field string, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, RuleRequired, field+" is required")
+}
+
+// RequiredTime проверяет, что время указано
+func (v *Validator) RequiredTime(field string, value time.Time) {
+	v.Check(!value.IsZero(), field, RuleRequired, field+" is required")
+}
+
+// MaxLength проверяет длину строки в символах, как ее считает VARCHAR в БД
+func (v *Validator) MaxLength(field string, value string, max int) {
+	v.Check(utf8.RuneCountInString(value) <= max, field, RuleMaxLength,
+		fmt.Sprintf("%s must be at most %d characters", field, max))
+}
+
+// Err возвращает Errors, если есть нарушения, иначе nil
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+func (v *Validator) invalid(field string) bool {
+	for _, e := range v.errs {
+		if e.Field == field {
+			return true
+		}
+	}
+
+	return false
+}